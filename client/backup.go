@@ -0,0 +1,57 @@
+package main
+
+import (
+	"context"
+	"io"
+	"log"
+	"os"
+	"time"
+
+	pb "example.com/grpc-go"
+	"google.golang.org/grpc"
+)
+
+// backup saves an archive of the server's state to -out, or to a file named
+// after the current time. The archive is only put in place once it was
+// received in full.
+func backup(conn *grpc.ClientConn) {
+	dst := outFile("backup-" + time.Now().Format("20060102-150405") + ".tar.gz")
+	part := dst + ".part"
+	f, err := os.OpenFile(part, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0600)
+	if err != nil {
+		log.Fatalf("could not create %s: %v", part, err)
+	}
+	fail := func(format string, v ...interface{}) {
+		f.Close()
+		os.Remove(part)
+		log.Fatalf(format, v...)
+	}
+	stream, err := pb.NewAdminServiceClient(conn).Backup(context.Background(), &pb.BackupRequest{})
+	if err != nil {
+		fail("could not back up: %v", err)
+	}
+	var n int64
+	for {
+		chunk, err := stream.Recv()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			fail("backup failed after %d bytes: %v", n, err)
+		}
+		if _, err := f.Write(chunk.GetData()); err != nil {
+			fail("could not write %s: %v", part, err)
+		}
+		n += int64(len(chunk.GetData()))
+	}
+	if err := f.Sync(); err != nil {
+		fail("could not write %s: %v", part, err)
+	}
+	if err := f.Close(); err != nil {
+		fail("could not write %s: %v", part, err)
+	}
+	if err := os.Rename(part, dst); err != nil {
+		log.Fatalf("could not move %s into place: %v", part, err)
+	}
+	log.Printf("saved a backup of %d bytes to %s", n, dst)
+}
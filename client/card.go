@@ -0,0 +1,53 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"log"
+	"os"
+	"time"
+
+	pb "example.com/grpc-go"
+)
+
+// card renders a welcome card for -name and writes it to -out, or to
+// card.png.
+func card(c pb.WelcomeServiceClient) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	stream, err := c.RenderWelcomeCard(ctx, &pb.RenderWelcomeCardRequest{
+		Name:       *name,
+		Template:   *cardTemplate,
+		Background: *cardBackground,
+	})
+	if err != nil {
+		log.Fatalf("could not render card: %v", err)
+	}
+	var (
+		buf  bytes.Buffer
+		size int64
+		etag string
+	)
+	for {
+		chunk, err := stream.Recv()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			log.Fatalf("could not render card: %v", err)
+		}
+		if buf.Len() == 0 {
+			size, etag = chunk.GetTotalSize(), chunk.GetEtag()
+		}
+		buf.Write(chunk.GetData())
+	}
+	if int64(buf.Len()) != size {
+		log.Fatalf("card is %d bytes, expected %d", buf.Len(), size)
+	}
+	dst := outFile("card.png")
+	if err := os.WriteFile(dst, buf.Bytes(), 0644); err != nil {
+		log.Fatalf("could not write card: %v", err)
+	}
+	log.Printf("wrote %d byte card %s to %s", size, etag, dst)
+}
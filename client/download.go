@@ -0,0 +1,162 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path"
+	"time"
+
+	pb "example.com/grpc-go"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// downloadAttempts is how often download tries to resume a broken stream.
+const downloadAttempts = 5
+
+// download fetches -kit_file from the welcome kit into -out, or a file of
+// the same name in the current directory. Data goes to a .part file first,
+// which a later run resumes from, and the file is only put in place once
+// its SHA-256 digest matches the server's. If it does not, the file changed
+// on the server since the .part was started, or was corrupted on the way,
+// and the download starts over once.
+func download(c pb.WelcomeServiceClient) {
+	dst := outFile(path.Base(*kitFile))
+	part := dst + ".part"
+	f, err := os.OpenFile(part, os.O_CREATE|os.O_RDWR, 0644)
+	if err != nil {
+		log.Fatalf("could not open %s: %v", part, err)
+	}
+	defer f.Close()
+	offset, err := f.Seek(0, io.SeekEnd)
+	if err != nil {
+		log.Fatalf("could not read %s: %v", part, err)
+	}
+	if offset > 0 {
+		log.Printf("resuming %s at %d bytes", *kitFile, offset)
+	}
+	startOver := func() {
+		if err := f.Truncate(0); err != nil {
+			log.Fatalf("could not truncate %s: %v", part, err)
+		}
+		if _, err := f.Seek(0, io.SeekStart); err != nil {
+			log.Fatalf("could not truncate %s: %v", part, err)
+		}
+		offset = 0
+	}
+
+	var digest string
+	for restarted := false; ; restarted = true {
+		digest = downloadRest(c, f, part, &offset, startOver)
+		if _, err := f.Seek(0, io.SeekStart); err != nil {
+			log.Fatalf("could not read %s: %v", part, err)
+		}
+		h := sha256.New()
+		if _, err := io.Copy(h, f); err != nil {
+			log.Fatalf("could not read %s: %v", part, err)
+		}
+		got := hex.EncodeToString(h.Sum(nil))
+		if got == digest {
+			break
+		}
+		if restarted {
+			os.Remove(part)
+			log.Fatalf("%s is corrupt: SHA-256 %s, expected %s; removed it", part, got, digest)
+		}
+		log.Printf("%s has SHA-256 %s, expected %s; starting over", part, got, digest)
+		startOver()
+	}
+	if err := os.Rename(part, dst); err != nil {
+		log.Fatalf("could not move %s into place: %v", part, err)
+	}
+	log.Printf("downloaded %s to %s (%d bytes, SHA-256 %s)", *kitFile, dst, offset, digest)
+}
+
+// downloadRest downloads the file from *offset to its end into f, resuming
+// broken streams, and returns the digest the server sent. It calls
+// startOver if the file shrank below *offset.
+func downloadRest(c pb.WelcomeServiceClient, f *os.File, part string, offset *int64, startOver func()) string {
+	for attempt := 1; ; attempt++ {
+		digest, err := downloadFrom(c, f, offset)
+		if err == nil {
+			fmt.Fprintln(os.Stderr)
+			return digest
+		}
+		if status.Code(err) == codes.OutOfRange && *offset > 0 {
+			// The file shrank on the server since the .part was started, so
+			// what was downloaded is of another version.
+			fmt.Fprintln(os.Stderr)
+			log.Printf("%s no longer has %d bytes, starting over: %v", *kitFile, *offset, err)
+			startOver()
+			continue
+		}
+		switch status.Code(err) {
+		case codes.InvalidArgument, codes.NotFound, codes.OutOfRange, codes.PermissionDenied, codes.Unauthenticated:
+			if *offset == 0 {
+				os.Remove(part)
+			}
+			fmt.Fprintln(os.Stderr)
+			log.Fatalf("could not download %s: %v", *kitFile, err)
+		}
+		if attempt == downloadAttempts {
+			fmt.Fprintln(os.Stderr)
+			log.Fatalf("could not download %s after %d attempts: %v", *kitFile, attempt, err)
+		}
+		fmt.Fprintln(os.Stderr)
+		log.Printf("download interrupted at %d bytes, resuming: %v", *offset, err)
+		time.Sleep(time.Duration(attempt) * time.Second)
+	}
+}
+
+// downloadFrom streams the file from *offset to its end into f, advancing
+// *offset as data is written, and returns the digest from the trailer.
+func downloadFrom(c pb.WelcomeServiceClient, f *os.File, offset *int64) (string, error) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	var trailer metadata.MD
+	stream, err := c.DownloadWelcomeKit(ctx, &pb.DownloadWelcomeKitRequest{Path: *kitFile, Offset: *offset}, grpc.Trailer(&trailer))
+	if err != nil {
+		return "", err
+	}
+	var size int64
+	for {
+		chunk, err := stream.Recv()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return "", err
+		}
+		if chunk.GetTotalSize() > 0 {
+			size = chunk.GetTotalSize()
+		}
+		if chunk.GetOffset() != *offset {
+			return "", fmt.Errorf("chunk at offset %d, expected %d", chunk.GetOffset(), *offset)
+		}
+		if _, err := f.Write(chunk.GetData()); err != nil {
+			log.Fatalf("could not write: %v", err)
+		}
+		*offset += int64(len(chunk.GetData()))
+		printProgress(*offset, size)
+	}
+	digest := trailer.Get("x-content-sha256")
+	if len(digest) == 0 {
+		return "", errors.New("server sent no digest")
+	}
+	return digest[0], nil
+}
+
+func printProgress(done, total int64) {
+	if total <= 0 {
+		return
+	}
+	fmt.Fprintf(os.Stderr, "\r%d / %d bytes (%.1f%%)", done, total, 100*float64(done)/float64(total))
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+
+	pb "example.com/grpc-go"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+)
+
+// kitServer serves one welcome kit file in chunks of four bytes.
+type kitServer struct {
+	pb.UnimplementedWelcomeServiceServer
+	data []byte
+}
+
+func (s *kitServer) DownloadWelcomeKit(in *pb.DownloadWelcomeKitRequest, stream pb.WelcomeService_DownloadWelcomeKitServer) error {
+	for off := in.GetOffset(); off < int64(len(s.data)); off += 4 {
+		end := off + 4
+		if end > int64(len(s.data)) {
+			end = int64(len(s.data))
+		}
+		if err := stream.Send(&pb.WelcomeKitChunk{Offset: off, Data: s.data[off:end], TotalSize: int64(len(s.data))}); err != nil {
+			return err
+		}
+	}
+	sum := sha256.Sum256(s.data)
+	stream.SetTrailer(metadata.Pairs("x-content-sha256", hex.EncodeToString(sum[:])))
+	return nil
+}
+
+func TestDownloadStartsOverOnDigestMismatch(t *testing.T) {
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := grpc.NewServer()
+	data := []byte("the handbook, second edition")
+	pb.RegisterWelcomeServiceServer(s, &kitServer{data: data})
+	go s.Serve(lis)
+	defer s.Stop()
+	cc, err := grpc.Dial(lis.Addr().String(), grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cc.Close()
+
+	dst := filepath.Join(t.TempDir(), "handbook.txt")
+	defer func(kit, o string) { *kitFile, *out = kit, o }(*kitFile, *out)
+	*kitFile, *out = "handbook.txt", dst
+	// Left by a download of the first edition that was cut short.
+	if err := os.WriteFile(dst+".part", []byte("the handbook, first"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	download(pb.NewWelcomeServiceClient(cc))
+	got, err := os.ReadFile(dst)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != string(data) {
+		t.Errorf("downloaded %q, want %q", got, data)
+	}
+	if _, err := os.Stat(dst + ".part"); !os.IsNotExist(err) {
+		t.Errorf("%s.part is left behind: %v", dst, err)
+	}
+}
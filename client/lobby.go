@@ -0,0 +1,34 @@
+package main
+
+import (
+	"context"
+	"io"
+	"log"
+	"time"
+
+	pb "example.com/grpc-go"
+	"google.golang.org/grpc"
+)
+
+// lobby enters the lobby of -cohort_id as -member_id and prints its events
+// until the stream ends.
+func lobby(conn *grpc.ClientConn) {
+	stream, err := pb.NewCohortServiceClient(conn).JoinLobby(context.Background(), &pb.JoinLobbyRequest{CohortId: *cohortID, MemberId: *memberID})
+	if err != nil {
+		log.Fatalf("could not join lobby: %v", err)
+	}
+	for {
+		ev, err := stream.Recv()
+		if err == io.EOF {
+			return
+		}
+		if err != nil {
+			log.Fatalf("lobby stream failed: %v", err)
+		}
+		at := ev.GetSentAt().AsTime().Local().Format(time.Kitchen)
+		if ev.GetBacklog() {
+			at += ", earlier"
+		}
+		log.Printf("#%d %s (%s)", ev.GetSeq(), ev.GetMessage(), at)
+	}
+}
@@ -16,20 +16,98 @@ const (
 )
 
 var (
-	addr = flag.String("addr", "localhost:50051", "the address to connect to")
-	name = flag.String("name", defaultName, "Name to greet")
+	addr        = flag.String("addr", "localhost:50051", "the address to connect to")
+	name        = flag.String("name", defaultName, "Name to greet")
+	resumeAfter = flag.Uint64("resume_after", 0, "With the subscribe command, the sequence number to resume after")
+	resumeEpoch = flag.Uint64("resume_epoch", 0, "With the subscribe command, the epoch of -resume_after, as logged by an earlier subscribe; resuming fails if the server restarted since")
+	replayLog   = flag.String("log", "", "With the replay command, the binary log recorded by the server")
+	replaySpeed = flag.Float64("speed", 1, "With the replay command, how much faster than recorded to replay; 0 sends calls one at a time, back to back")
+	apiKey      = flag.String("api_key", "", "API key to authenticate calls with")
+	out         = flag.String("out", "", "With the card, download and backup commands, the file to write; if empty, the command's default: card.png, the name of the kit file or a timestamped backup name")
+	memberID    = flag.String("member_id", "", "With the presence and lobby commands, the member to keep online or to join as")
+	follows     = flag.String("follow", "", "With the presence command, comma-separated IDs of members whose presence to show")
+	cohortID    = flag.String("cohort_id", "", "With the lobby command, the cohort whose lobby to join")
+	kitFile     = flag.String("kit_file", "", "With the download command, the welcome kit file to download, such as handbook.pdf")
+
+	cardTemplate   = flag.String("card_template", "", "With the card command, the layout template; the server's default if empty")
+	cardBackground = flag.String("card_background", "", "With the card command, the background color as #rrggbb; the template's if empty")
+
+	tokenURL         = flag.String("token_url", "", "If set, authenticate calls with access tokens from this OAuth2 token endpoint")
+	clientID         = flag.String("client_id", "", "With -token_url, the OAuth2 client ID")
+	clientSecretFile = flag.String("client_secret_file", "", "With -token_url, the file holding the OAuth2 client secret")
+	scopes           = flag.String("scopes", "", "With -token_url, the comma-separated scopes to request")
+	plaintextTokens  = flag.Bool("plaintext_tokens", false, "With -token_url, allow sending access tokens without -tls, such as to a server on localhost")
+
+	useTLS             = flag.Bool("tls", false, "Connect using TLS")
+	caFile             = flag.String("ca_file", "", "With -tls, the CA certificates to verify the server with; the system pool if empty")
+	certFile           = flag.String("cert_file", "", "With -tls, the client certificate file for mutual TLS")
+	keyFile            = flag.String("key_file", "", "With -tls, the client private key file for mutual TLS")
+	serverHostOverride = flag.String("server_host_override", "", "With -tls, the name to verify the server certificate against, if not the host in -addr")
 )
 
+// apiKeyCredentials sends an API key as a bearer token with every call.
+type apiKeyCredentials string
+
+func (k apiKeyCredentials) GetRequestMetadata(ctx context.Context, uri ...string) (map[string]string, error) {
+	return map[string]string{"authorization": "Bearer " + string(k)}, nil
+}
+
+// RequireTransportSecurity allows keys over plaintext connections, used when
+// -tls is not set.
+func (k apiKeyCredentials) RequireTransportSecurity() bool { return false }
+
 func main() {
 	flag.Parse()
 	// Set up a connection to the server.
-	conn, err := grpc.Dial(*addr, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	opts := []grpc.DialOption{grpc.WithTransportCredentials(insecure.NewCredentials())}
+	if *useTLS {
+		creds, err := clientTLSCredentials(*caFile, *certFile, *keyFile, *serverHostOverride)
+		if err != nil {
+			log.Fatalf("could not load TLS configuration: %v", err)
+		}
+		opts[0] = grpc.WithTransportCredentials(creds)
+	}
+	if *apiKey != "" {
+		opts = append(opts, grpc.WithPerRPCCredentials(apiKeyCredentials(*apiKey)))
+	}
+	if *tokenURL != "" {
+		creds, err := newOAuthCredentials(*tokenURL, *clientID, *clientSecretFile, *scopes, *plaintextTokens)
+		if err != nil {
+			log.Fatalf("could not read client secret: %v", err)
+		}
+		opts = append(opts, grpc.WithPerRPCCredentials(creds))
+	}
+	conn, err := grpc.Dial(*addr, opts...)
 	if err != nil {
 		log.Fatalf("did not connect: %v", err)
 	}
 	defer conn.Close()
 	c := pb.NewWelcomeServiceClient(conn)
 
+	switch flag.Arg(0) {
+	case "subscribe":
+		subscribe(c)
+		return
+	case "replay":
+		replay(conn)
+		return
+	case "card":
+		card(c)
+		return
+	case "download":
+		download(c)
+		return
+	case "presence":
+		presence(conn)
+		return
+	case "lobby":
+		lobby(conn)
+		return
+	case "backup":
+		backup(conn)
+		return
+	}
+
 	// Contact the server and print out its response.
 	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
 	defer cancel()
@@ -39,3 +117,12 @@ func main() {
 	}
 	log.Printf("Greeting: %s", r.GetMessage())
 }
+
+// outFile returns -out, or def if it is empty. Every command that writes a
+// file has its own default, so -out has none.
+func outFile(def string) string {
+	if *out == "" {
+		return def
+	}
+	return *out
+}
@@ -0,0 +1,179 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// oauthCredentials obtains access tokens with the OAuth2 client credentials
+// grant (RFC 6749, section 4.4) and sends them as bearer tokens. A token is
+// refreshed in the background shortly before it expires, so calls only wait
+// for the token endpoint when there is no usable token at all. Only one
+// request to the token endpoint is made at a time; calls that need a token
+// meanwhile wait for it.
+type oauthCredentials struct {
+	tokenURL     string
+	clientID     string
+	clientSecret string
+	scopes       []string
+	httpClient   *http.Client
+	// allowPlaintext permits sending tokens without transport security.
+	allowPlaintext bool
+
+	mu        sync.Mutex
+	token     string
+	expiry    time.Time
+	refreshAt time.Time
+	// fetching is closed when the request to the token endpoint in progress
+	// ends, and is nil if there is none.
+	fetching chan struct{}
+	err      error // of the last request
+}
+
+func newOAuthCredentials(tokenURL, clientID, secretFile, scopes string, allowPlaintext bool) (*oauthCredentials, error) {
+	secret, err := os.ReadFile(secretFile)
+	if err != nil {
+		return nil, err
+	}
+	return &oauthCredentials{
+		tokenURL:     tokenURL,
+		clientID:     clientID,
+		clientSecret: strings.TrimSpace(string(secret)),
+		scopes:       strings.FieldsFunc(scopes, func(r rune) bool { return r == ',' || r == ' ' }),
+		httpClient:   &http.Client{Timeout: 10 * time.Second},
+
+		allowPlaintext: allowPlaintext,
+	}, nil
+}
+
+func (c *oauthCredentials) GetRequestMetadata(ctx context.Context, uri ...string) (map[string]string, error) {
+	tok, err := c.get(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return map[string]string{"authorization": "Bearer " + tok}, nil
+}
+
+// RequireTransportSecurity keeps tokens off plaintext connections unless
+// -plaintext_tokens is set.
+func (c *oauthCredentials) RequireTransportSecurity() bool { return !c.allowPlaintext }
+
+// get returns a valid token, fetching one if there is none.
+func (c *oauthCredentials) get(ctx context.Context) (string, error) {
+	c.mu.Lock()
+	now := time.Now()
+	if c.token != "" && now.Before(c.expiry) {
+		tok := c.token
+		if !now.Before(c.refreshAt) && c.fetching == nil {
+			c.refresh()
+		}
+		c.mu.Unlock()
+		return tok, nil
+	}
+	if c.fetching == nil {
+		c.refresh()
+	}
+	fetching := c.fetching
+	c.mu.Unlock()
+	select {
+	case <-fetching:
+	case <-ctx.Done():
+		return "", ctx.Err()
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.token == "" || !time.Now().Before(c.expiry) {
+		if c.err != nil {
+			return "", c.err
+		}
+		return "", errors.New("no valid access token")
+	}
+	return c.token, nil
+}
+
+// refresh starts fetching a new token, which is stored when it arrives. A
+// failed background refresh keeps the current token; the next call past
+// refreshAt tries again. c.mu must be held.
+func (c *oauthCredentials) refresh() {
+	c.fetching = make(chan struct{})
+	go func() {
+		tok, lifetime, err := c.fetch(context.Background())
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		defer func() {
+			close(c.fetching)
+			c.fetching = nil
+		}()
+		c.err = err
+		if err != nil {
+			log.Printf("failed to refresh access token: %v", err)
+			return
+		}
+		now := time.Now()
+		c.token = tok
+		c.expiry = now.Add(lifetime)
+		// Refresh a minute early, or halfway through short-lived tokens.
+		early := time.Minute
+		if lifetime/2 < early {
+			early = lifetime / 2
+		}
+		c.refreshAt = c.expiry.Add(-early)
+	}()
+}
+
+// tokenResponse is the token endpoint's answer, successful or not.
+type tokenResponse struct {
+	AccessToken      string `json:"access_token"`
+	TokenType        string `json:"token_type"`
+	ExpiresIn        int64  `json:"expires_in"`
+	Error            string `json:"error"`
+	ErrorDescription string `json:"error_description"`
+}
+
+func (c *oauthCredentials) fetch(ctx context.Context) (string, time.Duration, error) {
+	form := url.Values{"grant_type": {"client_credentials"}}
+	if len(c.scopes) > 0 {
+		form.Set("scope", strings.Join(c.scopes, " "))
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.tokenURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", 0, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	req.SetBasicAuth(url.QueryEscape(c.clientID), url.QueryEscape(c.clientSecret))
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return "", 0, err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return "", 0, err
+	}
+	var tr tokenResponse
+	if err := json.Unmarshal(body, &tr); err != nil {
+		return "", 0, fmt.Errorf("token endpoint returned %s: %v", resp.Status, err)
+	}
+	if resp.StatusCode != http.StatusOK || tr.Error != "" {
+		return "", 0, fmt.Errorf("token endpoint returned %s: %s %s", resp.Status, tr.Error, tr.ErrorDescription)
+	}
+	if tr.AccessToken == "" || !strings.EqualFold(tr.TokenType, "bearer") {
+		return "", 0, fmt.Errorf("token endpoint returned no bearer token")
+	}
+	lifetime := time.Duration(tr.ExpiresIn) * time.Second
+	if lifetime <= 0 {
+		lifetime = time.Hour // expires_in is only recommended
+	}
+	return tr.AccessToken, lifetime, nil
+}
@@ -0,0 +1,216 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// tokenServer is a token endpoint that hands out numbered tokens.
+type tokenServer struct {
+	*httptest.Server
+	requests  int32
+	expiresIn int64
+	// fail, if set, is returned as an OAuth2 error response.
+	fail string
+	// hold, if not nil, delays every response until it is closed.
+	hold chan struct{}
+}
+
+func newTokenServer(t *testing.T) *tokenServer {
+	ts := &tokenServer{expiresIn: 3600}
+	ts.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := atomic.AddInt32(&ts.requests, 1)
+		if ts.hold != nil {
+			<-ts.hold
+		}
+		w.Header().Set("Content-Type", "application/json")
+		id, secret, ok := r.BasicAuth()
+		switch {
+		case r.Method != http.MethodPost || r.FormValue("grant_type") != "client_credentials":
+			w.WriteHeader(http.StatusBadRequest)
+			fmt.Fprint(w, `{"error": "unsupported_grant_type"}`)
+		case !ok || id != "welcome" || secret != "s3cret":
+			w.WriteHeader(http.StatusUnauthorized)
+			fmt.Fprint(w, `{"error": "invalid_client", "error_description": "bad credentials"}`)
+		case ts.fail != "":
+			w.WriteHeader(http.StatusBadRequest)
+			fmt.Fprintf(w, `{"error": %q}`, ts.fail)
+		default:
+			fmt.Fprintf(w, `{"access_token": "token-%d-%s", "token_type": "Bearer", "expires_in": %d}`, n, r.FormValue("scope"), ts.expiresIn)
+		}
+	}))
+	t.Cleanup(ts.Close)
+	return ts
+}
+
+func newTestCredentials(t *testing.T, tokenURL, secret string) *oauthCredentials {
+	path := filepath.Join(t.TempDir(), "secret")
+	if err := os.WriteFile(path, []byte(secret+"\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	c, err := newOAuthCredentials(tokenURL, "welcome", path, "read, write", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+func TestOAuthFetchAndCache(t *testing.T) {
+	ts := newTokenServer(t)
+	c := newTestCredentials(t, ts.URL, "s3cret")
+	for i := 0; i < 3; i++ {
+		md, err := c.GetRequestMetadata(context.Background())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got, want := md["authorization"], "Bearer token-1-read write"; got != want {
+			t.Errorf("call %d sent %q, want %q", i, got, want)
+		}
+	}
+	if n := atomic.LoadInt32(&ts.requests); n != 1 {
+		t.Errorf("made %d token requests, want 1", n)
+	}
+}
+
+func TestOAuthRefreshBeforeExpiry(t *testing.T) {
+	ts := newTokenServer(t)
+	c := newTestCredentials(t, ts.URL, "s3cret")
+	if _, err := c.get(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	c.mu.Lock()
+	if early := c.expiry.Sub(c.refreshAt); early != time.Minute {
+		t.Errorf("refreshes %v before expiry, want 1m", early)
+	}
+	c.refreshAt = time.Now().Add(-time.Second)
+	c.mu.Unlock()
+
+	// The current token is still used while the new one is fetched.
+	tok, err := c.get(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(tok, "token-1-") {
+		t.Errorf("got %q while refreshing, want the first token", tok)
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for !strings.HasPrefix(tok, "token-2-") {
+		if time.Now().After(deadline) {
+			t.Fatalf("still %q after refreshing", tok)
+		}
+		time.Sleep(10 * time.Millisecond)
+		if tok, err = c.get(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestOAuthShortLivedToken(t *testing.T) {
+	ts := newTokenServer(t)
+	ts.expiresIn = 20
+	c := newTestCredentials(t, ts.URL, "s3cret")
+	if _, err := c.get(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if early := c.expiry.Sub(c.refreshAt); early != 10*time.Second {
+		t.Errorf("refreshes %v before expiry, want halfway through 20s", early)
+	}
+}
+
+func TestOAuthConcurrentFetch(t *testing.T) {
+	ts := newTokenServer(t)
+	ts.hold = make(chan struct{})
+	c := newTestCredentials(t, ts.URL, "s3cret")
+	var wg sync.WaitGroup
+	toks := make([]string, 10)
+	errs := make([]error, len(toks))
+	for i := range toks {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			toks[i], errs[i] = c.get(context.Background())
+		}(i)
+	}
+	time.Sleep(50 * time.Millisecond)
+	close(ts.hold)
+	wg.Wait()
+	for i := range toks {
+		if errs[i] != nil || toks[i] != toks[0] {
+			t.Errorf("call %d got %q, %v; want %q", i, toks[i], errs[i], toks[0])
+		}
+	}
+	if n := atomic.LoadInt32(&ts.requests); n != 1 {
+		t.Errorf("made %d token requests, want 1", n)
+	}
+}
+
+func TestOAuthErrors(t *testing.T) {
+	ts := newTokenServer(t)
+	c := newTestCredentials(t, ts.URL, "wrong")
+	_, err := c.get(context.Background())
+	if err == nil || !strings.Contains(err.Error(), "invalid_client") || !strings.Contains(err.Error(), "bad credentials") {
+		t.Errorf("wrong secret: got %v, want invalid_client", err)
+	}
+
+	ts.fail = "invalid_scope"
+	c = newTestCredentials(t, ts.URL, "s3cret")
+	if _, err := c.get(context.Background()); err == nil || !strings.Contains(err.Error(), "invalid_scope") {
+		t.Errorf("got %v, want invalid_scope", err)
+	}
+
+	// A failed refresh keeps the token that is still valid.
+	ts.fail = ""
+	if _, err := c.get(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	ts.fail = "temporarily_unavailable"
+	c.mu.Lock()
+	c.refreshAt = time.Now().Add(-time.Second)
+	c.mu.Unlock()
+	for i := 0; i < 3; i++ {
+		if tok, err := c.get(context.Background()); err != nil || tok == "" {
+			t.Errorf("got %q, %v during failed refreshes", tok, err)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	c = newTestCredentials(t, ts.URL+"/missing", "s3cret")
+	ts.Close()
+	if _, err := c.get(context.Background()); err == nil {
+		t.Error("got a token from a closed endpoint")
+	}
+}
+
+func TestOAuthCanceledWait(t *testing.T) {
+	ts := newTokenServer(t)
+	ts.hold = make(chan struct{})
+	defer close(ts.hold)
+	c := newTestCredentials(t, ts.URL, "s3cret")
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if _, err := c.get(ctx); err != context.DeadlineExceeded {
+		t.Errorf("got %v, want %v", err, context.DeadlineExceeded)
+	}
+}
+
+func TestOAuthTransportSecurity(t *testing.T) {
+	c := newTestCredentials(t, "http://localhost/token", "s3cret")
+	if !c.RequireTransportSecurity() {
+		t.Error("tokens allowed without TLS by default")
+	}
+	c.allowPlaintext = true
+	if c.RequireTransportSecurity() {
+		t.Error("tokens refused without TLS with -plaintext_tokens")
+	}
+}
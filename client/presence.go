@@ -0,0 +1,74 @@
+package main
+
+import (
+	"context"
+	"io"
+	"log"
+	"strings"
+	"time"
+
+	pb "example.com/grpc-go"
+	"google.golang.org/grpc"
+)
+
+// presence keeps -member_id online and prints the presence changes of the
+// members in -follow until the stream ends.
+func presence(conn *grpc.ClientConn) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	stream, err := pb.NewPresenceServiceClient(conn).Presence(ctx)
+	if err != nil {
+		log.Fatalf("could not open presence stream: %v", err)
+	}
+	var follow []string
+	if *follows != "" {
+		follow = strings.Split(*follows, ",")
+	}
+	if err := stream.Send(&pb.PresenceHeartbeat{MemberId: *memberID, Follow: follow}); err != nil {
+		log.Fatalf("could not send heartbeat: %v", err)
+	}
+	header, err := stream.Header()
+	if err != nil {
+		log.Fatalf("presence stream failed: %v", err)
+	}
+	ttl := 30 * time.Second
+	if v := header.Get("x-presence-ttl"); len(v) > 0 {
+		if d, err := time.ParseDuration(v[0]); err == nil && d > 0 {
+			ttl = d
+		}
+	}
+	// Three heartbeats per TTL, so that one late heartbeat does not count
+	// as going offline.
+	go func() {
+		t := time.NewTicker(ttl / 3)
+		defer t.Stop()
+		for {
+			select {
+			case <-t.C:
+				if err := stream.Send(&pb.PresenceHeartbeat{}); err != nil {
+					return
+				}
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	for {
+		c, err := stream.Recv()
+		if err == io.EOF {
+			return
+		}
+		if err != nil {
+			log.Fatalf("presence stream failed: %v", err)
+		}
+		state := "offline"
+		if c.GetOnline() {
+			state = "online"
+		}
+		since := "never seen"
+		if c.GetSince() != nil {
+			since = "since " + c.GetSince().AsTime().Local().Format(time.Kitchen)
+		}
+		log.Printf("%s is %s (%s)", c.GetMemberId(), state, since)
+	}
+}
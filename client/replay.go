@@ -0,0 +1,235 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	pb "example.com/grpc-go"
+	"google.golang.org/grpc"
+	binlogpb "google.golang.org/grpc/binarylog/grpc_binarylog_v1"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/reflect/protoregistry"
+)
+
+// recordedRPC is one call reassembled from a binary log.
+type recordedRPC struct {
+	id        uint64
+	method    string
+	start     time.Time
+	end       time.Time
+	md        metadata.MD
+	requests  [][]byte
+	responses [][]byte
+	code      codes.Code
+	message   string
+	complete  bool // the trailer was recorded
+}
+
+// readBinaryLog parses a file written by the server's -record_file option
+// and returns the calls in the order they started.
+func readBinaryLog(path string) ([]*recordedRPC, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	r := bufio.NewReader(f)
+	calls := make(map[uint64]*recordedRPC)
+	for {
+		var n uint32
+		if err := binary.Read(r, binary.BigEndian, &n); err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, err
+		}
+		buf := make([]byte, n)
+		if _, err := io.ReadFull(r, buf); err != nil {
+			return nil, fmt.Errorf("truncated entry: %v", err)
+		}
+		e := &binlogpb.GrpcLogEntry{}
+		if err := proto.Unmarshal(buf, e); err != nil {
+			return nil, err
+		}
+		c := calls[e.GetCallId()]
+		if c == nil {
+			c = &recordedRPC{id: e.GetCallId(), md: metadata.MD{}}
+			calls[e.GetCallId()] = c
+		}
+		switch e.GetType() {
+		case binlogpb.GrpcLogEntry_EVENT_TYPE_CLIENT_HEADER:
+			h := e.GetClientHeader()
+			c.method = h.GetMethodName()
+			c.start = e.GetTimestamp().AsTime()
+			for _, me := range h.GetMetadata().GetEntry() {
+				c.md.Append(me.GetKey(), string(me.GetValue()))
+			}
+		case binlogpb.GrpcLogEntry_EVENT_TYPE_CLIENT_MESSAGE:
+			c.requests = append(c.requests, e.GetMessage().GetData())
+		case binlogpb.GrpcLogEntry_EVENT_TYPE_SERVER_MESSAGE:
+			c.responses = append(c.responses, e.GetMessage().GetData())
+		case binlogpb.GrpcLogEntry_EVENT_TYPE_SERVER_TRAILER:
+			c.end = e.GetTimestamp().AsTime()
+			c.code = codes.Code(e.GetTrailer().GetStatusCode())
+			c.message = e.GetTrailer().GetStatusMessage()
+			c.complete = true
+		}
+	}
+	var out []*recordedRPC
+	for _, c := range calls {
+		if c.method != "" && c.complete {
+			out = append(out, c)
+		}
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].start.Before(out[j].start) })
+	return out, nil
+}
+
+// replayResult is what the target server returned for a recorded call.
+type replayResult struct {
+	responses [][]byte
+	err       error
+}
+
+// replayCall re-issues c. Calls that the client canceled, such as
+// subscriptions, are canceled after the recorded duration divided by speed.
+func replayCall(cc *grpc.ClientConn, c *recordedRPC, speed float64) replayResult {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	if c.code == codes.Canceled {
+		d := c.end.Sub(c.start)
+		if speed > 0 {
+			d = time.Duration(float64(d) / speed)
+		}
+		t := time.AfterFunc(d, cancel)
+		defer t.Stop()
+	}
+	ctx = metadata.NewOutgoingContext(ctx, c.md)
+	desc := &grpc.StreamDesc{ServerStreams: true, ClientStreams: true}
+	stream, err := cc.NewStream(ctx, desc, c.method, grpc.ForceCodec(pb.RawCodec{}))
+	if err != nil {
+		return replayResult{err: err}
+	}
+	for _, req := range c.requests {
+		req := req
+		if err := stream.SendMsg(&req); err != nil {
+			break // the status is reported by RecvMsg
+		}
+	}
+	stream.CloseSend()
+	var res replayResult
+	for {
+		var resp []byte
+		err := stream.RecvMsg(&resp)
+		if err == io.EOF {
+			return res
+		}
+		if err != nil {
+			res.err = err
+			return res
+		}
+		res.responses = append(res.responses, resp)
+	}
+}
+
+// diffCall describes how a replayed call differed from the recording, or
+// returns "" if it matched.
+func diffCall(c *recordedRPC, res replayResult) string {
+	var b strings.Builder
+	st := status.Convert(res.err)
+	if st.Code() != c.code {
+		fmt.Fprintf(&b, "  status: recorded %v %q, replayed %v %q\n", c.code, c.message, st.Code(), st.Message())
+	}
+	if len(res.responses) != len(c.responses) {
+		fmt.Fprintf(&b, "  responses: recorded %d, replayed %d\n", len(c.responses), len(res.responses))
+	}
+	for i := 0; i < len(c.responses) && i < len(res.responses); i++ {
+		if bytes.Equal(c.responses[i], res.responses[i]) {
+			continue
+		}
+		fmt.Fprintf(&b, "  response %d:\n    recorded: %s\n    replayed: %s\n", i,
+			describeResponse(c.method, c.responses[i]), describeResponse(c.method, res.responses[i]))
+	}
+	return b.String()
+}
+
+// describeResponse renders a response as JSON when its type is known to
+// this binary, and as hex otherwise.
+func describeResponse(method string, data []byte) string {
+	parts := strings.Split(strings.TrimPrefix(method, "/"), "/")
+	if len(parts) == 2 {
+		if d, err := protoregistry.GlobalFiles.FindDescriptorByName(protoreflect.FullName(parts[0])); err == nil {
+			if sd, ok := d.(protoreflect.ServiceDescriptor); ok {
+				if md := sd.Methods().ByName(protoreflect.Name(parts[1])); md != nil {
+					if mt, err := protoregistry.GlobalTypes.FindMessageByName(md.Output().FullName()); err == nil {
+						m := mt.New().Interface()
+						if proto.Unmarshal(data, m) == nil {
+							if js, err := protojson.Marshal(m); err == nil {
+								return string(js)
+							}
+						}
+					}
+				}
+			}
+		}
+	}
+	return fmt.Sprintf("%x", data)
+}
+
+// replay re-issues the calls in the binary log at *replayLog against cc. Calls
+// start at their recorded offsets divided by *replaySpeed; a speed of zero
+// sends them back to back, one at a time, each once the previous one ended.
+// Every call whose status or responses differ from the recording is
+// reported.
+func replay(cc *grpc.ClientConn) {
+	calls, err := readBinaryLog(*replayLog)
+	if err != nil {
+		log.Fatalf("could not read %s: %v", *replayLog, err)
+	}
+	if len(calls) == 0 {
+		log.Fatalf("no complete calls in %s", *replayLog)
+	}
+	var (
+		wg       sync.WaitGroup
+		mu       sync.Mutex
+		differed int
+	)
+	check := func(c *recordedRPC, res replayResult) {
+		if d := diffCall(c, res); d != "" {
+			mu.Lock()
+			differed++
+			fmt.Printf("call %d %s differs:\n%s", c.id, c.method, d)
+			mu.Unlock()
+		}
+	}
+	begin := time.Now()
+	for _, c := range calls {
+		if *replaySpeed <= 0 {
+			check(c, replayCall(cc, c, 0))
+			continue
+		}
+		offset := time.Duration(float64(c.start.Sub(calls[0].start)) / *replaySpeed)
+		time.Sleep(time.Until(begin.Add(offset)))
+		wg.Add(1)
+		go func(c *recordedRPC) {
+			defer wg.Done()
+			check(c, replayCall(cc, c, *replaySpeed))
+		}(c)
+	}
+	wg.Wait()
+	log.Printf("replayed %d calls in %v, %d differed", len(calls), time.Since(begin).Round(time.Millisecond), differed)
+}
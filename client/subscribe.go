@@ -0,0 +1,30 @@
+package main
+
+import (
+	"context"
+	"log"
+	"time"
+
+	pb "example.com/grpc-go"
+)
+
+// subscribe prints welcome events until the subscription fails for good.
+func subscribe(c pb.WelcomeServiceClient) {
+	s := &pb.Subscriber{
+		Client:  c,
+		LastSeq: *resumeAfter,
+		Epoch:   *resumeEpoch,
+	}
+	s.OnRetry = func(err error, delay time.Duration) {
+		log.Printf("subscription interrupted (%v), resuming after #%d of epoch %d in %v", err, s.LastSeq, s.Epoch, delay)
+	}
+	epoch := s.Epoch
+	err := s.Run(context.Background(), func(ev *pb.WelcomeEvent) {
+		if ev.GetEpoch() != epoch {
+			epoch = ev.GetEpoch()
+			log.Printf("events of epoch %d; resume with -resume_epoch %d -resume_after <#>", epoch, epoch)
+		}
+		log.Printf("#%d %s", ev.GetSeq(), ev.GetMessage())
+	})
+	log.Fatalf("subscription ended: %v", err)
+}
@@ -0,0 +1,9 @@
+package welcome
+
+// welcome.pb.go and welcome_grpc.pb.go are generated from welcome.proto, and
+// the server builds its OpenAPI document from the descriptor compiled into
+// welcome.pb.go, so run go generate after every change to welcome.proto.
+// google/api/annotations.proto is looked up in $GOOGLEAPIS, a checkout of
+// github.com/googleapis/googleapis.
+//
+//go:generate protoc -I . -I ${GOOGLEAPIS} --go_out=. --go_opt=paths=source_relative --go-grpc_out=. --go-grpc_opt=paths=source_relative welcome.proto
@@ -1,8 +1,10 @@
 module example.com/grpc-go
 
-go 1.13
+go 1.16
 
 require (
+	golang.org/x/text v0.3.8
+	google.golang.org/genproto v0.0.0-20200526211855-cb27e3aa2013
 	google.golang.org/grpc v1.44.0
 	google.golang.org/protobuf v1.27.1
 )
@@ -0,0 +1,16 @@
+package welcome
+
+// RawCodec passes already encoded messages through unchanged: the values it
+// marshals and unmarshals are *[]byte. It is named "proto" so that the peer
+// decodes the messages with its regular codec. Proxies and replays use it to
+// forward calls without knowing the message types.
+type RawCodec struct{}
+
+func (RawCodec) Marshal(v interface{}) ([]byte, error) { return *v.(*[]byte), nil }
+
+func (RawCodec) Unmarshal(data []byte, v interface{}) error {
+	*v.(*[]byte) = append([]byte(nil), data...)
+	return nil
+}
+
+func (RawCodec) Name() string { return "proto" }
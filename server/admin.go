@@ -0,0 +1,98 @@
+package main
+
+import (
+	"context"
+	"log"
+	"strings"
+	"time"
+
+	pb "example.com/grpc-go"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/durationpb"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// adminServer implements welcome.AdminService.
+type adminServer struct {
+	pb.UnimplementedAdminServiceServer
+	faults  *faultInjector // nil unless fault injection is enabled
+	meter   *meter
+	members *memberStore
+	backup  *backupSources
+}
+
+func (s *adminServer) SetFaultRules(ctx context.Context, in *pb.SetFaultRulesRequest) (*pb.FaultRules, error) {
+	if s.faults == nil {
+		return nil, status.Error(codes.FailedPrecondition, "fault injection is disabled; start the server with -enable_faults")
+	}
+	for i, r := range in.GetRules() {
+		if err := validateFaultRule(r); err != nil {
+			return nil, status.Errorf(codes.InvalidArgument, "rule %d: %v", i, err)
+		}
+	}
+	rules := proto.Clone(in).(*pb.SetFaultRulesRequest).GetRules()
+	s.faults.setRules(rules)
+	return &pb.FaultRules{Rules: rules}, nil
+}
+
+func (s *adminServer) GetFaultRules(ctx context.Context, in *pb.GetFaultRulesRequest) (*pb.FaultRules, error) {
+	if s.faults == nil {
+		return &pb.FaultRules{}, nil
+	}
+	return &pb.FaultRules{Rules: s.faults.getRules()}, nil
+}
+
+// GetUsage reports the usage of the caller, or of any identity to callers
+// granted the whole AdminService.
+func (s *adminServer) GetUsage(ctx context.Context, in *pb.GetUsageRequest) (*pb.GetUsageResponse, error) {
+	identity := in.GetIdentity()
+	if identity == "" {
+		identity = callerIdentity(ctx)
+	}
+	if identity != callerIdentity(ctx) && !isAdmin(ctx) {
+		return nil, status.Errorf(codes.PermissionDenied, "only callers granted %s* may look up the usage of others", adminServicePrefix)
+	}
+	daySince, daily, monthSince, monthly := s.meter.usage(identity)
+	limits := s.meter.quotas.limits(identity)
+	var name string
+	if id := strings.TrimPrefix(identity, apiKeyIdentityPrefix); id != identity {
+		name = s.backup.keys.name(id)
+	}
+	return &pb.GetUsageResponse{
+		Identity:     identity,
+		DisplayName:  name,
+		Daily:        daily.proto(),
+		Monthly:      monthly.proto(),
+		DailyQuota:   limits.Daily.proto(),
+		MonthlyQuota: limits.Monthly.proto(),
+		DailySince:   timestamppb.New(daySince),
+		MonthlySince: timestamppb.New(monthSince),
+	}, nil
+}
+
+// isAdmin reports whether the caller is granted every AdminService method,
+// by the scopes of its API key or by the RBAC policy.
+func isAdmin(ctx context.Context) bool {
+	if k, ok := apiKeyFromContext(ctx); ok && matchMethod(k.Scopes, adminServicePrefix+"*") {
+		return true
+	}
+	admin, _ := ctx.Value(rbacAdminContextKey{}).(bool)
+	return admin
+}
+
+func (s *adminServer) RebuildMembers(ctx context.Context, in *pb.RebuildMembersRequest) (*pb.RebuildMembersResponse, error) {
+	start := time.Now()
+	events, members, changed, err := s.members.rebuild()
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "failed to rebuild members: %v", err)
+	}
+	log.Printf("rebuilt members from %d events; %d differed", events, changed)
+	return &pb.RebuildMembersResponse{
+		Events:  events,
+		Members: int32(members),
+		Changed: int32(changed),
+		Took:    durationpb.New(time.Since(start)),
+	}, nil
+}
@@ -0,0 +1,430 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"log"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	pb "example.com/grpc-go"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+const (
+	// apiKeyPrefix starts every key, which reads "wk_<id>_<secret>".
+	apiKeyPrefix = "wk_"
+	// apiKeyCacheTTL is how long a validated key is trusted without
+	// looking it up again.
+	apiKeyCacheTTL = 30 * time.Second
+	// lastUsedResolution limits how often last-used times are written.
+	lastUsedResolution = time.Minute
+	// apiKeyServicePrefix starts the full method names of the
+	// ApiKeyService.
+	apiKeyServicePrefix = "/welcome.ApiKeyService/"
+	// getUsageMethod reports usage, which callers may ask for their own.
+	getUsageMethod = adminServicePrefix + "GetUsage"
+)
+
+// apiKeyRecord is a stored API key. Only the SHA-256 hash of the key is
+// kept; the key itself is shown once, when it is created.
+type apiKeyRecord struct {
+	ID         string    `json:"id"`
+	Name       string    `json:"name"`
+	Hash       string    `json:"hash"`
+	Scopes     []string  `json:"scopes"`
+	MemberID   string    `json:"member_id,omitempty"`
+	CreatedAt  time.Time `json:"created_at"`
+	ExpiresAt  time.Time `json:"expires_at"`
+	LastUsedAt time.Time `json:"last_used_at"`
+	RevokedAt  time.Time `json:"revoked_at"`
+}
+
+func (r *apiKeyRecord) proto() *pb.ApiKey {
+	ts := func(t time.Time) *timestamppb.Timestamp {
+		if t.IsZero() {
+			return nil
+		}
+		return timestamppb.New(t)
+	}
+	return &pb.ApiKey{
+		Id:         r.ID,
+		Name:       r.Name,
+		Scopes:     r.Scopes,
+		MemberId:   r.MemberID,
+		CreatedAt:  ts(r.CreatedAt),
+		ExpiresAt:  ts(r.ExpiresAt),
+		LastUsedAt: ts(r.LastUsedAt),
+		RevokedAt:  ts(r.RevokedAt),
+	}
+}
+
+// apiKeyStore keeps the API keys in a JSON file and checks presented keys
+// against them.
+type apiKeyStore struct {
+	mu    sync.Mutex
+	path  string
+	lock  *dataDirLock
+	keys  map[string]*apiKeyRecord
+	cache map[string]cachedAPIKey // by presented key
+}
+
+type cachedAPIKey struct {
+	id    string
+	until time.Time
+}
+
+// openAPIKeyStore reads the keys at path. Writes go through lock, which may
+// be nil.
+func openAPIKeyStore(path string, lock *dataDirLock) (*apiKeyStore, error) {
+	s := &apiKeyStore{path: path, lock: lock}
+	if err := s.reload(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// reload reads the keys from disk again, replacing those in memory.
+func (s *apiKeyStore) reload() error {
+	var recs []*apiKeyRecord
+	if err := readJSONFile(s.path, &recs); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.keys = make(map[string]*apiKeyRecord)
+	s.cache = make(map[string]cachedAPIKey)
+	for _, r := range recs {
+		s.keys[r.ID] = r
+	}
+	return nil
+}
+
+// sorted returns the keys in the order they were created. s.mu must be
+// held.
+func (s *apiKeyStore) sorted() []*apiKeyRecord {
+	recs := make([]*apiKeyRecord, 0, len(s.keys))
+	for _, r := range s.keys {
+		recs = append(recs, r)
+	}
+	sort.Slice(recs, func(i, j int) bool { return recs[i].CreatedAt.Before(recs[j].CreatedAt) })
+	return recs
+}
+
+// save writes all keys to disk. s.mu must be held.
+func (s *apiKeyStore) save() error {
+	return s.lock.write(func() error { return writeJSONFile(s.path, s.sorted()) })
+}
+
+func hashAPIKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+func (s *apiKeyStore) empty() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.keys) == 0
+}
+
+// create adds a key and returns it with its record. A zero ttl means the key
+// never expires.
+func (s *apiKeyStore) create(name string, scopes []string, memberID string, ttl time.Duration) (string, apiKeyRecord, error) {
+	id := make([]byte, 8)
+	secret := make([]byte, 32)
+	if _, err := rand.Read(id); err != nil {
+		return "", apiKeyRecord{}, err
+	}
+	if _, err := rand.Read(secret); err != nil {
+		return "", apiKeyRecord{}, err
+	}
+	r := &apiKeyRecord{
+		ID:        hex.EncodeToString(id),
+		Name:      name,
+		Scopes:    scopes,
+		MemberID:  memberID,
+		CreatedAt: time.Now().UTC(),
+	}
+	if ttl > 0 {
+		r.ExpiresAt = r.CreatedAt.Add(ttl)
+	}
+	key := apiKeyPrefix + r.ID + "_" + base64.RawURLEncoding.EncodeToString(secret)
+	r.Hash = hashAPIKey(key)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.keys[r.ID] = r
+	if err := s.save(); err != nil {
+		delete(s.keys, r.ID)
+		return "", apiKeyRecord{}, err
+	}
+	return key, *r, nil
+}
+
+func (s *apiKeyStore) list() []apiKeyRecord {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	out := make([]apiKeyRecord, 0, len(s.keys))
+	for _, r := range s.keys {
+		out = append(out, *r)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].CreatedAt.Before(out[j].CreatedAt) })
+	return out
+}
+
+// name returns the name of key id, or "" if there is no such key.
+func (s *apiKeyStore) name(id string) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if r, ok := s.keys[id]; ok {
+		return r.Name
+	}
+	return ""
+}
+
+func (s *apiKeyStore) revoke(id string) (apiKeyRecord, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	r, ok := s.keys[id]
+	if !ok {
+		return apiKeyRecord{}, status.Errorf(codes.NotFound, "no API key %q", id)
+	}
+	if r.RevokedAt.IsZero() {
+		r.RevokedAt = time.Now().UTC()
+		if err := s.save(); err != nil {
+			r.RevokedAt = time.Time{}
+			return apiKeyRecord{}, status.Errorf(codes.Internal, "failed to save API keys: %v", err)
+		}
+	}
+	s.dropCached(id)
+	return *r, nil
+}
+
+// rotate creates a successor to key id and makes id expire after grace.
+func (s *apiKeyStore) rotate(id string, grace, ttl time.Duration) (string, apiKeyRecord, error) {
+	s.mu.Lock()
+	old, ok := s.keys[id]
+	var (
+		name, memberID string
+		scopes         []string
+		revoked        bool
+	)
+	if ok {
+		name, scopes, memberID, revoked = old.Name, old.Scopes, old.MemberID, !old.RevokedAt.IsZero()
+	}
+	s.mu.Unlock()
+	if !ok {
+		return "", apiKeyRecord{}, status.Errorf(codes.NotFound, "no API key %q", id)
+	}
+	if revoked {
+		return "", apiKeyRecord{}, status.Errorf(codes.FailedPrecondition, "API key %q is revoked", id)
+	}
+	key, r, err := s.create(name, scopes, memberID, ttl)
+	if err != nil {
+		return "", apiKeyRecord{}, status.Errorf(codes.Internal, "failed to save API keys: %v", err)
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if end := time.Now().UTC().Add(grace); old.ExpiresAt.IsZero() || end.Before(old.ExpiresAt) {
+		old.ExpiresAt = end
+	}
+	if err := s.save(); err != nil {
+		return "", apiKeyRecord{}, status.Errorf(codes.Internal, "failed to save API keys: %v", err)
+	}
+	s.dropCached(id)
+	return key, r, nil
+}
+
+// dropCached forgets cached validations of key id. s.mu must be held.
+func (s *apiKeyStore) dropCached(id string) {
+	for k, c := range s.cache {
+		if c.id == id {
+			delete(s.cache, k)
+		}
+	}
+}
+
+// authenticate checks that key is valid and may call method, and returns its
+// record.
+func (s *apiKeyStore) authenticate(key, method string) (apiKeyRecord, error) {
+	now := time.Now()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var r *apiKeyRecord
+	if c, ok := s.cache[key]; ok && now.Before(c.until) {
+		r = s.keys[c.id]
+	} else {
+		delete(s.cache, key)
+		if rest := strings.TrimPrefix(key, apiKeyPrefix); rest != key {
+			if i := strings.IndexByte(rest, '_'); i > 0 {
+				r = s.keys[rest[:i]]
+			}
+		}
+		if r == nil || subtle.ConstantTimeCompare([]byte(hashAPIKey(key)), []byte(r.Hash)) != 1 {
+			return apiKeyRecord{}, status.Error(codes.Unauthenticated, "invalid API key")
+		}
+		s.cache[key] = cachedAPIKey{id: r.ID, until: now.Add(apiKeyCacheTTL)}
+	}
+	switch {
+	case r == nil:
+		return apiKeyRecord{}, status.Error(codes.Unauthenticated, "invalid API key")
+	case !r.RevokedAt.IsZero():
+		return apiKeyRecord{}, status.Error(codes.Unauthenticated, "API key revoked")
+	case !r.ExpiresAt.IsZero() && now.After(r.ExpiresAt):
+		return apiKeyRecord{}, status.Error(codes.Unauthenticated, "API key expired")
+	case !matchMethod(r.Scopes, method):
+		return apiKeyRecord{}, status.Errorf(codes.PermissionDenied, "API key %s may not call %s", r.ID, method)
+	}
+	if now.Sub(r.LastUsedAt) >= lastUsedResolution {
+		r.LastUsedAt = now.UTC()
+		if err := s.save(); err != nil && err != errHandedOff {
+			log.Printf("failed to save API key last use: %v", err)
+		}
+	}
+	return *r, nil
+}
+
+type apiKeyContextKey struct{}
+
+// apiKeyFromContext returns the key a call was authenticated with.
+func apiKeyFromContext(ctx context.Context) (apiKeyRecord, bool) {
+	r, ok := ctx.Value(apiKeyContextKey{}).(apiKeyRecord)
+	return r, ok
+}
+
+func (s *apiKeyStore) authorize(ctx context.Context, method string) (context.Context, error) {
+	md, _ := metadata.FromIncomingContext(ctx)
+	var key string
+	for _, v := range md.Get("authorization") {
+		if strings.HasPrefix(v, "Bearer ") {
+			key = strings.TrimPrefix(v, "Bearer ")
+		}
+	}
+	if key == "" {
+		return nil, status.Error(codes.Unauthenticated, "missing API key")
+	}
+	r, err := s.authenticate(key, method)
+	if err != nil {
+		return nil, err
+	}
+	if e, _ := ctx.Value(accessEntryContextKey{}).(*accessEntry); e != nil {
+		e.apiKeyID = r.ID
+	}
+	return context.WithValue(ctx, apiKeyContextKey{}, r), nil
+}
+
+func (s *apiKeyStore) unaryInterceptor(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	ctx, err := s.authorize(ctx, info.FullMethod)
+	if err != nil {
+		return nil, err
+	}
+	return handler(ctx, req)
+}
+
+func (s *apiKeyStore) streamInterceptor(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	ctx, err := s.authorize(ss.Context(), info.FullMethod)
+	if err != nil {
+		return err
+	}
+	return handler(srv, &contextStream{ServerStream: ss, ctx: ctx})
+}
+
+// authorizeAdmin authorizes the calls to the administrative services with
+// API keys, for when other calls do not take them. GetUsage is open to
+// callers asking for their own usage; a key, if sent, lets admins ask for
+// that of others.
+func (s *apiKeyStore) authorizeAdmin(ctx context.Context, method string) (context.Context, error) {
+	if !strings.HasPrefix(method, adminServicePrefix) && !strings.HasPrefix(method, apiKeyServicePrefix) {
+		return ctx, nil
+	}
+	if md, _ := metadata.FromIncomingContext(ctx); method == getUsageMethod && len(md.Get("authorization")) == 0 {
+		return ctx, nil
+	}
+	return s.authorize(ctx, method)
+}
+
+func (s *apiKeyStore) adminUnaryInterceptor(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	ctx, err := s.authorizeAdmin(ctx, info.FullMethod)
+	if err != nil {
+		return nil, err
+	}
+	return handler(ctx, req)
+}
+
+func (s *apiKeyStore) adminStreamInterceptor(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	ctx, err := s.authorizeAdmin(ss.Context(), info.FullMethod)
+	if err != nil {
+		return err
+	}
+	return handler(srv, &contextStream{ServerStream: ss, ctx: ctx})
+}
+
+// contextStream replaces the context of a server stream.
+type contextStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *contextStream) Context() context.Context { return s.ctx }
+
+// apiKeyServer implements welcome.ApiKeyService.
+type apiKeyServer struct {
+	pb.UnimplementedApiKeyServiceServer
+	store *apiKeyStore
+}
+
+func (s *apiKeyServer) CreateApiKey(ctx context.Context, in *pb.CreateApiKeyRequest) (*pb.CreateApiKeyResponse, error) {
+	if in.GetName() == "" {
+		return nil, status.Error(codes.InvalidArgument, "name is required")
+	}
+	if len(in.GetScopes()) == 0 {
+		return nil, status.Error(codes.InvalidArgument, "at least one scope is required")
+	}
+	if in.GetTtl().AsDuration() < 0 {
+		return nil, status.Error(codes.InvalidArgument, "ttl must not be negative")
+	}
+	key, r, err := s.store.create(in.GetName(), in.GetScopes(), in.GetMemberId(), in.GetTtl().AsDuration())
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "failed to save API keys: %v", err)
+	}
+	log.Printf("created API key %s (%s) with scopes %v", r.ID, r.Name, r.Scopes)
+	return &pb.CreateApiKeyResponse{ApiKey: r.proto(), Key: key}, nil
+}
+
+func (s *apiKeyServer) ListApiKeys(ctx context.Context, in *pb.ListApiKeysRequest) (*pb.ListApiKeysResponse, error) {
+	resp := &pb.ListApiKeysResponse{}
+	for _, r := range s.store.list() {
+		resp.ApiKeys = append(resp.ApiKeys, r.proto())
+	}
+	return resp, nil
+}
+
+func (s *apiKeyServer) RevokeApiKey(ctx context.Context, in *pb.RevokeApiKeyRequest) (*pb.ApiKey, error) {
+	r, err := s.store.revoke(in.GetId())
+	if err != nil {
+		return nil, err
+	}
+	log.Printf("revoked API key %s (%s)", r.ID, r.Name)
+	return r.proto(), nil
+}
+
+func (s *apiKeyServer) RotateApiKey(ctx context.Context, in *pb.RotateApiKeyRequest) (*pb.CreateApiKeyResponse, error) {
+	if in.GetGracePeriod().AsDuration() < 0 || in.GetTtl().AsDuration() < 0 {
+		return nil, status.Error(codes.InvalidArgument, "grace_period and ttl must not be negative")
+	}
+	key, r, err := s.store.rotate(in.GetId(), in.GetGracePeriod().AsDuration(), in.GetTtl().AsDuration())
+	if err != nil {
+		return nil, err
+	}
+	log.Printf("rotated API key %s to %s (%s)", in.GetId(), r.ID, r.Name)
+	return &pb.CreateApiKeyResponse{ApiKey: r.proto(), Key: key}, nil
+}
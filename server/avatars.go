@@ -0,0 +1,209 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/color"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+
+	pb "example.com/grpc-go"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// maxAvatarDimension bounds the width and height of avatars, which are
+// checked before the image is decoded so that a small file cannot claim an
+// enormous image.
+const maxAvatarDimension = 4096
+
+// avatarThumbnailSizes are the widths and heights of the thumbnails made of
+// every avatar.
+var avatarThumbnailSizes = []int{256, 64}
+
+// avatarFormats maps the accepted content types to the format names of the
+// image package and the file extensions of stored originals.
+var avatarFormats = map[string]struct{ format, ext string }{
+	"image/png":  {"png", ".png"},
+	"image/jpeg": {"jpeg", ".jpg"},
+	"image/gif":  {"gif", ".gif"},
+}
+
+// The image package only decodes registered formats.
+var (
+	_ = png.Decode
+	_ = jpeg.Decode
+	_ = gif.Decode
+)
+
+// avatarStore keeps avatars in a directory per member: the original as
+// uploaded and a PNG thumbnail for each size.
+type avatarStore struct {
+	dir  string
+	lock *dataDirLock
+	mu   sync.Mutex // held while files are replaced
+}
+
+// save validates an uploaded image of the declared content type, makes its
+// thumbnails and stores them with it, replacing the member's avatar.
+func (s *avatarStore) save(memberID, contentType string, data []byte) (*pb.Avatar, error) {
+	f := avatarFormats[contentType]
+	cfg, format, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "not a PNG, JPEG or GIF image: %v", err)
+	}
+	if format != f.format {
+		return nil, status.Errorf(codes.InvalidArgument, "declared %s but the image is %s", contentType, format)
+	}
+	if cfg.Width < 1 || cfg.Height < 1 || cfg.Width > maxAvatarDimension || cfg.Height > maxAvatarDimension {
+		return nil, status.Errorf(codes.InvalidArgument, "image is %dx%d, at most %dx%d is allowed", cfg.Width, cfg.Height, maxAvatarDimension, maxAvatarDimension)
+	}
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "invalid %s image: %v", format, err)
+	}
+
+	dir := filepath.Join(s.dir, memberID)
+	out := &pb.Avatar{
+		MemberId:    memberID,
+		ContentType: contentType,
+		Width:       int32(cfg.Width),
+		Height:      int32(cfg.Height),
+		Bytes:       int64(len(data)),
+	}
+	thumbs := make([][]byte, len(avatarThumbnailSizes))
+	for i, size := range avatarThumbnailSizes {
+		var buf bytes.Buffer
+		if err := png.Encode(&buf, thumbnail(img, size)); err != nil {
+			return nil, status.Errorf(codes.Internal, "failed to encode thumbnail: %v", err)
+		}
+		thumbs[i] = buf.Bytes()
+		out.Thumbnails = append(out.Thumbnails, &pb.AvatarThumbnail{Size: int32(size), Bytes: int64(buf.Len())})
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	err = s.lock.write(func() error {
+		for i, size := range avatarThumbnailSizes {
+			if err := writeFileAtomic(filepath.Join(dir, fmt.Sprintf("%d.png", size)), thumbs[i]); err != nil {
+				return status.Errorf(codes.Internal, "failed to save thumbnail: %v", err)
+			}
+		}
+		if err := writeFileAtomic(filepath.Join(dir, "original"+f.ext), data); err != nil {
+			return status.Errorf(codes.Internal, "failed to save avatar: %v", err)
+		}
+		for _, other := range avatarFormats {
+			if other.ext != f.ext {
+				os.Remove(filepath.Join(dir, "original"+other.ext))
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// remove deletes the avatar of a member, if any.
+func (s *avatarStore) remove(memberID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.lock.write(func() error { return os.RemoveAll(filepath.Join(s.dir, memberID)) })
+}
+
+// thumbnail crops the largest centered square from img and scales it to
+// size by size pixels. Each pixel averages up to 4x4 samples of the area it
+// covers, which is smooth enough for thumbnails and bounds the work for
+// large images.
+func thumbnail(img image.Image, size int) *image.NRGBA {
+	b := img.Bounds()
+	side := b.Dx()
+	if b.Dy() < side {
+		side = b.Dy()
+	}
+	x0 := b.Min.X + (b.Dx()-side)/2
+	y0 := b.Min.Y + (b.Dy()-side)/2
+	samples := side / size
+	if samples < 1 {
+		samples = 1
+	}
+	if samples > 4 {
+		samples = 4
+	}
+	dst := image.NewNRGBA(image.Rect(0, 0, size, size))
+	for y := 0; y < size; y++ {
+		for x := 0; x < size; x++ {
+			var r, g, bl, a uint64
+			for sy := 0; sy < samples; sy++ {
+				for sx := 0; sx < samples; sx++ {
+					// The sample points are spread evenly over the area.
+					px := x0 + ((2*x*samples+2*sx+1)*side)/(2*size*samples)
+					py := y0 + ((2*y*samples+2*sy+1)*side)/(2*size*samples)
+					cr, cg, cb, ca := img.At(px, py).RGBA()
+					r, g, bl, a = r+uint64(cr), g+uint64(cg), bl+uint64(cb), a+uint64(ca)
+				}
+			}
+			n := uint64(samples * samples)
+			c := color.RGBA64{R: uint16(r / n), G: uint16(g / n), B: uint16(bl / n), A: uint16(a / n)}
+			dst.Set(x, y, c)
+		}
+	}
+	return dst
+}
+
+// UploadAvatar receives the metadata and then the data of an avatar,
+// aborting as soon as the data exceeds the size limit.
+func (s *memberServer) UploadAvatar(stream pb.MemberService_UploadAvatarServer) error {
+	first, err := stream.Recv()
+	if err == io.EOF {
+		return status.Error(codes.InvalidArgument, "no metadata received")
+	}
+	if err != nil {
+		return err
+	}
+	md := first.GetMetadata()
+	if md == nil {
+		return status.Error(codes.InvalidArgument, "the first message must carry the metadata")
+	}
+	if _, err := s.store.get(md.GetMemberId()); err != nil {
+		return err
+	}
+	if _, ok := avatarFormats[md.GetContentType()]; !ok {
+		return status.Errorf(codes.InvalidArgument, "unsupported content type %q, want image/png, image/jpeg or image/gif", md.GetContentType())
+	}
+	if md.GetSize() > s.maxAvatarBytes {
+		return status.Errorf(codes.ResourceExhausted, "avatar of %d bytes exceeds the limit of %d", md.GetSize(), s.maxAvatarBytes)
+	}
+	var data bytes.Buffer
+	for {
+		in, err := stream.Recv()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		if in.GetMetadata() != nil {
+			return status.Error(codes.InvalidArgument, "metadata may only be sent in the first message")
+		}
+		if int64(data.Len()+len(in.GetChunk())) > s.maxAvatarBytes {
+			return status.Errorf(codes.ResourceExhausted, "avatar exceeds the limit of %d bytes", s.maxAvatarBytes)
+		}
+		data.Write(in.GetChunk())
+	}
+	if md.GetSize() > 0 && int64(data.Len()) != md.GetSize() {
+		return status.Errorf(codes.InvalidArgument, "received %d bytes, %d were declared", data.Len(), md.GetSize())
+	}
+	avatar, err := s.avatars.save(md.GetMemberId(), md.GetContentType(), data.Bytes())
+	if err != nil {
+		return err
+	}
+	return stream.SendAndClose(avatar)
+}
@@ -0,0 +1,408 @@
+package main
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	pb "example.com/grpc-go"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	// backupFormat and backupVersion identify the archives of this server.
+	// The version changes when the layout of the archive or of the stores in
+	// it changes incompatibly.
+	backupFormat  = "welcome-backup"
+	backupVersion = 1
+	// backupManifestName is the last file of an archive.
+	backupManifestName = "MANIFEST.json"
+	// backupChunkSize is the size of the messages streamed by Backup.
+	backupChunkSize = 64 << 10
+)
+
+// backupManifest describes the files of a backup archive.
+type backupManifest struct {
+	Format    string         `json:"format"`
+	Version   int            `json:"version"`
+	CreatedAt time.Time      `json:"created_at"`
+	Files     []manifestFile `json:"files"`
+}
+
+type manifestFile struct {
+	Path   string `json:"path"` // relative to the data directory, with slashes
+	Size   int64  `json:"size"`
+	SHA256 string `json:"sha256"`
+}
+
+// backupSources are the stores a backup is taken of. The member log is the
+// history of the members. Welcomes are sent when they are asked for, never
+// scheduled, so there is no schedule store to take.
+type backupSources struct {
+	keys    *apiKeyStore
+	members *memberStore
+	cohorts *cohortStore
+	usage   *meter
+	stats   *welcomeStats
+	avatars *avatarStore
+	dataDir string
+}
+
+// backupEntry is a file captured for a backup.
+type backupEntry struct {
+	path string
+	r    io.Reader
+	size int64
+}
+
+// capture takes the state of every store as of one point in time, holding
+// all of their locks at once but only for as long as it takes to copy what
+// is in memory and open the files. The member log is append-only and
+// avatar files are only ever replaced by renames, so they are read after
+// the locks are released. The returned function closes the opened files.
+func (b *backupSources) capture() ([]backupEntry, func(), error) {
+	b.keys.mu.Lock()
+	defer b.keys.mu.Unlock()
+	b.members.mu.Lock()
+	defer b.members.mu.Unlock()
+	b.cohorts.mu.Lock()
+	defer b.cohorts.mu.Unlock()
+	b.usage.mu.Lock()
+	defer b.usage.mu.Unlock()
+	b.stats.mu.Lock()
+	defer b.stats.mu.Unlock()
+	b.avatars.mu.Lock()
+	defer b.avatars.mu.Unlock()
+
+	var (
+		entries []backupEntry
+		files   []*os.File
+	)
+	closeAll := func() {
+		for _, f := range files {
+			f.Close()
+		}
+	}
+	addJSON := func(name string, v interface{}) error {
+		data, err := encodeJSONFile(v)
+		if err != nil {
+			return err
+		}
+		entries = append(entries, backupEntry{path: name, r: bytes.NewReader(data), size: int64(len(data))})
+		return nil
+	}
+	addFile := func(name, p string, size int64) error {
+		f, err := os.Open(p)
+		if err != nil {
+			return err
+		}
+		files = append(files, f)
+		if size < 0 {
+			fi, err := f.Stat()
+			if err != nil {
+				return err
+			}
+			size = fi.Size()
+		}
+		entries = append(entries, backupEntry{path: name, r: io.LimitReader(f, size), size: size})
+		return nil
+	}
+
+	// Every store is listed here; see backupSources for why schedules are
+	// not.
+	err := addJSON("api_keys.json", b.keys.sorted())
+	if err == nil {
+		err = addJSON("cohorts.json", b.cohorts.sorted())
+	}
+	if err == nil {
+		err = addJSON("usage.json", b.usage.periods)
+	}
+	if err == nil {
+		err = addJSON("welcome_stats.json", b.stats.saved())
+	}
+	if err == nil {
+		err = addFile(filepath.Base(b.members.logPath), b.members.logPath, b.members.offset)
+	}
+	if err == nil {
+		err = filepath.WalkDir(b.avatars.dir, func(p string, d fs.DirEntry, err error) error {
+			if os.IsNotExist(err) && p == b.avatars.dir {
+				return nil
+			}
+			if err != nil || !d.Type().IsRegular() || strings.HasPrefix(d.Name(), ".") {
+				return err
+			}
+			rel, err := filepath.Rel(b.dataDir, p)
+			if err != nil {
+				return err
+			}
+			return addFile(filepath.ToSlash(rel), p, -1)
+		})
+	}
+	if err != nil {
+		closeAll()
+		return nil, nil, err
+	}
+	return entries, closeAll, nil
+}
+
+// writeBackup writes a backup archive of entries to w, with a manifest of
+// their digests last.
+func writeBackup(w io.Writer, entries []backupEntry) (*backupManifest, error) {
+	m := &backupManifest{Format: backupFormat, Version: backupVersion, CreatedAt: time.Now().UTC()}
+	gz := gzip.NewWriter(w)
+	tw := tar.NewWriter(gz)
+	write := func(name string, r io.Reader, size int64) (string, error) {
+		hdr := &tar.Header{Name: name, Mode: 0600, Size: size, ModTime: m.CreatedAt, Typeflag: tar.TypeReg}
+		if err := tw.WriteHeader(hdr); err != nil {
+			return "", err
+		}
+		h := sha256.New()
+		n, err := io.Copy(io.MultiWriter(tw, h), r)
+		if err != nil {
+			return "", err
+		}
+		if n != size {
+			return "", fmt.Errorf("%s: read %d bytes, expected %d", name, n, size)
+		}
+		return hex.EncodeToString(h.Sum(nil)), nil
+	}
+	for _, e := range entries {
+		sum, err := write(e.path, e.r, e.size)
+		if err != nil {
+			return nil, err
+		}
+		m.Files = append(m.Files, manifestFile{Path: e.path, Size: e.size, SHA256: sum})
+	}
+	data, err := encodeJSONFile(m)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := write(backupManifestName, bytes.NewReader(data), int64(len(data))); err != nil {
+		return nil, err
+	}
+	if err := tw.Close(); err != nil {
+		return nil, err
+	}
+	return m, gz.Close()
+}
+
+// chunkWriter sends what is written to it as BackupChunks.
+type chunkWriter struct {
+	stream pb.AdminService_BackupServer
+}
+
+func (w chunkWriter) Write(p []byte) (int, error) {
+	data := append([]byte(nil), p...)
+	if err := w.stream.Send(&pb.BackupChunk{Data: data}); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+func (s *adminServer) Backup(in *pb.BackupRequest, stream pb.AdminService_BackupServer) error {
+	entries, closeAll, err := s.backup.capture()
+	if err != nil {
+		return status.Errorf(codes.Internal, "failed to capture state: %v", err)
+	}
+	defer closeAll()
+	w := bufio.NewWriterSize(chunkWriter{stream}, backupChunkSize)
+	m, err := writeBackup(w, entries)
+	if err == nil {
+		err = w.Flush()
+	}
+	if err != nil {
+		if _, ok := status.FromError(err); ok {
+			return err
+		}
+		return status.Errorf(codes.Internal, "failed to write backup: %v", err)
+	}
+	log.Printf("streamed a backup of %d files", len(m.Files))
+	return nil
+}
+
+// restoreBackup replaces dataDir with the contents of the backup archive at
+// archivePath. The archive is extracted next to dataDir and checked against
+// its manifest and by opening the stores in it before it is swapped in; the
+// old directory is kept under a name that is returned.
+func restoreBackup(archivePath, dataDir string, keys *keyring) (m *backupManifest, old string, err error) {
+	f, err := os.Open(archivePath)
+	if err != nil {
+		return nil, "", err
+	}
+	defer f.Close()
+	dataDir = filepath.Clean(dataDir)
+	tmp, err := os.MkdirTemp(filepath.Dir(dataDir), filepath.Base(dataDir)+".restore-")
+	if err != nil {
+		return nil, "", err
+	}
+	defer func() {
+		if err != nil {
+			os.RemoveAll(tmp)
+		}
+	}()
+	if m, err = extractBackup(f, tmp); err != nil {
+		return nil, "", err
+	}
+	if err := checkRestoredStores(tmp, keys); err != nil {
+		return nil, "", fmt.Errorf("archive holds invalid state: %v", err)
+	}
+
+	if _, err := os.Stat(dataDir); err == nil {
+		old = dataDir + ".pre-restore-" + time.Now().Format("20060102-150405")
+		if err := os.Rename(dataDir, old); err != nil {
+			return nil, "", err
+		}
+	}
+	if err := os.Rename(tmp, dataDir); err != nil {
+		if old != "" {
+			os.Rename(old, dataDir)
+		}
+		return nil, "", err
+	}
+	return m, old, nil
+}
+
+// extractBackup extracts an archive into dir and checks that its files are
+// exactly those of its manifest.
+func extractBackup(r io.Reader, dir string) (*backupManifest, error) {
+	gz, err := gzip.NewReader(r)
+	if err != nil {
+		return nil, fmt.Errorf("not a backup archive: %v", err)
+	}
+	tr := tar.NewReader(gz)
+	var m *backupManifest
+	got := make(map[string]manifestFile)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("corrupt archive: %v", err)
+		}
+		name := hdr.Name
+		if hdr.Typeflag != tar.TypeReg || name != path.Clean(name) || path.IsAbs(name) || name == "." || strings.HasPrefix(name, "../") {
+			return nil, fmt.Errorf("unexpected entry %q in archive", name)
+		}
+		if m != nil {
+			return nil, fmt.Errorf("entry %q after the manifest", name)
+		}
+		if name == backupManifestName {
+			m = &backupManifest{}
+			if err := json.NewDecoder(tr).Decode(m); err != nil {
+				return nil, fmt.Errorf("corrupt manifest: %v", err)
+			}
+			continue
+		}
+		if _, dup := got[name]; dup {
+			return nil, fmt.Errorf("duplicate entry %q in archive", name)
+		}
+		p := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(p), 0700); err != nil {
+			return nil, err
+		}
+		out, err := os.OpenFile(p, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0600)
+		if err != nil {
+			return nil, err
+		}
+		h := sha256.New()
+		n, err := io.Copy(io.MultiWriter(out, h), tr)
+		if err == nil {
+			err = out.Sync()
+		}
+		if cerr := out.Close(); err == nil {
+			err = cerr
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to extract %s: %v", name, err)
+		}
+		got[name] = manifestFile{Path: name, Size: n, SHA256: hex.EncodeToString(h.Sum(nil))}
+	}
+	// Reading to the end verifies the gzip checksum.
+	if _, err := io.Copy(io.Discard, gz); err != nil {
+		return nil, fmt.Errorf("corrupt archive: %v", err)
+	}
+
+	switch {
+	case m == nil:
+		return nil, fmt.Errorf("no %s; not a backup archive", backupManifestName)
+	case m.Format != backupFormat:
+		return nil, fmt.Errorf("archive format is %q, not %q", m.Format, backupFormat)
+	case m.Version > backupVersion:
+		return nil, fmt.Errorf("archive version %d is newer than version %d of this server", m.Version, backupVersion)
+	case m.Version < 1:
+		return nil, fmt.Errorf("invalid archive version %d", m.Version)
+	}
+	for _, want := range m.Files {
+		if g, ok := got[want.Path]; !ok {
+			return nil, fmt.Errorf("%s is in the manifest but not the archive", want.Path)
+		} else if g != want {
+			return nil, fmt.Errorf("%s does not match the manifest: %d bytes with SHA-256 %s, expected %d bytes with %s", want.Path, g.Size, g.SHA256, want.Size, want.SHA256)
+		}
+		delete(got, want.Path)
+	}
+	for name := range got {
+		return nil, fmt.Errorf("%s is in the archive but not the manifest", name)
+	}
+	return m, nil
+}
+
+// checkRestoredStores opens the stores in dir as the server would, with the
+// keyring the member data is encrypted with.
+func checkRestoredStores(dir string, keys *keyring) error {
+	if _, err := openAPIKeyStore(filepath.Join(dir, "api_keys.json"), nil); err != nil {
+		return fmt.Errorf("api_keys.json: %v", err)
+	}
+	if _, err := openCohortStore(filepath.Join(dir, "cohorts.json"), nil); err != nil {
+		return fmt.Errorf("cohorts.json: %v", err)
+	}
+	var periods map[string]map[string]*usageCounts
+	if err := readJSONFile(filepath.Join(dir, "usage.json"), &periods); err != nil {
+		return fmt.Errorf("usage.json: %v", err)
+	}
+	if _, err := readWelcomeStats(filepath.Join(dir, "welcome_stats.json")); err != nil {
+		return fmt.Errorf("welcome_stats.json: %v", err)
+	}
+	members, err := openMemberStore(dir, keys, nil)
+	if err != nil {
+		return fmt.Errorf("members: %v", err)
+	}
+	return members.log.Close()
+}
+
+// runRestore restores -backup_file into -data_dir.
+func runRestore() {
+	if *backupFile == "" {
+		log.Fatalf("restore needs -backup_file")
+	}
+	// A missing directory is not in use, and locking would create it.
+	if _, err := os.Stat(*dataDir); err == nil {
+		data, err := lockDataDir(*dataDir)
+		if err != nil {
+			log.Fatalf("failed to lock data directory: %v", err)
+		}
+		defer data.Close()
+	}
+	m, old, err := restoreBackup(*backupFile, *dataDir, flagKeyring())
+	if err != nil {
+		log.Fatalf("failed to restore %s: %v", *backupFile, err)
+	}
+	log.Printf("restored %d files of the backup taken at %s into %s", len(m.Files), m.CreatedAt.Local().Format(time.RFC3339), *dataDir)
+	if old != "" {
+		log.Printf("the previous state is kept in %s", old)
+	}
+}
@@ -0,0 +1,355 @@
+package main
+
+import (
+	"bytes"
+	"container/list"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"strconv"
+	"strings"
+	"sync"
+
+	pb "example.com/grpc-go"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	// cardChunkSize is the most image data sent in one WelcomeCardChunk.
+	cardChunkSize = 32 << 10
+	// cardCacheBytes bounds the size of the rendered cards kept in memory.
+	cardCacheBytes = 32 << 20
+	// maxCardNameLines is how many lines a long name may be wrapped over.
+	maxCardNameLines = 3
+
+	defaultCardTemplate = "classic"
+)
+
+// cardTemplate lays out a welcome card: the greeting in small type, an
+// accent rule below it and the name in large type, wrapped and scaled down
+// as needed to fit inside the margins.
+type cardTemplate struct {
+	Width      int    `json:"width"`
+	Height     int    `json:"height"`
+	Background string `json:"background"` // as "#rrggbb"
+	Foreground string `json:"foreground"`
+	Accent     string `json:"accent"`
+	// Align is "left" or "center".
+	Align string `json:"align"`
+	// GreetingScale and NameScale are the size of a font pixel, in image
+	// pixels.
+	GreetingScale int `json:"greeting_scale"`
+	NameScale     int `json:"name_scale"`
+	Margin        int `json:"margin"`
+}
+
+var builtinCardTemplates = map[string]cardTemplate{
+	"classic": {
+		Width: 1200, Height: 630,
+		Background: "#1e293b", Foreground: "#f8fafc", Accent: "#38bdf8",
+		Align: "left", GreetingScale: 6, NameScale: 14, Margin: 80,
+	},
+	"square": {
+		Width: 1080, Height: 1080,
+		Background: "#fef3c7", Foreground: "#1f2937", Accent: "#f59e0b",
+		Align: "center", GreetingScale: 7, NameScale: 16, Margin: 90,
+	},
+	"banner": {
+		Width: 1500, Height: 500,
+		Background: "#0f766e", Foreground: "#ffffff", Accent: "#fde68a",
+		Align: "center", GreetingScale: 5, NameScale: 12, Margin: 60,
+	},
+}
+
+func (t *cardTemplate) validate() error {
+	switch {
+	case t.Width < 64 || t.Width > 4096 || t.Height < 64 || t.Height > 4096:
+		return fmt.Errorf("size %dx%d is outside 64x64 to 4096x4096", t.Width, t.Height)
+	case t.GreetingScale < 1 || t.GreetingScale > 64 || t.NameScale < 1 || t.NameScale > 64:
+		return fmt.Errorf("scales must be between 1 and 64")
+	case t.Margin < 0 || 2*t.Margin >= t.Width || 2*t.Margin >= t.Height:
+		return fmt.Errorf("margin %d does not leave room for content", t.Margin)
+	case t.Align != "left" && t.Align != "center":
+		return fmt.Errorf("align must be left or center, not %q", t.Align)
+	}
+	for _, c := range []string{t.Background, t.Foreground, t.Accent} {
+		if _, err := parseHexColor(c); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func parseHexColor(s string) (color.RGBA, error) {
+	if len(s) != 7 || s[0] != '#' {
+		return color.RGBA{}, fmt.Errorf("color %q is not #rrggbb", s)
+	}
+	v, err := strconv.ParseUint(s[1:], 16, 32)
+	if err != nil {
+		return color.RGBA{}, fmt.Errorf("color %q is not #rrggbb", s)
+	}
+	return color.RGBA{R: uint8(v >> 16), G: uint8(v >> 8), B: uint8(v), A: 0xff}, nil
+}
+
+// loadCardTemplates reads templates from a JSON object of names to
+// templates, adding to or replacing the built-in ones. Fields a template
+// leaves out are those of the classic template, for example:
+//
+//	{"dark-square": {"width": 1080, "height": 1080, "background": "#000000", "align": "center"}}
+func loadCardTemplates(path string) (map[string]cardTemplate, error) {
+	templates := make(map[string]cardTemplate)
+	for name, t := range builtinCardTemplates {
+		templates[name] = t
+	}
+	if path == "" {
+		return templates, nil
+	}
+	var raw map[string]json.RawMessage
+	if err := readJSONFile(path, &raw); err != nil {
+		return nil, err
+	}
+	for name, msg := range raw {
+		t := builtinCardTemplates[defaultCardTemplate]
+		if err := json.Unmarshal(msg, &t); err != nil {
+			return nil, fmt.Errorf("template %q: %v", name, err)
+		}
+		if err := t.validate(); err != nil {
+			return nil, fmt.Errorf("template %q: %v", name, err)
+		}
+		templates[name] = t
+	}
+	return templates, nil
+}
+
+// cardRenderer draws welcome cards and caches them by a hash of everything
+// that goes into them.
+type cardRenderer struct {
+	templates map[string]cardTemplate
+	cache     *cardCache
+}
+
+func newCardRenderer(templates map[string]cardTemplate) *cardRenderer {
+	return &cardRenderer{templates: templates, cache: newCardCache(cardCacheBytes)}
+}
+
+// render returns the PNG image of a card and its content hash.
+func (r *cardRenderer) render(t cardTemplate, greeting, name string) ([]byte, string, error) {
+	key, err := json.Marshal(struct {
+		Template       cardTemplate
+		Greeting, Name string
+	}{t, greeting, name})
+	if err != nil {
+		return nil, "", err
+	}
+	sum := sha256.Sum256(key)
+	etag := hex.EncodeToString(sum[:])
+	if data, ok := r.cache.get(etag); ok {
+		return data, etag, nil
+	}
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, drawCard(t, greeting, name)); err != nil {
+		return nil, "", err
+	}
+	r.cache.put(etag, buf.Bytes())
+	return buf.Bytes(), etag, nil
+}
+
+// Color indexes in the palette of a card.
+const (
+	cardBackground = iota
+	cardForeground
+	cardAccent
+)
+
+// drawCard lays out t with an ASCII greeting and name, which may be empty.
+func drawCard(t cardTemplate, greeting, name string) *image.Paletted {
+	bg, _ := parseHexColor(t.Background)
+	fg, _ := parseHexColor(t.Foreground)
+	accent, _ := parseHexColor(t.Accent)
+	img := image.NewPaletted(image.Rect(0, 0, t.Width, t.Height), color.Palette{bg, fg, accent})
+
+	// A stripe along the edge, thinner than the margin.
+	stripe := t.Margin / 4
+	if t.Align == "left" {
+		fillRect(img, image.Rect(0, 0, stripe, t.Height), cardAccent)
+	} else {
+		fillRect(img, image.Rect(0, 0, t.Width, stripe), cardAccent)
+		fillRect(img, image.Rect(0, t.Height-stripe, t.Width, t.Height), cardAccent)
+	}
+
+	contentWidth := t.Width - 2*t.Margin
+	contentHeight := t.Height - 2*t.Margin
+	gs := t.GreetingScale
+	// The greeting, the rule and the space around it.
+	header := (glyphHeight + 8) * gs
+	var (
+		lines []string
+		ns    int
+	)
+	for ns = t.NameScale; ns > 1; ns-- {
+		lines = wrapText(name, contentWidth/(glyphAdvance*ns))
+		if len(lines) <= maxCardNameLines && header+(len(lines)*lineAdvance-3)*ns <= contentHeight {
+			break
+		}
+	}
+	if ns == 1 {
+		lines = wrapText(name, contentWidth/glyphAdvance)
+	}
+	if len(lines) > maxCardNameLines {
+		lines = lines[:maxCardNameLines]
+	}
+
+	x := func(width int) int {
+		if t.Align == "left" {
+			return t.Margin
+		}
+		return (t.Width - width) / 2
+	}
+	y := t.Margin + (contentHeight-header-(len(lines)*lineAdvance-3)*ns)/2
+	drawText(img, image.Pt(x(textWidth(greeting, gs)), y), greeting, gs, cardForeground)
+	y += (glyphHeight + 3) * gs
+	ruleWidth := 8 * glyphAdvance * gs
+	fillRect(img, image.Rect(x(ruleWidth), y, x(ruleWidth)+ruleWidth, y+gs), cardAccent)
+	y += 5 * gs
+	for _, l := range lines {
+		drawText(img, image.Pt(x(textWidth(l, ns)), y), l, ns, cardForeground)
+		y += lineAdvance * ns
+	}
+	return img
+}
+
+// wrapText breaks s into lines of at most width characters, between words
+// where possible.
+func wrapText(s string, width int) []string {
+	if width < 1 {
+		width = 1
+	}
+	var lines []string
+	line := ""
+	for _, w := range strings.Fields(s) {
+		for len(w) > width {
+			if line != "" {
+				lines = append(lines, line)
+				line = ""
+			}
+			lines = append(lines, w[:width])
+			w = w[width:]
+		}
+		switch {
+		case line == "":
+			line = w
+		case len(line)+1+len(w) <= width:
+			line += " " + w
+		default:
+			lines = append(lines, line)
+			line = w
+		}
+	}
+	if line != "" {
+		lines = append(lines, line)
+	}
+	return lines
+}
+
+// cardCache keeps recently rendered cards, evicting the least recently used
+// ones beyond a total size.
+type cardCache struct {
+	mu    sync.Mutex
+	max   int
+	size  int
+	order *list.List // of *cardCacheEntry, most recently used first
+	items map[string]*list.Element
+}
+
+type cardCacheEntry struct {
+	key  string
+	data []byte
+}
+
+func newCardCache(max int) *cardCache {
+	return &cardCache{max: max, order: list.New(), items: make(map[string]*list.Element)}
+}
+
+func (c *cardCache) get(key string) ([]byte, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	e, ok := c.items[key]
+	if !ok {
+		return nil, false
+	}
+	c.order.MoveToFront(e)
+	return e.Value.(*cardCacheEntry).data, true
+}
+
+func (c *cardCache) put(key string, data []byte) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, ok := c.items[key]; ok || len(data) > c.max {
+		return
+	}
+	c.items[key] = c.order.PushFront(&cardCacheEntry{key: key, data: data})
+	c.size += len(data)
+	for c.size > c.max {
+		e := c.order.Back()
+		old := e.Value.(*cardCacheEntry)
+		c.order.Remove(e)
+		delete(c.items, old.key)
+		c.size -= len(old.data)
+	}
+}
+
+// RenderWelcomeCard streams a PNG welcome card in chunks.
+func (s *server) RenderWelcomeCard(in *pb.RenderWelcomeCardRequest, stream pb.WelcomeService_RenderWelcomeCardServer) error {
+	name := normalizeName(in.GetName())
+	if in.GetTitleCase() {
+		name = titleCase(name)
+	}
+	if name == "" {
+		return status.Error(codes.InvalidArgument, "name is required")
+	}
+	// Names the font cannot spell out get their initials, or failing that
+	// the greeting alone.
+	text := asciiName(name)
+	if text == "" {
+		text = nameInitials(name)
+	}
+	tname := in.GetTemplate()
+	if tname == "" {
+		tname = defaultCardTemplate
+	}
+	t, ok := s.cards.templates[tname]
+	if !ok {
+		return status.Errorf(codes.InvalidArgument, "unknown template %q", tname)
+	}
+	if bg := in.GetBackground(); bg != "" {
+		if _, err := parseHexColor(bg); err != nil {
+			return status.Error(codes.InvalidArgument, err.Error())
+		}
+		t.Background = bg
+	}
+	data, etag, err := s.cards.render(t, welcomeGreeting, text)
+	if err != nil {
+		return status.Errorf(codes.Internal, "failed to render card: %v", err)
+	}
+	for off := 0; off < len(data); off += cardChunkSize {
+		end := off + cardChunkSize
+		if end > len(data) {
+			end = len(data)
+		}
+		chunk := &pb.WelcomeCardChunk{Data: data[off:end]}
+		if off == 0 {
+			chunk.ContentType = "image/png"
+			chunk.TotalSize = int64(len(data))
+			chunk.Etag = etag
+		}
+		if err := stream.Send(chunk); err != nil {
+			return err
+		}
+	}
+	return nil
+}
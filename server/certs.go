@@ -0,0 +1,290 @@
+package main
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"math/big"
+	"net"
+	"net/url"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+)
+
+// The certs command writes these files to -certs_dir, named as the TLS flags
+// of the server and client expect them.
+const (
+	caCertFile     = "ca.crt"
+	caKeyFile      = "ca.key"
+	serverCertFile = "server.crt"
+	serverKeyFile  = "server.key"
+	clientCertFile = "client.crt"
+	clientKeyFile  = "client.key"
+)
+
+// runCerts serves the certs command: it creates a development CA in
+// *certsDir unless there is one, then issues the server and client
+// certificates that are missing, expire within *renewBefore, have other SANs
+// than requested or were not issued by the CA.
+func runCerts() {
+	if err := os.MkdirAll(*certsDir, 0700); err != nil {
+		log.Fatalf("failed to create %s: %v", *certsDir, err)
+	}
+	ca, caKey, err := loadCertAndKey(*certsDir, caCertFile, caKeyFile)
+	switch {
+	case errors.Is(err, fs.ErrNotExist):
+		if ca, caKey, err = issueCA(); err != nil {
+			log.Fatalf("failed to create CA: %v", err)
+		}
+		log.Printf("created CA %s, valid until %s", filepath.Join(*certsDir, caCertFile), ca.NotAfter.Format(time.RFC3339))
+	case err != nil:
+		log.Fatalf("failed to load CA: %v", err)
+	case time.Until(ca.NotAfter) < *renewBefore:
+		// Replacing the CA would invalidate every certificate it issued, so
+		// that is left to the user.
+		log.Printf("warning: CA expires %s; remove %s to create a new one", ca.NotAfter.Format(time.RFC3339), *certsDir)
+	case ca.PublicKeyAlgorithm != keyAlgorithm():
+		log.Printf("warning: the CA key is %s, not %s; remove %s to create a new one", ca.PublicKeyAlgorithm, *keyType, *certsDir)
+	}
+	leaves := []struct {
+		name, certFile, keyFile, sans string
+		usage                         []x509.ExtKeyUsage
+	}{
+		// The server certificate is also valid for client authentication,
+		// because the HTTP endpoints present it when calling the server.
+		{"server", serverCertFile, serverKeyFile, *serverSANs, []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth}},
+		{"client", clientCertFile, clientKeyFile, *clientSANs, []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth}},
+	}
+	for _, l := range leaves {
+		tmpl, err := leafTemplate(l.name, l.sans, l.usage)
+		if err != nil {
+			log.Fatalf("invalid %s SANs: %v", l.name, err)
+		}
+		cert, _, err := loadCertAndKey(*certsDir, l.certFile, l.keyFile)
+		if err == nil {
+			reason := reissueReason(cert, ca, tmpl)
+			if reason == "" {
+				log.Printf("%s certificate is current, valid until %s", l.name, cert.NotAfter.Format(time.RFC3339))
+				continue
+			}
+			log.Printf("re-issuing %s certificate: %s", l.name, reason)
+		} else if !errors.Is(err, fs.ErrNotExist) {
+			log.Printf("re-issuing %s certificate: %v", l.name, err)
+		}
+		cert, err = issueLeaf(tmpl, ca, caKey, l.certFile, l.keyFile)
+		if err != nil {
+			log.Fatalf("failed to issue %s certificate: %v", l.name, err)
+		}
+		log.Printf("issued %s certificate for %s, valid until %s", l.name, l.sans, cert.NotAfter.Format(time.RFC3339))
+	}
+	dir := *certsDir
+	fmt.Printf("server flags: -tls -cert_file %s -key_file %s -client_ca_file %s\n",
+		filepath.Join(dir, serverCertFile), filepath.Join(dir, serverKeyFile), filepath.Join(dir, caCertFile))
+	fmt.Printf("client flags: -tls -ca_file %s -cert_file %s -key_file %s\n",
+		filepath.Join(dir, caCertFile), filepath.Join(dir, clientCertFile), filepath.Join(dir, clientKeyFile))
+}
+
+// reissueReason says why cert needs to be issued again, or returns "".
+func reissueReason(cert, ca *x509.Certificate, want *x509.Certificate) string {
+	switch {
+	case cert.CheckSignatureFrom(ca) != nil:
+		return "not issued by the current CA"
+	case time.Until(cert.NotAfter) < *renewBefore:
+		return "expires " + cert.NotAfter.Format(time.RFC3339)
+	case !sameSANs(cert, want):
+		return "SANs changed"
+	case cert.PublicKeyAlgorithm != keyAlgorithm():
+		return fmt.Sprintf("the key is %s, not %s", cert.PublicKeyAlgorithm, *keyType)
+	}
+	return ""
+}
+
+func sameSANs(a, b *x509.Certificate) bool {
+	names := func(c *x509.Certificate) []string {
+		var out []string
+		out = append(out, c.DNSNames...)
+		for _, ip := range c.IPAddresses {
+			out = append(out, ip.String())
+		}
+		for _, u := range c.URIs {
+			out = append(out, u.String())
+		}
+		sort.Strings(out)
+		return out
+	}
+	return reflect.DeepEqual(names(a), names(b))
+}
+
+// leafTemplate describes a certificate for sans, a comma-separated list of
+// DNS names, IP addresses and URIs.
+func leafTemplate(name, sans string, usage []x509.ExtKeyUsage) (*x509.Certificate, error) {
+	tmpl := &x509.Certificate{
+		Subject:     pkix.Name{CommonName: "welcome " + name},
+		KeyUsage:    x509.KeyUsageDigitalSignature,
+		ExtKeyUsage: usage,
+	}
+	for _, san := range strings.Split(sans, ",") {
+		san = strings.TrimSpace(san)
+		switch {
+		case san == "":
+		case strings.Contains(san, "://"):
+			u, err := url.Parse(san)
+			if err != nil {
+				return nil, err
+			}
+			tmpl.URIs = append(tmpl.URIs, u)
+		case net.ParseIP(san) != nil:
+			tmpl.IPAddresses = append(tmpl.IPAddresses, net.ParseIP(san))
+		default:
+			tmpl.DNSNames = append(tmpl.DNSNames, san)
+		}
+	}
+	if len(tmpl.DNSNames)+len(tmpl.IPAddresses)+len(tmpl.URIs) == 0 {
+		return nil, errors.New("none given")
+	}
+	return tmpl, nil
+}
+
+func issueCA() (*x509.Certificate, crypto.Signer, error) {
+	key, err := newKey()
+	if err != nil {
+		return nil, nil, err
+	}
+	tmpl := &x509.Certificate{
+		Subject:               pkix.Name{CommonName: "welcome development CA"},
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	cert, err := signAndWrite(tmpl, nil, key, key, *caLifetime, caCertFile, caKeyFile)
+	return cert, key, err
+}
+
+func issueLeaf(tmpl, ca *x509.Certificate, caKey crypto.Signer, certFile, keyFile string) (*x509.Certificate, error) {
+	key, err := newKey()
+	if err != nil {
+		return nil, err
+	}
+	return signAndWrite(tmpl, ca, caKey, key, *certLifetime, certFile, keyFile)
+}
+
+// signAndWrite completes tmpl, signs it with parentKey, or self-signs it if
+// parent is nil, and writes the certificate and key to *certsDir.
+func signAndWrite(tmpl, parent *x509.Certificate, parentKey, key crypto.Signer, lifetime time.Duration, certFile, keyFile string) (*x509.Certificate, error) {
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return nil, err
+	}
+	tmpl.SerialNumber = serial
+	tmpl.NotBefore = time.Now().Add(-5 * time.Minute) // tolerates clock skew
+	tmpl.NotAfter = time.Now().Add(lifetime)
+	if parent == nil {
+		parent = tmpl
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, parent, key.Public(), parentKey)
+	if err != nil {
+		return nil, err
+	}
+	keyDER, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return nil, err
+	}
+	// Both are written before either is put in place, and the certificate
+	// goes first: a failure in between leaves the new certificate with the
+	// old key, which loadCertAndKey refuses, so the next run issues again.
+	certTmp, err := writePEMTemp(certFile, "CERTIFICATE", der, 0644)
+	if err != nil {
+		return nil, err
+	}
+	defer os.Remove(certTmp) // fails harmlessly once renamed
+	keyTmp, err := writePEMTemp(keyFile, "PRIVATE KEY", keyDER, 0600)
+	if err != nil {
+		return nil, err
+	}
+	defer os.Remove(keyTmp)
+	if err := os.Rename(certTmp, filepath.Join(*certsDir, certFile)); err != nil {
+		return nil, err
+	}
+	if err := os.Rename(keyTmp, filepath.Join(*certsDir, keyFile)); err != nil {
+		return nil, err
+	}
+	return x509.ParseCertificate(der)
+}
+
+func newKey() (crypto.Signer, error) {
+	switch *keyType {
+	case "ecdsa":
+		return ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	case "ed25519":
+		_, key, err := ed25519.GenerateKey(rand.Reader)
+		return key, err
+	}
+	return nil, fmt.Errorf("unknown key type %q, want ecdsa or ed25519", *keyType)
+}
+
+// keyAlgorithm returns the algorithm of the keys newKey creates.
+func keyAlgorithm() x509.PublicKeyAlgorithm {
+	switch *keyType {
+	case "ecdsa":
+		return x509.ECDSA
+	case "ed25519":
+		return x509.Ed25519
+	}
+	return x509.UnknownPublicKeyAlgorithm
+}
+
+// writePEMTemp writes der as PEM to a temporary file next to name in
+// *certsDir, to be renamed over it, and returns its path.
+func writePEMTemp(name, typ string, der []byte, perm os.FileMode) (string, error) {
+	tmp := filepath.Join(*certsDir, name+".tmp")
+	if err := os.WriteFile(tmp, pem.EncodeToMemory(&pem.Block{Type: typ, Bytes: der}), perm); err != nil {
+		return "", err
+	}
+	return tmp, nil
+}
+
+// loadCertAndKey reads a certificate and its private key from dir.
+func loadCertAndKey(dir, certFile, keyFile string) (*x509.Certificate, crypto.Signer, error) {
+	certPEM, err := os.ReadFile(filepath.Join(dir, certFile))
+	if err != nil {
+		return nil, nil, err
+	}
+	keyPEM, err := os.ReadFile(filepath.Join(dir, keyFile))
+	if err != nil {
+		return nil, nil, err
+	}
+	cb, _ := pem.Decode(certPEM)
+	kb, _ := pem.Decode(keyPEM)
+	if cb == nil || kb == nil {
+		return nil, nil, fmt.Errorf("%s or %s is not PEM", certFile, keyFile)
+	}
+	cert, err := x509.ParseCertificate(cb.Bytes)
+	if err != nil {
+		return nil, nil, err
+	}
+	k, err := x509.ParsePKCS8PrivateKey(kb.Bytes)
+	if err != nil {
+		return nil, nil, err
+	}
+	key, ok := k.(crypto.Signer)
+	if !ok {
+		return nil, nil, fmt.Errorf("%s: unsupported key type %T", keyFile, k)
+	}
+	if pub, ok := key.Public().(interface{ Equal(crypto.PublicKey) bool }); !ok || !pub.Equal(cert.PublicKey) {
+		return nil, nil, fmt.Errorf("%s is not the key of %s", keyFile, certFile)
+	}
+	return cert, key, nil
+}
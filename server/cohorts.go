@@ -0,0 +1,255 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	pb "example.com/grpc-go"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// cohortRecord is a stored cohort.
+type cohortRecord struct {
+	ID        string    `json:"id"`
+	Name      string    `json:"name"`
+	MemberIDs []string  `json:"member_ids"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+func (r *cohortRecord) proto() *pb.Cohort {
+	return &pb.Cohort{
+		Id:        r.ID,
+		Name:      r.Name,
+		MemberIds: append([]string(nil), r.MemberIDs...),
+		CreatedAt: timestamppb.New(r.CreatedAt),
+	}
+}
+
+func (r *cohortRecord) has(memberID string) bool {
+	for _, id := range r.MemberIDs {
+		if id == memberID {
+			return true
+		}
+	}
+	return false
+}
+
+// cohortStore keeps the cohorts and their members in a JSON file.
+type cohortStore struct {
+	mu      sync.Mutex
+	path    string
+	lock    *dataDirLock
+	cohorts map[string]*cohortRecord
+}
+
+// openCohortStore reads the cohorts at path. Writes go through lock, which
+// may be nil.
+func openCohortStore(path string, lock *dataDirLock) (*cohortStore, error) {
+	s := &cohortStore{path: path, lock: lock}
+	if err := s.reload(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// reload reads the cohorts from disk again, replacing those in memory.
+func (s *cohortStore) reload() error {
+	var recs []*cohortRecord
+	if err := readJSONFile(s.path, &recs); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.cohorts = make(map[string]*cohortRecord)
+	for _, r := range recs {
+		s.cohorts[r.ID] = r
+	}
+	return nil
+}
+
+// sorted returns the cohorts in the order they were created. s.mu must be
+// held.
+func (s *cohortStore) sorted() []*cohortRecord {
+	recs := make([]*cohortRecord, 0, len(s.cohorts))
+	for _, r := range s.cohorts {
+		recs = append(recs, r)
+	}
+	sort.Slice(recs, func(i, j int) bool {
+		if !recs[i].CreatedAt.Equal(recs[j].CreatedAt) {
+			return recs[i].CreatedAt.Before(recs[j].CreatedAt)
+		}
+		return recs[i].ID < recs[j].ID
+	})
+	return recs
+}
+
+// save writes all cohorts to disk. s.mu must be held.
+func (s *cohortStore) save() error {
+	err := s.lock.write(func() error { return writeJSONFile(s.path, s.sorted()) })
+	if err == errHandedOff {
+		return err
+	}
+	if err != nil {
+		return status.Errorf(codes.Internal, "failed to save cohorts: %v", err)
+	}
+	return nil
+}
+
+func (s *cohortStore) create(name string) (*pb.Cohort, error) {
+	id := make([]byte, 8)
+	if _, err := rand.Read(id); err != nil {
+		return nil, status.Errorf(codes.Internal, "failed to create cohort ID: %v", err)
+	}
+	r := &cohortRecord{ID: hex.EncodeToString(id), Name: name, MemberIDs: []string{}, CreatedAt: time.Now().UTC()}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.cohorts[r.ID] = r
+	if err := s.save(); err != nil {
+		delete(s.cohorts, r.ID)
+		return nil, err
+	}
+	return r.proto(), nil
+}
+
+func (s *cohortStore) get(id string) (*pb.Cohort, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	r, ok := s.cohorts[id]
+	if !ok {
+		return nil, status.Errorf(codes.NotFound, "no cohort %q", id)
+	}
+	return r.proto(), nil
+}
+
+// list returns the cohorts in the order they were created.
+func (s *cohortStore) list() []*pb.Cohort {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	recs := s.sorted()
+	out := make([]*pb.Cohort, len(recs))
+	for i, r := range recs {
+		out[i] = r.proto()
+	}
+	return out
+}
+
+// addMember adds memberID to cohort id and reports whether it was not a
+// member before.
+func (s *cohortStore) addMember(id, memberID string) (*pb.Cohort, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	r, ok := s.cohorts[id]
+	if !ok {
+		return nil, false, status.Errorf(codes.NotFound, "no cohort %q", id)
+	}
+	if r.has(memberID) {
+		return r.proto(), false, nil
+	}
+	old := r.MemberIDs
+	r.MemberIDs = append(append([]string(nil), old...), memberID)
+	if err := s.save(); err != nil {
+		r.MemberIDs = old
+		return nil, false, err
+	}
+	return r.proto(), true, nil
+}
+
+func (s *cohortStore) removeMember(id, memberID string) (*pb.Cohort, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	r, ok := s.cohorts[id]
+	if !ok {
+		return nil, status.Errorf(codes.NotFound, "no cohort %q", id)
+	}
+	if !r.has(memberID) {
+		return nil, status.Errorf(codes.NotFound, "member %q is not in cohort %q", memberID, id)
+	}
+	old := r.MemberIDs
+	r.MemberIDs = make([]string, 0, len(old)-1)
+	for _, m := range old {
+		if m != memberID {
+			r.MemberIDs = append(r.MemberIDs, m)
+		}
+	}
+	if err := s.save(); err != nil {
+		r.MemberIDs = old
+		return nil, err
+	}
+	return r.proto(), nil
+}
+
+// memberOf returns the IDs of the cohorts memberID is in.
+func (s *cohortStore) memberOf(memberID string) []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var ids []string
+	for _, r := range s.sorted() {
+		if r.has(memberID) {
+			ids = append(ids, r.ID)
+		}
+	}
+	return ids
+}
+
+// cohortServer implements welcome.CohortService.
+type cohortServer struct {
+	pb.UnimplementedCohortServiceServer
+	store   *cohortStore
+	members *memberStore
+	lobbies *lobbyHub
+}
+
+func (s *cohortServer) CreateCohort(ctx context.Context, in *pb.CreateCohortRequest) (*pb.Cohort, error) {
+	name := strings.TrimSpace(in.GetName())
+	if name == "" || !utf8.ValidString(name) || len(name) > 200 {
+		return nil, status.Error(codes.InvalidArgument, "name must be non-empty valid UTF-8 of at most 200 bytes")
+	}
+	return s.store.create(name)
+}
+
+func (s *cohortServer) ListCohorts(ctx context.Context, in *pb.ListCohortsRequest) (*pb.ListCohortsResponse, error) {
+	offset, n, err := page(in.GetPageSize(), in.GetPageToken())
+	if err != nil {
+		return nil, err
+	}
+	cohorts := s.store.list()
+	start, end, next := pageBounds(offset, n, len(cohorts))
+	return &pb.ListCohortsResponse{Cohorts: cohorts[start:end], NextPageToken: next}, nil
+}
+
+func (s *cohortServer) AddCohortMember(ctx context.Context, in *pb.AddCohortMemberRequest) (*pb.Cohort, error) {
+	m, err := s.members.get(in.GetMemberId())
+	if err != nil {
+		return nil, err
+	}
+	c, added, err := s.store.addMember(in.GetCohortId(), m.ID)
+	if err != nil {
+		return nil, err
+	}
+	if added {
+		s.lobbies.publish(c.GetId(), &pb.LobbyEvent{
+			Type:       pb.LobbyEvent_WELCOME,
+			MemberId:   m.ID,
+			MemberName: m.Name,
+			Message:    "Say hi to " + m.Name,
+		})
+	}
+	return c, nil
+}
+
+func (s *cohortServer) RemoveCohortMember(ctx context.Context, in *pb.RemoveCohortMemberRequest) (*pb.Cohort, error) {
+	c, err := s.store.removeMember(in.GetCohortId(), in.GetMemberId())
+	if err != nil {
+		return nil, err
+	}
+	s.lobbies.kick(c.GetId(), in.GetMemberId())
+	return c, nil
+}
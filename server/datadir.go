@@ -0,0 +1,149 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"syscall"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// errHandedOff is returned for writes to the data directory after the
+// process handed it over to a new one in an upgrade.
+var errHandedOff = status.Error(codes.Unavailable, "the server is being upgraded and no longer accepts changes; retry")
+
+// dataDirLock is an exclusive lock on the data directory, so that only one
+// process ever writes it. The server holds it while it runs, and the
+// commands that rewrite the directory take it too. In an upgrade the old
+// process hands it to the new one, refusing writes while it drains, and
+// takes it back if the upgrade fails.
+type dataDirLock struct {
+	path string
+	// flush saves state kept in memory before the lock is handed off, and
+	// reload reads the state again after it is taken back, as the new
+	// process may have changed it.
+	flush  []func() error
+	reload []func() error
+
+	mu        sync.RWMutex // held for reading by writes to the directory
+	f         *os.File     // holds the lock; nil while it is handed off
+	handedOff bool
+}
+
+// lockDataDir takes the lock on dir, creating dir if needed. It fails at
+// once if another process holds the lock.
+func lockDataDir(dir string) (*dataDirLock, error) {
+	l := &dataDirLock{path: filepath.Join(dir, "LOCK")}
+	if err := l.lock(); err != nil {
+		return nil, err
+	}
+	return l, nil
+}
+
+// lock takes the lock file and writes the process ID into it.
+func (l *dataDirLock) lock() error {
+	if err := os.MkdirAll(filepath.Dir(l.path), 0700); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(l.path, os.O_CREATE|os.O_RDWR, 0600)
+	if err != nil {
+		return err
+	}
+	if err := syscall.Flock(int(f.Fd()), syscall.LOCK_EX|syscall.LOCK_NB); err != nil {
+		f.Close()
+		if err != syscall.EWOULDBLOCK {
+			return err
+		}
+		holder := "another process"
+		if pid, _ := os.ReadFile(l.path); len(bytes.TrimSpace(pid)) > 0 {
+			holder = "process " + string(bytes.TrimSpace(pid))
+		}
+		return fmt.Errorf("%s is in use by %s; stop the server first", filepath.Dir(l.path), holder)
+	}
+	if err := f.Truncate(0); err == nil {
+		f.WriteAt([]byte(strconv.Itoa(os.Getpid())+"\n"), 0)
+	}
+	l.f = f
+	return nil
+}
+
+// Close releases the lock.
+func (l *dataDirLock) Close() error {
+	if l == nil || l.f == nil {
+		return nil
+	}
+	err := l.f.Close()
+	l.f = nil
+	return err
+}
+
+// write runs fn, which writes to the data directory, unless the directory
+// has been handed off. A nil lock runs fn as it is, for stores that are not
+// in a locked directory.
+func (l *dataDirLock) write(fn func() error) error {
+	if l == nil {
+		return fn()
+	}
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	if l.handedOff {
+		return errHandedOff
+	}
+	return fn()
+}
+
+// handOff flushes the state kept in memory, waits for writes in progress
+// and releases the lock. Later writes fail with errHandedOff.
+func (l *dataDirLock) handOff() error {
+	if l == nil {
+		return nil
+	}
+	for _, flush := range l.flush {
+		if err := flush(); err != nil {
+			return err
+		}
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.handedOff = true
+	f := l.f
+	l.f = nil
+	return f.Close()
+}
+
+// reclaim takes the lock back after a failed upgrade, waiting up to
+// shutdownTimeout for the new process to let go of it, and reloads the
+// state. Writes stay refused if that fails.
+func (l *dataDirLock) reclaim() error {
+	if l == nil {
+		return nil
+	}
+	deadline := time.Now().Add(shutdownTimeout)
+	for {
+		l.mu.Lock()
+		err := l.lock()
+		l.mu.Unlock()
+		if err == nil {
+			break
+		}
+		if time.Now().After(deadline) {
+			return err
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	for _, reload := range l.reload {
+		if err := reload(); err != nil {
+			return fmt.Errorf("failed to reload: %v", err)
+		}
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.handedOff = false
+	return nil
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"sync"
+
+	pb "example.com/grpc-go"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// eventHub numbers welcome events and keeps the most recent ones in a ring
+// buffer, so that a subscriber whose stream dropped can resume without gaps
+// or duplicates as long as it reconnects before its position is evicted.
+// Numbering starts over in every process, so events also carry a random
+// epoch that tells subscribers when their position no longer exists.
+type eventHub struct {
+	epoch  uint64
+	mu     sync.Mutex
+	buf    []*pb.WelcomeEvent
+	last   uint64        // sequence number of the newest event
+	notify chan struct{} // closed and replaced on every publish
+}
+
+func newEventHub(size int) (*eventHub, error) {
+	if size < 1 {
+		size = 1
+	}
+	var epoch [8]byte
+	for binary.BigEndian.Uint64(epoch[:]) == 0 {
+		if _, err := rand.Read(epoch[:]); err != nil {
+			return nil, err
+		}
+	}
+	return &eventHub{
+		epoch:  binary.BigEndian.Uint64(epoch[:]),
+		buf:    make([]*pb.WelcomeEvent, size),
+		notify: make(chan struct{}),
+	}, nil
+}
+
+// publish assigns the next sequence number to a welcome and wakes up all
+// subscribers waiting for it.
+func (h *eventHub) publish(name, message string) *pb.WelcomeEvent {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.last++
+	ev := &pb.WelcomeEvent{
+		Seq:     h.last,
+		Name:    name,
+		Message: message,
+		SentAt:  timestamppb.Now(),
+		Epoch:   h.epoch,
+	}
+	h.buf[h.last%uint64(len(h.buf))] = ev
+	close(h.notify)
+	h.notify = make(chan struct{})
+	return ev
+}
+
+// latest returns the sequence number of the newest event.
+func (h *eventHub) latest() uint64 {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.last
+}
+
+// since returns the buffered events with a sequence number greater than seq,
+// along with a channel that is closed once a newer event is published. It
+// fails with OutOfRange if some of those events were already evicted, or if
+// seq is ahead of the stream (for example after a server restart).
+func (h *eventHub) since(seq uint64) ([]*pb.WelcomeEvent, <-chan struct{}, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if seq > h.last {
+		return nil, nil, status.Errorf(codes.OutOfRange, "sequence %d is ahead of the latest event %d", seq, h.last)
+	}
+	oldest := uint64(1)
+	if n := uint64(len(h.buf)); h.last > n {
+		oldest = h.last - n + 1
+	}
+	if seq+1 < oldest {
+		return nil, nil, status.Errorf(codes.OutOfRange, "events after %d are no longer buffered, oldest is %d", seq, oldest)
+	}
+	evs := make([]*pb.WelcomeEvent, 0, h.last-seq)
+	for s := seq + 1; s <= h.last; s++ {
+		evs = append(evs, h.buf[s%uint64(len(h.buf))])
+	}
+	return evs, h.notify, nil
+}
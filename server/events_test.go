@@ -0,0 +1,115 @@
+package main
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	pb "example.com/grpc-go"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+)
+
+// startWelcomeServer serves a WelcomeService with events from hub and
+// returns a client of it.
+func startWelcomeServer(t *testing.T, hub *eventHub) pb.WelcomeServiceClient {
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := grpc.NewServer()
+	pb.RegisterWelcomeServiceServer(s, &server{events: hub})
+	go s.Serve(lis)
+	t.Cleanup(s.Stop)
+	cc, err := grpc.Dial(lis.Addr().String(), grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { cc.Close() })
+	return pb.NewWelcomeServiceClient(cc)
+}
+
+// droppingClient breaks the first SubscribeWelcomes stream once its header
+// arrived, before any event, and publishes events before handing it back.
+type droppingClient struct {
+	pb.WelcomeServiceClient
+	hub     *eventHub
+	dropped bool
+}
+
+func (c *droppingClient) SubscribeWelcomes(ctx context.Context, in *pb.SubscribeWelcomesRequest, opts ...grpc.CallOption) (pb.WelcomeService_SubscribeWelcomesClient, error) {
+	if c.dropped {
+		return c.WelcomeServiceClient.SubscribeWelcomes(ctx, in, opts...)
+	}
+	c.dropped = true
+	ctx, cancel := context.WithCancel(ctx)
+	stream, err := c.WelcomeServiceClient.SubscribeWelcomes(ctx, in, opts...)
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	if _, err := stream.Header(); err != nil {
+		cancel()
+		return nil, err
+	}
+	cancel()
+	for _, name := range []string{"Ann", "Bo", "Cy"} {
+		c.hub.publish(name, welcomeGreeting+" "+name)
+	}
+	return stream, nil
+}
+
+func TestSubscriberResumesBeforeFirstEvent(t *testing.T) {
+	hub, err := newEventHub(16)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Events from before the subscription are not delivered.
+	hub.publish("Old", welcomeGreeting+" Old")
+	hub.publish("Older", welcomeGreeting+" Older")
+	s := &pb.Subscriber{
+		Client:     &droppingClient{WelcomeServiceClient: startWelcomeServer(t, hub), hub: hub},
+		MinBackoff: time.Millisecond,
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	var got []string
+	err = s.Run(ctx, func(ev *pb.WelcomeEvent) {
+		got = append(got, ev.GetName())
+		if len(got) == 3 {
+			cancel()
+		}
+	})
+	if err != context.Canceled {
+		t.Fatalf("Run: %v, got %v", err, got)
+	}
+	if len(got) != 3 || got[0] != "Ann" || got[1] != "Bo" || got[2] != "Cy" {
+		t.Errorf("got %v, want [Ann Bo Cy]", got)
+	}
+	if s.Epoch != hub.epoch || s.LastSeq != 5 {
+		t.Errorf("ended at #%d of epoch %d, want #5 of epoch %d", s.LastSeq, s.Epoch, hub.epoch)
+	}
+}
+
+func TestSubscribeFromStartOfEpoch(t *testing.T) {
+	hub, err := newEventHub(16)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := startWelcomeServer(t, hub)
+	hub.publish("Ann", welcomeGreeting+" Ann")
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	stream, err := c.SubscribeWelcomes(ctx, &pb.SubscribeWelcomesRequest{Epoch: hub.epoch})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ev, err := stream.Recv()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ev.GetSeq() != 1 {
+		t.Errorf("got #%d, want #1 with resume_after 0 and an epoch", ev.GetSeq())
+	}
+}
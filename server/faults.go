@@ -0,0 +1,226 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	pb "example.com/grpc-go"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/encoding"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+)
+
+// adminServicePrefix is exempt from fault injection, so that faults can
+// always be turned off again.
+const adminServicePrefix = "/welcome.AdminService/"
+
+// faultInjector makes calls misbehave on purpose, according to rules set
+// through the AdminService or to the x-fault header of the call itself. The
+// header holds comma-separated faults, for example
+// "delay=500ms,abort=UNAVAILABLE", "corrupt" or "truncate".
+type faultInjector struct {
+	mu    sync.RWMutex
+	rules []*pb.FaultRule
+}
+
+// fault is what happens to one call.
+type fault struct {
+	delay    time.Duration
+	abort    codes.Code
+	message  string
+	corrupt  bool
+	truncate bool
+}
+
+func (f *faultInjector) setRules(rules []*pb.FaultRule) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.rules = rules
+}
+
+func (f *faultInjector) getRules() []*pb.FaultRule {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	return f.rules
+}
+
+// validateFaultRule reports what is wrong with r, if anything.
+func validateFaultRule(r *pb.FaultRule) error {
+	switch {
+	case r.GetMethod() == "":
+		return fmt.Errorf("method is required")
+	case r.GetPercent() < 0 || r.GetPercent() > 100:
+		return fmt.Errorf("percent %v is not between 0 and 100", r.GetPercent())
+	case r.GetDelay() != nil && (r.GetDelay().CheckValid() != nil || r.GetDelay().AsDuration() < 0):
+		return fmt.Errorf("invalid delay %v", r.GetDelay())
+	case r.GetAbortCode() < 0 || r.GetAbortCode() > 16:
+		return fmt.Errorf("abort_code %d is not a gRPC status code", r.GetAbortCode())
+	}
+	return nil
+}
+
+// faultFor decides the fault for a call to method: the x-fault header if
+// present, otherwise the first matching rule that the dice select.
+func (f *faultInjector) faultFor(ctx context.Context, method string) (fault, error) {
+	if strings.HasPrefix(method, adminServicePrefix) {
+		return fault{}, nil
+	}
+	md, _ := metadata.FromIncomingContext(ctx)
+	if v := md.Get("x-fault"); len(v) > 0 {
+		return parseFaultHeader(strings.Join(v, ","))
+	}
+	for _, r := range f.getRules() {
+		if !matchMethod([]string{r.GetMethod()}, method) {
+			continue
+		}
+		if p := r.GetPercent(); p > 0 && rand.Float64()*100 >= p {
+			continue
+		}
+		return fault{
+			delay:    r.GetDelay().AsDuration(),
+			abort:    codes.Code(r.GetAbortCode()),
+			message:  r.GetAbortMessage(),
+			corrupt:  r.GetCorrupt(),
+			truncate: r.GetTruncate(),
+		}, nil
+	}
+	return fault{}, nil
+}
+
+func parseFaultHeader(h string) (fault, error) {
+	var ft fault
+	for _, part := range strings.Split(h, ",") {
+		key, value := strings.TrimSpace(part), ""
+		if i := strings.IndexByte(key, '='); i >= 0 {
+			key, value = key[:i], key[i+1:]
+		}
+		switch key {
+		case "delay":
+			d, err := time.ParseDuration(value)
+			if err != nil || d < 0 {
+				return fault{}, status.Errorf(codes.InvalidArgument, "x-fault: invalid delay %q", value)
+			}
+			ft.delay = d
+		case "abort":
+			// Codes are accepted by number or by name, such as UNAVAILABLE.
+			if ft.abort.UnmarshalJSON([]byte(value)) != nil &&
+				ft.abort.UnmarshalJSON([]byte(strconv.Quote(strings.ToUpper(value)))) != nil {
+				return fault{}, status.Errorf(codes.InvalidArgument, "x-fault: invalid status code %q", value)
+			}
+		case "corrupt":
+			ft.corrupt = true
+		case "truncate":
+			ft.truncate = true
+		case "":
+		default:
+			return fault{}, status.Errorf(codes.InvalidArgument, "x-fault: unknown fault %q", key)
+		}
+	}
+	return ft, nil
+}
+
+// before applies the delay and abort parts of ft.
+func (ft fault) before(ctx context.Context) error {
+	if ft.delay > 0 {
+		t := time.NewTimer(ft.delay)
+		defer t.Stop()
+		select {
+		case <-t.C:
+		case <-ctx.Done():
+			return status.FromContextError(ctx.Err()).Err()
+		}
+	}
+	if ft.abort != codes.OK {
+		msg := ft.message
+		if msg == "" {
+			msg = "injected fault"
+		}
+		return status.Error(ft.abort, msg)
+	}
+	return nil
+}
+
+// mangle returns m encoded and then corrupted or truncated as ft asks, for
+// faultCodec to send as is. Other messages are returned unchanged.
+func (ft fault) mangle(m interface{}) interface{} {
+	msg, ok := m.(proto.Message)
+	if !ok || !ft.corrupt && !ft.truncate {
+		return m
+	}
+	data, err := proto.Marshal(msg)
+	if err != nil {
+		return m
+	}
+	if ft.truncate {
+		data = data[:len(data)/2]
+	}
+	if ft.corrupt {
+		for i := range data {
+			data[i] ^= 0xff
+		}
+	}
+	return &data
+}
+
+func (f *faultInjector) unaryInterceptor(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	ft, err := f.faultFor(ctx, info.FullMethod)
+	if err != nil {
+		return nil, err
+	}
+	if err := ft.before(ctx); err != nil {
+		return nil, err
+	}
+	resp, err := handler(ctx, req)
+	if err != nil {
+		return resp, err
+	}
+	return ft.mangle(resp), nil
+}
+
+func (f *faultInjector) streamInterceptor(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	ft, err := f.faultFor(ss.Context(), info.FullMethod)
+	if err != nil {
+		return err
+	}
+	if err := ft.before(ss.Context()); err != nil {
+		return err
+	}
+	if ft.corrupt || ft.truncate {
+		ss = &faultyStream{ServerStream: ss, fault: ft}
+	}
+	return handler(srv, ss)
+}
+
+type faultyStream struct {
+	grpc.ServerStream
+	fault fault
+}
+
+func (s *faultyStream) SendMsg(m interface{}) error {
+	return s.ServerStream.SendMsg(s.fault.mangle(m))
+}
+
+// faultCodec is the regular proto codec, except that it sends *[]byte values
+// as they are, which is how mangled messages reach the wire.
+type faultCodec struct {
+	encoding.Codec
+}
+
+func newFaultCodec() faultCodec {
+	return faultCodec{encoding.GetCodec("proto")}
+}
+
+func (c faultCodec) Marshal(v interface{}) ([]byte, error) {
+	if data, ok := v.(*[]byte); ok {
+		return *data, nil
+	}
+	return c.Codec.Marshal(v)
+}
@@ -0,0 +1,312 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"google.golang.org/genproto/googleapis/api/annotations"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+)
+
+const maxHTTPBody = 4 << 20
+
+// httpBinding is one HTTP route declared with a google.api.http annotation.
+type httpBinding struct {
+	verb     string
+	path     string   // the template as written, e.g. /v1/members/{id}
+	segments []string // path split on "/", variables kept as "{field}"
+	body     string
+	method   protoreflect.MethodDescriptor
+}
+
+// fullMethod returns the gRPC method name, e.g. /welcome.WelcomeService/SendWelcome.
+func (b *httpBinding) fullMethod() string {
+	return "/" + string(b.method.Parent().FullName()) + "/" + string(b.method.Name())
+}
+
+// pathParams returns the field names bound by path variables.
+func (b *httpBinding) pathParams() []string {
+	var params []string
+	for _, seg := range b.segments {
+		if strings.HasPrefix(seg, "{") {
+			params = append(params, strings.Trim(seg, "{}"))
+		}
+	}
+	return params
+}
+
+// match reports whether the request path matches the template and returns
+// the values of the path variables.
+func (b *httpBinding) match(verb, path string) (map[string]string, bool) {
+	if verb != b.verb {
+		return nil, false
+	}
+	parts := strings.Split(path, "/")
+	if len(parts) != len(b.segments) {
+		return nil, false
+	}
+	params := make(map[string]string)
+	for i, seg := range b.segments {
+		if strings.HasPrefix(seg, "{") {
+			if parts[i] == "" {
+				return nil, false
+			}
+			params[strings.Trim(seg, "{}")] = parts[i]
+		} else if seg != parts[i] {
+			return nil, false
+		}
+	}
+	return params, true
+}
+
+// httpBindings collects the HTTP bindings of the unary methods declared in fd.
+func httpBindings(fd protoreflect.FileDescriptor) []*httpBinding {
+	var bindings []*httpBinding
+	for i := 0; i < fd.Services().Len(); i++ {
+		sd := fd.Services().Get(i)
+		for j := 0; j < sd.Methods().Len(); j++ {
+			md := sd.Methods().Get(j)
+			if md.IsStreamingClient() || md.IsStreamingServer() {
+				continue
+			}
+			rule, ok := proto.GetExtension(md.Options(), annotations.E_Http).(*annotations.HttpRule)
+			if !ok || rule == nil {
+				continue
+			}
+			for _, r := range append([]*annotations.HttpRule{rule}, rule.GetAdditionalBindings()...) {
+				if b := newHTTPBinding(r, md); b != nil {
+					bindings = append(bindings, b)
+				}
+			}
+		}
+	}
+	return bindings
+}
+
+func newHTTPBinding(rule *annotations.HttpRule, md protoreflect.MethodDescriptor) *httpBinding {
+	b := &httpBinding{body: rule.GetBody(), method: md}
+	switch p := rule.GetPattern().(type) {
+	case *annotations.HttpRule_Get:
+		b.verb, b.path = http.MethodGet, p.Get
+	case *annotations.HttpRule_Put:
+		b.verb, b.path = http.MethodPut, p.Put
+	case *annotations.HttpRule_Post:
+		b.verb, b.path = http.MethodPost, p.Post
+	case *annotations.HttpRule_Delete:
+		b.verb, b.path = http.MethodDelete, p.Delete
+	case *annotations.HttpRule_Patch:
+		b.verb, b.path = http.MethodPatch, p.Patch
+	case *annotations.HttpRule_Custom:
+		b.verb, b.path = p.Custom.GetKind(), p.Custom.GetPath()
+	default:
+		return nil
+	}
+	b.segments = strings.Split(b.path, "/")
+	return b
+}
+
+// gateway serves the unary RPCs that have HTTP bindings as JSON over HTTP.
+// Requests are forwarded through cc, so every server interceptor applies.
+type gateway struct {
+	cc       *grpc.ClientConn
+	bindings []*httpBinding
+}
+
+func newGateway(cc *grpc.ClientConn, fd protoreflect.FileDescriptor) *gateway {
+	return &gateway{cc: cc, bindings: httpBindings(fd)}
+}
+
+func (g *gateway) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	for _, b := range g.bindings {
+		if params, ok := b.match(r.Method, r.URL.Path); ok {
+			g.serve(w, r, b, params)
+			return
+		}
+	}
+	writeHTTPStatus(w, status.Newf(codes.NotFound, "no route for %s %s", r.Method, r.URL.Path))
+}
+
+func (g *gateway) serve(w http.ResponseWriter, r *http.Request, b *httpBinding, params map[string]string) {
+	in, err := newMessage(b.method.Input())
+	if err != nil {
+		writeHTTPStatus(w, status.New(codes.Internal, err.Error()))
+		return
+	}
+	if err := decodeHTTPRequest(r, b, params, in); err != nil {
+		writeHTTPStatus(w, status.New(codes.InvalidArgument, err.Error()))
+		return
+	}
+	ctx := r.Context()
+	if tok := bearerToken(r); tok != "" {
+		ctx = metadata.AppendToOutgoingContext(ctx, "authorization", "Bearer "+tok)
+	}
+	out, err := newMessage(b.method.Output())
+	if err == nil {
+		err = g.cc.Invoke(ctx, b.fullMethod(), in, out)
+	}
+	if err != nil {
+		writeHTTPStatus(w, status.Convert(err))
+		return
+	}
+	data, err := protojson.Marshal(out)
+	if err != nil {
+		writeHTTPStatus(w, status.New(codes.Internal, err.Error()))
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(data)
+}
+
+// decodeHTTPRequest fills in from the body, the path variables and, for
+// fields not covered by the body, the query string.
+func decodeHTTPRequest(r *http.Request, b *httpBinding, params map[string]string, in proto.Message) error {
+	msg := in.ProtoReflect()
+	if b.body != "" {
+		data, err := ioutil.ReadAll(io.LimitReader(r.Body, maxHTTPBody+1))
+		if err != nil {
+			return err
+		}
+		if len(data) > maxHTTPBody {
+			return fmt.Errorf("request body exceeds %d bytes", maxHTTPBody)
+		}
+		if len(data) > 0 {
+			target := msg
+			if b.body != "*" {
+				fd := msg.Descriptor().Fields().ByName(protoreflect.Name(b.body))
+				if fd == nil || fd.Message() == nil {
+					return fmt.Errorf("body field %q is not a message field", b.body)
+				}
+				target = msg.Mutable(fd).Message()
+			}
+			if err := protojson.Unmarshal(data, target.Interface()); err != nil {
+				return err
+			}
+		}
+	}
+	for name, value := range params {
+		if err := setFieldFromString(msg, name, value); err != nil {
+			return err
+		}
+	}
+	if b.body != "*" {
+		for name, values := range r.URL.Query() {
+			for _, v := range values {
+				if err := setFieldFromString(msg, name, v); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// setFieldFromString sets a top-level scalar field, or appends to a repeated
+// one, from its textual form. Fields are looked up by proto or JSON name.
+func setFieldFromString(msg protoreflect.Message, name, value string) error {
+	fields := msg.Descriptor().Fields()
+	fd := fields.ByName(protoreflect.Name(name))
+	if fd == nil {
+		fd = fields.ByJSONName(name)
+	}
+	if fd == nil || fd.IsMap() || fd.Message() != nil {
+		return fmt.Errorf("unknown or unsupported parameter %q", name)
+	}
+	v, err := parseScalar(fd, value)
+	if err != nil {
+		return fmt.Errorf("parameter %q: %v", name, err)
+	}
+	if fd.IsList() {
+		msg.Mutable(fd).List().Append(v)
+	} else {
+		msg.Set(fd, v)
+	}
+	return nil
+}
+
+func parseScalar(fd protoreflect.FieldDescriptor, s string) (protoreflect.Value, error) {
+	switch fd.Kind() {
+	case protoreflect.StringKind:
+		return protoreflect.ValueOfString(s), nil
+	case protoreflect.BytesKind:
+		return protoreflect.ValueOfBytes([]byte(s)), nil
+	case protoreflect.BoolKind:
+		v, err := strconv.ParseBool(s)
+		return protoreflect.ValueOfBool(v), err
+	case protoreflect.EnumKind:
+		if ev := fd.Enum().Values().ByName(protoreflect.Name(s)); ev != nil {
+			return protoreflect.ValueOfEnum(ev.Number()), nil
+		}
+		n, err := strconv.ParseInt(s, 10, 32)
+		return protoreflect.ValueOfEnum(protoreflect.EnumNumber(n)), err
+	case protoreflect.Int32Kind, protoreflect.Sint32Kind, protoreflect.Sfixed32Kind:
+		n, err := strconv.ParseInt(s, 10, 32)
+		return protoreflect.ValueOfInt32(int32(n)), err
+	case protoreflect.Int64Kind, protoreflect.Sint64Kind, protoreflect.Sfixed64Kind:
+		n, err := strconv.ParseInt(s, 10, 64)
+		return protoreflect.ValueOfInt64(n), err
+	case protoreflect.Uint32Kind, protoreflect.Fixed32Kind:
+		n, err := strconv.ParseUint(s, 10, 32)
+		return protoreflect.ValueOfUint32(uint32(n)), err
+	case protoreflect.Uint64Kind, protoreflect.Fixed64Kind:
+		n, err := strconv.ParseUint(s, 10, 64)
+		return protoreflect.ValueOfUint64(n), err
+	case protoreflect.FloatKind:
+		f, err := strconv.ParseFloat(s, 32)
+		return protoreflect.ValueOfFloat32(float32(f)), err
+	case protoreflect.DoubleKind:
+		f, err := strconv.ParseFloat(s, 64)
+		return protoreflect.ValueOfFloat64(f), err
+	}
+	return protoreflect.Value{}, fmt.Errorf("unsupported kind %v", fd.Kind())
+}
+
+// writeHTTPStatus writes st as a JSON google.rpc.Status with the matching
+// HTTP status code.
+func writeHTTPStatus(w http.ResponseWriter, st *status.Status) {
+	data, err := protojson.Marshal(st.Proto())
+	if err != nil {
+		data = []byte(`{"code":13,"message":"failed to encode error"}`)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(httpStatusFromCode(st.Code()))
+	w.Write(data)
+}
+
+// httpStatusFromCode follows the mapping documented in google/rpc/code.proto.
+func httpStatusFromCode(c codes.Code) int {
+	switch c {
+	case codes.OK:
+		return http.StatusOK
+	case codes.Canceled:
+		return 499
+	case codes.InvalidArgument, codes.FailedPrecondition, codes.OutOfRange:
+		return http.StatusBadRequest
+	case codes.DeadlineExceeded:
+		return http.StatusGatewayTimeout
+	case codes.NotFound:
+		return http.StatusNotFound
+	case codes.AlreadyExists, codes.Aborted:
+		return http.StatusConflict
+	case codes.PermissionDenied:
+		return http.StatusForbidden
+	case codes.Unauthenticated:
+		return http.StatusUnauthorized
+	case codes.ResourceExhausted:
+		return http.StatusTooManyRequests
+	case codes.Unimplemented:
+		return http.StatusNotImplemented
+	case codes.Unavailable:
+		return http.StatusServiceUnavailable
+	}
+	return http.StatusInternalServerError
+}
@@ -0,0 +1,237 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+)
+
+// principal is the workload identity of a caller, taken from the verified
+// client certificate.
+type principal struct {
+	// ID is the identity the caller is known by: its first URI SAN, such as
+	// spiffe://example.org/ns/prod/sa/frontend, or else its first DNS SAN.
+	ID string
+	// Names are all URI and DNS SANs of the certificate.
+	Names []string
+}
+
+// peerPrincipal returns the identity in the client certificate of the call
+// in ctx, if the connection uses mutual TLS.
+func peerPrincipal(ctx context.Context) (principal, bool) {
+	p, ok := peer.FromContext(ctx)
+	if !ok {
+		return principal{}, false
+	}
+	info, ok := p.AuthInfo.(credentials.TLSInfo)
+	if !ok || len(info.State.VerifiedChains) == 0 || len(info.State.VerifiedChains[0]) == 0 {
+		return principal{}, false
+	}
+	leaf := info.State.VerifiedChains[0][0]
+	var pr principal
+	for _, u := range leaf.URIs {
+		pr.Names = append(pr.Names, u.String())
+	}
+	pr.Names = append(pr.Names, leaf.DNSNames...)
+	if len(pr.Names) == 0 {
+		return principal{}, false
+	}
+	pr.ID = pr.Names[0]
+	return pr, true
+}
+
+type principalContextKey struct{}
+
+// principalFromContext returns the identity of the caller, as stored by the
+// identity interceptors.
+func principalFromContext(ctx context.Context) (principal, bool) {
+	p, ok := ctx.Value(principalContextKey{}).(principal)
+	return p, ok
+}
+
+// rbacPolicy allows calls by identity. It is read from a JSON file such as
+//
+//	{
+//	  "rules": [
+//	    {"principals": ["spiffe://example.org/ns/prod/*"], "methods": ["/welcome.WelcomeService/*"]},
+//	    {"principals": ["spiffe://example.org/ns/ops/sa/admin"], "methods": ["*"]}
+//	  ]
+//	}
+//
+// A call is allowed if any rule lists one of the caller's names and the
+// method. A principal pattern ending in "*" matches by prefix; methods
+// follow matchMethod. Everything else is denied, including calls without a
+// client certificate. Calls through the HTTP endpoints have the principal
+// internal:http-gateway.
+type rbacPolicy struct {
+	Rules []rbacRule `json:"rules"`
+}
+
+type rbacRule struct {
+	Principals []string `json:"principals"`
+	Methods    []string `json:"methods"`
+}
+
+func loadRBACPolicy(path string) (*rbacPolicy, error) {
+	p := &rbacPolicy{}
+	if err := readJSONFile(path, p); err != nil {
+		return nil, err
+	}
+	if len(p.Rules) == 0 {
+		return nil, fmt.Errorf("%s: no rules", path)
+	}
+	return p, nil
+}
+
+func (p *rbacPolicy) allows(pr principal, method string) bool {
+	for _, r := range p.Rules {
+		if matchMethod(r.Methods, method) && matchPrincipal(r.Principals, pr.Names) {
+			return true
+		}
+	}
+	return false
+}
+
+func matchPrincipal(patterns, names []string) bool {
+	for _, pat := range patterns {
+		for _, n := range names {
+			if pat == n || strings.HasSuffix(pat, "*") && strings.HasPrefix(n, strings.TrimSuffix(pat, "*")) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// loopbackPrincipal is the identity of calls made by the HTTP endpoints to
+// the server itself. Their connection presents the server's own
+// certificate, but they act for HTTP callers who presented none, so they are
+// only allowed by rules that name this principal.
+const loopbackPrincipal = "internal:http-gateway"
+
+// identityConfig is how callers are identified and authorized.
+type identityConfig struct {
+	// policy, if not nil, lists what each identity may call.
+	policy *rbacPolicy
+	// loopbackCert is the server's own certificate in DER, which identifies
+	// calls from the HTTP endpoints.
+	loopbackCert []byte
+	// requireClientCert is set under mutual TLS. Without a policy, loopback
+	// calls are then only allowed if trustLoopback is set, because API keys
+	// authenticate the HTTP callers instead.
+	requireClientCert bool
+	trustLoopback     bool
+}
+
+// isLoopback reports whether the call in ctx came from the HTTP endpoints.
+func (c *identityConfig) isLoopback(ctx context.Context) bool {
+	p, ok := peer.FromContext(ctx)
+	if !ok {
+		return false
+	}
+	info, ok := p.AuthInfo.(credentials.TLSInfo)
+	return ok && len(info.State.PeerCertificates) > 0 && bytes.Equal(info.State.PeerCertificates[0].Raw, c.loopbackCert)
+}
+
+// identify stores the caller's principal in ctx and checks that the caller
+// may call method.
+func (c *identityConfig) identify(ctx context.Context, method string) (context.Context, error) {
+	pr, ok := peerPrincipal(ctx)
+	loopback := ok && c.isLoopback(ctx)
+	if loopback {
+		pr = principal{ID: loopbackPrincipal, Names: []string{loopbackPrincipal}}
+	}
+	if e, _ := ctx.Value(accessEntryContextKey{}).(*accessEntry); e != nil && ok {
+		e.principal = pr.ID
+	}
+	if loopback && c.policy == nil && c.requireClientCert && !c.trustLoopback {
+		return nil, status.Error(codes.Unauthenticated, "calls through the HTTP endpoints are not authenticated under mutual TLS; enable -require_api_key or an -rbac_policy that grants "+loopbackPrincipal)
+	}
+	if ok {
+		ctx = context.WithValue(ctx, principalContextKey{}, pr)
+	}
+	if c.policy == nil {
+		return ctx, nil
+	}
+	if !ok {
+		return nil, status.Error(codes.Unauthenticated, "a client certificate with a URI or DNS SAN is required")
+	}
+	if !c.policy.allows(pr, method) {
+		return nil, status.Errorf(codes.PermissionDenied, "%s may not call %s", pr.ID, method)
+	}
+	return context.WithValue(ctx, rbacAdminContextKey{}, c.policy.allows(pr, adminServicePrefix+"*")), nil
+}
+
+// rbacAdminContextKey marks calls by principals that the policy grants the
+// whole AdminService; see isAdmin.
+type rbacAdminContextKey struct{}
+
+func identityUnaryInterceptor(c *identityConfig) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		ctx, err := c.identify(ctx, info.FullMethod)
+		if err != nil {
+			return nil, err
+		}
+		return handler(ctx, req)
+	}
+}
+
+func identityStreamInterceptor(c *identityConfig) grpc.StreamServerInterceptor {
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		ctx, err := c.identify(ss.Context(), info.FullMethod)
+		if err != nil {
+			return err
+		}
+		return handler(srv, &contextStream{ServerStream: ss, ctx: ctx})
+	}
+}
+
+// accessEntry is who made a call, as the interceptors after the access log
+// find out. It is filled in even for calls they refuse.
+type accessEntry struct {
+	principal string // as resolved by identify
+	apiKeyID  string
+}
+
+type accessEntryContextKey struct{}
+
+// logAccess writes one access log line for a finished call.
+func logAccess(ctx context.Context, e *accessEntry, method string, start time.Time, err error) {
+	who, key := "-", "-"
+	if e.principal != "" {
+		who = e.principal
+	}
+	if e.apiKeyID != "" {
+		key = e.apiKeyID
+	}
+	addr := "-"
+	if p, ok := peer.FromContext(ctx); ok {
+		addr = p.Addr.String()
+	}
+	log.Printf("access: %s %s peer=%s principal=%s api_key=%s duration=%v", method, status.Code(err), addr, who, key, time.Since(start).Round(time.Microsecond))
+}
+
+func accessLogUnaryInterceptor(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	start := time.Now()
+	e := &accessEntry{}
+	resp, err := handler(context.WithValue(ctx, accessEntryContextKey{}, e), req)
+	logAccess(ctx, e, info.FullMethod, start, err)
+	return resp, err
+}
+
+func accessLogStreamInterceptor(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	start := time.Now()
+	e := &accessEntry{}
+	err := handler(srv, &contextStream{ServerStream: ss, ctx: context.WithValue(ss.Context(), accessEntryContextKey{}, e)})
+	logAccess(ss.Context(), e, info.FullMethod, start, err)
+	return err
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+)
+
+// readJSONFile decodes the JSON file at path into v. A missing file leaves v
+// untouched and is not an error.
+func readJSONFile(path string, v interface{}) error {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
+
+// writeJSONFile replaces the file at path with v encoded as JSON. The data
+// is written to a temporary file that is renamed over path, so readers and
+// crashes never see a partial file. Missing directories are created.
+func writeJSONFile(path string, v interface{}) error {
+	data, err := encodeJSONFile(v)
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(path, data)
+}
+
+// encodeJSONFile encodes v as writeJSONFile writes it.
+func encodeJSONFile(v interface{}) ([]byte, error) {
+	data, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return append(data, '\n'), nil
+}
+
+// writeFileAtomic replaces the file at path with data, like writeJSONFile.
+func writeFileAtomic(path string, data []byte) error {
+	dir := filepath.Dir(path)
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return err
+	}
+	f, err := os.CreateTemp(dir, "."+filepath.Base(path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name()) // fails harmlessly once renamed
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), path)
+}
@@ -0,0 +1,229 @@
+package main
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+)
+
+// sealedPrefix starts every encrypted value, which reads
+// "enc1:<key ID>:<wrapped data key>:<ciphertext>" with both binary parts in
+// unpadded base64url. Values without it are plaintext, as written before
+// encryption was enabled.
+const sealedPrefix = "enc1:"
+
+// keyringFile is the JSON file with the master keys. Keys are never removed
+// by the server, since data may still be encrypted with them.
+type keyringFile struct {
+	Primary string       `json:"primary"`
+	Keys    []keyringKey `json:"keys"`
+}
+
+type keyringKey struct {
+	ID        string    `json:"id"`
+	Key       []byte    `json:"key"` // 32 bytes, base64 in JSON
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// keyring encrypts values with envelope encryption: each value gets a fresh
+// AES-256-GCM data key, which is stored with it wrapped by the primary
+// master key. Values sealed with older master keys can still be opened, so
+// rotating the primary key needs no immediate re-encryption.
+type keyring struct {
+	primary string
+	keys    map[string]cipher.AEAD
+}
+
+// loadKeyring reads the keyring file at path.
+func loadKeyring(path string) (*keyring, error) {
+	var f keyringFile
+	if err := readJSONFile(path, &f); err != nil {
+		return nil, err
+	}
+	if len(f.Keys) == 0 {
+		return nil, fmt.Errorf("%s has no keys; create one with the rotate-key command", path)
+	}
+	k := &keyring{primary: f.Primary, keys: make(map[string]cipher.AEAD)}
+	for _, key := range f.Keys {
+		if len(key.Key) != 32 {
+			return nil, fmt.Errorf("key %q is %d bytes, not 32", key.ID, len(key.Key))
+		}
+		aead, err := newGCM(key.Key)
+		if err != nil {
+			return nil, err
+		}
+		k.keys[key.ID] = aead
+	}
+	if k.keys[k.primary] == nil {
+		return nil, fmt.Errorf("primary key %q is not in %s", k.primary, path)
+	}
+	return k, nil
+}
+
+// rotateKeyring adds a new master key to the keyring file at path, creating
+// it if needed, and makes it the primary key. It returns the ID of the key.
+func rotateKeyring(path string) (string, error) {
+	var f keyringFile
+	if err := readJSONFile(path, &f); err != nil {
+		return "", err
+	}
+	key := make([]byte, 32)
+	id := make([]byte, 4)
+	if _, err := rand.Read(key); err != nil {
+		return "", err
+	}
+	if _, err := rand.Read(id); err != nil {
+		return "", err
+	}
+	k := keyringKey{ID: hex.EncodeToString(id), Key: key, CreatedAt: time.Now().UTC()}
+	f.Keys = append(f.Keys, k)
+	f.Primary = k.ID
+	if err := writeJSONFile(path, f); err != nil {
+		return "", err
+	}
+	return k.ID, nil
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// seal encrypts a value under the primary key. The context, such as the
+// record and field the value belongs to, is authenticated with it, so that
+// a sealed value cannot be moved elsewhere. Empty values and a nil keyring
+// leave the value as it is.
+func (k *keyring) seal(value, context string) (string, error) {
+	if k == nil || value == "" {
+		return value, nil
+	}
+	dataKey := make([]byte, 32)
+	if _, err := rand.Read(dataKey); err != nil {
+		return "", err
+	}
+	data, err := newGCM(dataKey)
+	if err != nil {
+		return "", err
+	}
+	wrapped, err := gcmSeal(k.keys[k.primary], dataKey, []byte(k.primary))
+	if err != nil {
+		return "", err
+	}
+	ct, err := gcmSeal(data, []byte(value), []byte(context))
+	if err != nil {
+		return "", err
+	}
+	return sealedPrefix + k.primary + ":" + base64.RawURLEncoding.EncodeToString(wrapped) + ":" + base64.RawURLEncoding.EncodeToString(ct), nil
+}
+
+// open decrypts a value sealed with the same context, returning plaintext
+// values as they are. It also reports whether the value is stale: sealed
+// with another key than the primary one, or not sealed although it could
+// be.
+func (k *keyring) open(value, context string) (plain string, stale bool, err error) {
+	if !strings.HasPrefix(value, sealedPrefix) {
+		return value, k != nil && value != "", nil
+	}
+	if k == nil {
+		return "", false, errors.New("value is encrypted but no keyring is configured")
+	}
+	parts := strings.Split(strings.TrimPrefix(value, sealedPrefix), ":")
+	if len(parts) != 3 {
+		return "", false, errors.New("malformed encrypted value")
+	}
+	master := k.keys[parts[0]]
+	if master == nil {
+		return "", false, fmt.Errorf("value is encrypted with key %q, which is not in the keyring", parts[0])
+	}
+	wrapped, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return "", false, errors.New("malformed encrypted value")
+	}
+	ct, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return "", false, errors.New("malformed encrypted value")
+	}
+	dataKey, err := gcmOpen(master, wrapped, []byte(parts[0]))
+	if err != nil {
+		return "", false, fmt.Errorf("failed to unwrap data key: %v", err)
+	}
+	data, err := newGCM(dataKey)
+	if err != nil {
+		return "", false, err
+	}
+	pt, err := gcmOpen(data, ct, []byte(context))
+	if err != nil {
+		return "", false, fmt.Errorf("failed to decrypt value: %v", err)
+	}
+	return string(pt), parts[0] != k.primary, nil
+}
+
+// gcmSeal encrypts plaintext with a random nonce, which precedes the
+// ciphertext.
+func gcmSeal(aead cipher.AEAD, plaintext, additional []byte) ([]byte, error) {
+	nonce := make([]byte, aead.NonceSize(), aead.NonceSize()+len(plaintext)+aead.Overhead())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return aead.Seal(nonce, nonce, plaintext, additional), nil
+}
+
+func gcmOpen(aead cipher.AEAD, sealed, additional []byte) ([]byte, error) {
+	if len(sealed) < aead.NonceSize() {
+		return nil, errors.New("ciphertext too short")
+	}
+	return aead.Open(nil, sealed[:aead.NonceSize()], sealed[aead.NonceSize():], additional)
+}
+
+// runRotateKey adds a new primary key to -keyring_file.
+func runRotateKey() {
+	if *keyringPath == "" {
+		log.Fatalf("rotate-key needs -keyring_file")
+	}
+	id, err := rotateKeyring(*keyringPath)
+	if err != nil {
+		log.Fatalf("failed to rotate key: %v", err)
+	}
+	log.Printf("key %s is now the primary key in %s; once the server is restarted, data is re-encrypted with it as it is written, or at once with the reencrypt command", id, *keyringPath)
+}
+
+// flagKeyring loads -keyring_file, or returns nil if it is not set.
+func flagKeyring() *keyring {
+	if *keyringPath == "" {
+		return nil
+	}
+	keys, err := loadKeyring(*keyringPath)
+	if err != nil {
+		log.Fatalf("failed to load keyring: %v", err)
+	}
+	return keys
+}
+
+// runReencrypt re-encrypts the member data in -data_dir under the primary
+// key of -keyring_file.
+func runReencrypt() {
+	keys := flagKeyring()
+	if keys == nil {
+		log.Fatalf("reencrypt needs -keyring_file")
+	}
+	data, err := lockDataDir(*dataDir)
+	if err != nil {
+		log.Fatalf("failed to lock data directory: %v", err)
+	}
+	defer data.Close()
+	n, err := reencryptMemberLog(*dataDir, keys)
+	if err != nil {
+		log.Fatalf("failed to re-encrypt members: %v", err)
+	}
+	log.Printf("re-encrypted %d member events with key %s; older keys are no longer needed for %s, but may be for its backups", n, keys.primary, *dataDir)
+}
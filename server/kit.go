@@ -0,0 +1,110 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	pb "example.com/grpc-go"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	// kitChunkSize is the size of every WelcomeKitChunk but the last.
+	kitChunkSize = 64 << 10
+	// kitDigestTrailer carries the hex SHA-256 digest of a downloaded file.
+	kitDigestTrailer = "x-content-sha256"
+)
+
+// openKitFile opens the regular file at the slash-separated path rel inside
+// dir, refusing paths and symbolic links that lead outside it.
+func openKitFile(dir, rel string) (*os.File, error) {
+	clean := path.Clean("/" + rel)
+	if rel == "" || clean == "/" || strings.Contains(rel, "\\") {
+		return nil, status.Errorf(codes.InvalidArgument, "invalid path %q", rel)
+	}
+	root, err := filepath.EvalSymlinks(dir)
+	if err != nil {
+		return nil, status.Errorf(codes.FailedPrecondition, "welcome kit unavailable: %v", err)
+	}
+	p, err := filepath.EvalSymlinks(filepath.Join(root, filepath.FromSlash(clean[1:])))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, status.Errorf(codes.NotFound, "no file %q in the welcome kit", rel)
+	}
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "failed to open %q: %v", rel, err)
+	}
+	if !strings.HasPrefix(p, root+string(filepath.Separator)) {
+		return nil, status.Errorf(codes.NotFound, "no file %q in the welcome kit", rel)
+	}
+	f, err := os.Open(p)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "failed to open %q: %v", rel, err)
+	}
+	if fi, err := f.Stat(); err != nil || !fi.Mode().IsRegular() {
+		f.Close()
+		return nil, status.Errorf(codes.NotFound, "no file %q in the welcome kit", rel)
+	}
+	return f, nil
+}
+
+// DownloadWelcomeKit streams a file from -kit_dir. The bytes before the
+// offset are read too, so that the digest in the trailer covers the whole
+// file and a resumed download can be verified.
+func (s *server) DownloadWelcomeKit(in *pb.DownloadWelcomeKitRequest, stream pb.WelcomeService_DownloadWelcomeKitServer) error {
+	f, err := openKitFile(s.kitDir, in.GetPath())
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	fi, err := f.Stat()
+	if err != nil {
+		return status.Errorf(codes.Internal, "failed to read %q: %v", in.GetPath(), err)
+	}
+	size, offset := fi.Size(), in.GetOffset()
+	if offset < 0 || offset > size {
+		return status.Errorf(codes.OutOfRange, "offset %d is outside the %d bytes of %q", offset, size, in.GetPath())
+	}
+	h := sha256.New()
+	if _, err := io.CopyN(h, f, offset); err != nil {
+		return status.Errorf(codes.Internal, "failed to read %q: %v", in.GetPath(), err)
+	}
+	first := true
+	for {
+		// Every chunk gets its own buffer, as a sent message must not change.
+		buf := make([]byte, kitChunkSize)
+		n, err := io.ReadFull(f, buf)
+		if err == io.EOF && !first {
+			break
+		}
+		if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+			return status.Errorf(codes.Internal, "failed to read %q: %v", in.GetPath(), err)
+		}
+		h.Write(buf[:n])
+		chunk := &pb.WelcomeKitChunk{Offset: offset, Data: buf[:n]}
+		if first {
+			chunk.TotalSize = size
+			first = false
+		}
+		if err := stream.Send(chunk); err != nil {
+			return err
+		}
+		offset += int64(n)
+		if n < len(buf) {
+			break
+		}
+	}
+	if offset != size {
+		return status.Errorf(codes.Aborted, "%q changed while it was sent", in.GetPath())
+	}
+	stream.SetTrailer(metadata.Pairs(kitDigestTrailer, hex.EncodeToString(h.Sum(nil))))
+	return nil
+}
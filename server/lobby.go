@@ -0,0 +1,216 @@
+package main
+
+import (
+	"sync"
+
+	pb "example.com/grpc-go"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+const (
+	// lobbyBacklog is how many recent events of a lobby are sent to streams
+	// that join it.
+	lobbyBacklog = 20
+	// lobbyQueue is how many events a lobby stream may fall behind before it
+	// is ended, so that a slow client never holds up the others.
+	lobbyQueue = 64
+)
+
+// lobbySub is one JoinLobby stream.
+type lobbySub struct {
+	memberID string
+	name     string
+	events   chan *pb.LobbyEvent
+	done     chan struct{} // closed when the hub ends the stream
+	err      error         // why the stream was ended, set before done is closed
+}
+
+type lobbyRoom struct {
+	seq     uint64
+	backlog []*pb.LobbyEvent
+	subs    map[*lobbySub]bool
+	present map[string]int // streams per member
+}
+
+// lobbyHub passes the events of each cohort's lobby to the streams in it.
+// Events are never waited on: a stream whose queue is full is ended instead.
+type lobbyHub struct {
+	mu    sync.Mutex
+	rooms map[string]*lobbyRoom
+}
+
+func newLobbyHub() *lobbyHub {
+	return &lobbyHub{rooms: make(map[string]*lobbyRoom)}
+}
+
+// room returns the lobby of a cohort, creating it if needed. h.mu must be
+// held.
+func (h *lobbyHub) room(cohortID string) *lobbyRoom {
+	r := h.rooms[cohortID]
+	if r == nil {
+		r = &lobbyRoom{subs: make(map[*lobbySub]bool), present: make(map[string]int)}
+		h.rooms[cohortID] = r
+	}
+	return r
+}
+
+// publish numbers ev and sends it to everyone in the lobby of a cohort.
+func (h *lobbyHub) publish(cohortID string, ev *pb.LobbyEvent) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.send(cohortID, ev)
+}
+
+// send is publish with h.mu held.
+func (h *lobbyHub) send(cohortID string, ev *pb.LobbyEvent) {
+	r := h.room(cohortID)
+	r.seq++
+	ev.Seq = r.seq
+	ev.SentAt = timestamppb.Now()
+	r.backlog = append(r.backlog, ev)
+	if len(r.backlog) > lobbyBacklog {
+		r.backlog = append(r.backlog[:0:0], r.backlog[len(r.backlog)-lobbyBacklog:]...)
+	}
+	var slow []*lobbySub
+	for sub := range r.subs {
+		select {
+		case sub.events <- ev:
+		default:
+			slow = append(slow, sub)
+		}
+	}
+	for _, sub := range slow {
+		h.end(cohortID, sub, status.Errorf(codes.ResourceExhausted, "fell more than %d events behind in the lobby", lobbyQueue))
+	}
+}
+
+// join enters a stream of memberID into the lobby of a cohort, queuing the
+// backlog for it. Everyone is told when a member enters with its first
+// stream.
+func (h *lobbyHub) join(cohortID, memberID, name string) *lobbySub {
+	sub := &lobbySub{
+		memberID: memberID,
+		name:     name,
+		events:   make(chan *pb.LobbyEvent, lobbyQueue),
+		done:     make(chan struct{}),
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	r := h.room(cohortID)
+	for _, ev := range r.backlog {
+		ev = proto.Clone(ev).(*pb.LobbyEvent)
+		ev.Backlog = true
+		sub.events <- ev
+	}
+	r.subs[sub] = true
+	r.present[memberID]++
+	if r.present[memberID] == 1 {
+		h.send(cohortID, &pb.LobbyEvent{
+			Type:       pb.LobbyEvent_JOINED,
+			MemberId:   memberID,
+			MemberName: name,
+			Message:    name + " joined the lobby",
+		})
+	}
+	return sub
+}
+
+// leave takes a stream out of the lobby of a cohort, if the hub has not
+// ended it already.
+func (h *lobbyHub) leave(cohortID string, sub *lobbySub) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.remove(cohortID, sub)
+}
+
+// end takes a stream out of the lobby and stops it with err. h.mu must be
+// held.
+func (h *lobbyHub) end(cohortID string, sub *lobbySub, err error) {
+	if h.remove(cohortID, sub) {
+		sub.err = err
+		close(sub.done)
+	}
+}
+
+// kick ends the streams of a member removed from a cohort.
+func (h *lobbyHub) kick(cohortID, memberID string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for sub := range h.room(cohortID).subs {
+		if sub.memberID == memberID {
+			h.end(cohortID, sub, status.Errorf(codes.PermissionDenied, "member %q was removed from cohort %q", memberID, cohortID))
+		}
+	}
+}
+
+// remove takes a stream out of the lobby, telling everyone if it was the
+// last one of its member, and reports whether it was still in. h.mu must be
+// held.
+func (h *lobbyHub) remove(cohortID string, sub *lobbySub) bool {
+	r := h.room(cohortID)
+	if !r.subs[sub] {
+		return false
+	}
+	delete(r.subs, sub)
+	r.present[sub.memberID]--
+	if r.present[sub.memberID] == 0 {
+		delete(r.present, sub.memberID)
+		h.send(cohortID, &pb.LobbyEvent{
+			Type:       pb.LobbyEvent_LEFT,
+			MemberId:   sub.memberID,
+			MemberName: sub.name,
+			Message:    sub.name + " left the lobby",
+		})
+	}
+	return true
+}
+
+func (s *cohortServer) JoinLobby(in *pb.JoinLobbyRequest, stream pb.CohortService_JoinLobbyServer) error {
+	if err := checkActsAs(stream.Context(), in.GetMemberId()); err != nil {
+		return err
+	}
+	m, err := s.members.get(in.GetMemberId())
+	if err != nil {
+		return err
+	}
+	if err := s.checkMember(in.GetCohortId(), m.ID); err != nil {
+		return err
+	}
+	sub := s.lobbies.join(in.GetCohortId(), m.ID, m.Name)
+	defer s.lobbies.leave(in.GetCohortId(), sub)
+	// The member may have been removed while joining, after kick looked for
+	// its streams.
+	if err := s.checkMember(in.GetCohortId(), m.ID); err != nil {
+		return err
+	}
+	ctx := stream.Context()
+	for {
+		select {
+		case ev := <-sub.events:
+			if err := stream.Send(ev); err != nil {
+				return err
+			}
+		case <-sub.done:
+			return sub.err
+		case <-ctx.Done():
+			return status.FromContextError(ctx.Err()).Err()
+		}
+	}
+}
+
+// checkMember returns an error unless memberID is in cohort id.
+func (s *cohortServer) checkMember(id, memberID string) error {
+	c, err := s.store.get(id)
+	if err != nil {
+		return err
+	}
+	for _, m := range c.GetMemberIds() {
+		if m == memberID {
+			return nil
+		}
+	}
+	return status.Errorf(codes.PermissionDenied, "member %q is not in cohort %q", memberID, id)
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+
+	pb "example.com/grpc-go"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// lobbyStream is a JoinLobby stream that hands events to a channel.
+type lobbyStream struct {
+	grpc.ServerStream
+	ctx    context.Context
+	events chan *pb.LobbyEvent
+}
+
+func (s *lobbyStream) Context() context.Context { return s.ctx }
+
+func (s *lobbyStream) Send(ev *pb.LobbyEvent) error {
+	s.events <- ev
+	return nil
+}
+
+func TestJoinLobbyActsAsKeyMember(t *testing.T) {
+	dir := t.TempDir()
+	members, err := openMemberStore(dir, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cohorts, err := openCohortStore(filepath.Join(dir, "cohorts.json"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &cohortServer{store: cohorts, members: members, lobbies: newLobbyHub()}
+	c, err := cohorts.create("Autumn")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var ids []string
+	for _, name := range []string{"Ann", "Bo"} {
+		m, err := members.create(name, "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, _, err := cohorts.addMember(c.GetId(), m.ID); err != nil {
+			t.Fatal(err)
+		}
+		ids = append(ids, m.ID)
+	}
+	ctx, cancel := context.WithCancel(context.WithValue(context.Background(), apiKeyContextKey{}, apiKeyRecord{ID: "k1", MemberID: ids[0]}))
+	defer cancel()
+
+	stream := &lobbyStream{ctx: ctx, events: make(chan *pb.LobbyEvent, 16)}
+	err = s.JoinLobby(&pb.JoinLobbyRequest{CohortId: c.GetId(), MemberId: ids[1]}, stream)
+	if status.Code(err) != codes.PermissionDenied {
+		t.Errorf("joining as another member: got %v, want PermissionDenied", err)
+	}
+	select {
+	case ev := <-stream.events:
+		t.Errorf("sent %v to a stream that may not join", ev)
+	default:
+	}
+
+	done := make(chan error, 1)
+	go func() { done <- s.JoinLobby(&pb.JoinLobbyRequest{CohortId: c.GetId(), MemberId: ids[0]}, stream) }()
+	for {
+		select {
+		case ev := <-stream.events:
+			if ev.GetType() != pb.LobbyEvent_JOINED || ev.GetMemberId() != ids[0] {
+				continue
+			}
+		case err := <-done:
+			t.Fatalf("joining as the key's member: %v", err)
+		case <-time.After(5 * time.Second):
+			t.Fatal("no JOINED event for the key's member")
+		}
+		break
+	}
+	cancel()
+	<-done
+}
@@ -2,40 +2,455 @@ package main
 
 import (
 	"context"
+	"errors"
 	"flag"
 	"fmt"
+	"io"
 	"log"
 	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strconv"
+	"syscall"
+	"time"
 
 	pb "example.com/grpc-go"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
 )
 
+// shutdownTimeout bounds how long calls may take to finish on shutdown.
+const shutdownTimeout = 10 * time.Second
+
 var (
-	port = flag.Int("port", 50051, "The server port")
+	port          = flag.Int("port", 50051, "The server port")
+	replayBuffer  = flag.Int("replay_buffer", 1024, "Number of recent welcome events kept for resuming subscribers")
+	httpPort      = flag.Int("http_port", 8080, "The port for HTTP endpoints such as the WebSocket bridge, 0 to disable")
+	wsOrigins     = flag.String("ws_allowed_origins", "", "Comma-separated origins, such as https://app.example.com, of the web pages that may open WebSockets besides the server's own")
+	recordFile    = flag.String("record_file", "", "If set, record RPCs to this file in the gRPC binary log format")
+	recordSample  = flag.Float64("record_sample", 1, "Fraction of RPCs to record, between 0 and 1")
+	recordMethods = flag.String("record_methods", "", "Comma-separated full method names to record, /package.Service/* for a whole service; empty records all")
+	shadowAddr    = flag.String("shadow_addr", "", "If set, mirror SendWelcome calls to the server at this address and log differences")
+	shadowPercent = flag.Float64("shadow_percent", 100, "Percentage of SendWelcome calls mirrored to the shadow server")
+	dataDir       = flag.String("data_dir", "data", "Directory for the server's persistent state")
+	quotaFile     = flag.String("quota_file", "", "JSON file with the daily and monthly usage quotas of callers; no quotas if empty")
+	requireAPIKey = flag.Bool("require_api_key", false, "Reject calls without a valid API key; keys are managed with the ApiKeyService. The AdminService and the ApiKeyService take keys regardless, unless an -rbac_policy governs them. The first start without keys writes an admin key to admin.key in -data_dir")
+	enableFaults  = flag.Bool("enable_faults", false, "Allow fault injection through the AdminService and the x-fault header; never set in production")
+	avatarLimit   = flag.Int64("avatar_max_bytes", 5<<20, "The largest avatar image accepted by UploadAvatar, in bytes")
+	presenceTTL   = flag.Duration("presence_ttl", 30*time.Second, "How long a member stays online after its last presence heartbeat")
+	kitDir        = flag.String("kit_dir", "kit", "Directory with the welcome kit files served by DownloadWelcomeKit")
+	keyringPath   = flag.String("keyring_file", "", "JSON file with the master keys that member names and emails are encrypted with on disk; plaintext if empty. Keep it apart from -data_dir and its backups")
+	cardTemplates = flag.String("card_templates", "", "JSON file with welcome card templates in addition to the built-in classic, square and banner")
+
+	upgradeTimeout  = flag.Duration("upgrade_timeout", 30*time.Second, "How long a new binary started by SIGUSR2 has to become ready before the upgrade is rolled back")
+	proxyConfigFile = flag.String("proxy_config", "proxy.json", "With the proxy command, the file with the backend pools and routes")
+	usagePeriod     = flag.String("usage_period", "", "With the usage command, only report the days or months starting with this, such as 2026-10")
+	backupFile      = flag.String("backup_file", "", "With the restore command, the backup archive to restore into -data_dir; stop the server first")
+
+	useTLS         = flag.Bool("tls", false, "Serve gRPC over TLS")
+	certFile       = flag.String("cert_file", "", "With -tls, the server certificate file")
+	keyFile        = flag.String("key_file", "", "With -tls, the server private key file")
+	clientCAFile   = flag.String("client_ca_file", "", "With -tls, require client certificates issued by a CA in this file")
+	rbacPolicyFile = flag.String("rbac_policy", "", "With -client_ca_file, the JSON policy of which client identities may call which methods")
+	accessLog      = flag.Bool("access_log", false, "Log every call with its status, caller identity and API key ID")
+
+	certsDir     = flag.String("certs_dir", "certs", "With the certs command, the directory for the CA and the certificates")
+	serverSANs   = flag.String("server_sans", "localhost,127.0.0.1,::1", "With the certs command, comma-separated DNS names, IP addresses and URIs of the server")
+	clientSANs   = flag.String("client_sans", "spiffe://welcome.local/client", "With the certs command, comma-separated DNS names, IP addresses and URIs of the client")
+	keyType      = flag.String("key_type", "ecdsa", "With the certs command, the type of new keys: ecdsa (P-256) or ed25519")
+	caLifetime   = flag.Duration("ca_lifetime", 10*365*24*time.Hour, "With the certs command, how long a new CA is valid")
+	certLifetime = flag.Duration("cert_lifetime", 90*24*time.Hour, "With the certs command, how long new certificates are valid")
+	renewBefore  = flag.Duration("renew_before", 30*24*time.Hour, "With the certs command, re-issue certificates that expire within this time")
 )
 
+// welcomeGreeting precedes the name in welcomes.
+const welcomeGreeting = "Welcome onboard"
+
 // server is used to implement helloworld.GreeterServer.
 type server struct {
 	pb.UnimplementedWelcomeServiceServer
+	events *eventHub
+	stats  *welcomeStats
+	cards  *cardRenderer
+	kitDir string
 }
 
 // SayHello implements helloworld.GreeterServer
 func (s *server) SendWelcome(ctx context.Context, in *pb.WelcomeRequest) (*pb.WelcomeResponse, error) {
 	log.Printf("Received: %v", in.GetName())
-	return &pb.WelcomeResponse{Message: "Welcome onboard " + in.GetName()}, nil
+	name := normalizeName(in.GetName())
+	if in.GetTitleCase() {
+		name = titleCase(name)
+	}
+	msg := welcomeGreeting + " " + name
+	s.stats.record(s.events.publish(name, msg))
+	return &pb.WelcomeResponse{Message: msg, DisplayName: name, AsciiName: asciiName(name)}, nil
+}
+
+// SubscribeWelcomes streams welcome events after in.ResumeAfter, then keeps
+// streaming new ones until the client goes away. The position it starts
+// after goes out in the header before any event, so that a client can
+// resume without gaps even if its stream drops before the first event.
+func (s *server) SubscribeWelcomes(in *pb.SubscribeWelcomesRequest, stream pb.WelcomeService_SubscribeWelcomesServer) error {
+	cursor := in.GetResumeAfter()
+	if e := in.GetEpoch(); e != 0 && e != s.events.epoch {
+		return status.Errorf(codes.OutOfRange, "event %d of epoch %d is gone, the server restarted and is at epoch %d", cursor, e, s.events.epoch)
+	}
+	if cursor == 0 && in.GetEpoch() == 0 {
+		cursor = s.events.latest()
+	}
+	header := metadata.Pairs(
+		"x-welcome-epoch", strconv.FormatUint(s.events.epoch, 10),
+		"x-welcome-resume-after", strconv.FormatUint(cursor, 10),
+	)
+	if err := stream.SendHeader(header); err != nil {
+		return err
+	}
+	for {
+		evs, wait, err := s.events.since(cursor)
+		if err != nil {
+			return err
+		}
+		for _, ev := range evs {
+			if err := stream.Send(ev); err != nil {
+				return err
+			}
+			cursor = ev.GetSeq()
+		}
+		select {
+		case <-wait:
+		case <-stream.Context().Done():
+			return status.FromContextError(stream.Context().Err()).Err()
+		}
+	}
+}
+
+// SendWelcomes greets every name received on the stream.
+func (s *server) SendWelcomes(stream pb.WelcomeService_SendWelcomesServer) error {
+	for {
+		in, err := stream.Recv()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		r, err := s.SendWelcome(stream.Context(), in)
+		if err != nil {
+			return err
+		}
+		if err := stream.Send(r); err != nil {
+			return err
+		}
+	}
+}
+
+// newHTTPServer returns the server for the HTTP endpoints: the WebSocket
+// bridge, the JSON gateway with its OpenAPI document and the API explorer.
+// Calls are bridged to the gRPC server through cc.
+func newHTTPServer(cc *grpc.ClientConn, wsOrigins string) (*http.Server, error) {
+	doc, err := openAPIHandler(openAPIDocument(pb.File_welcome_proto))
+	if err != nil {
+		return nil, err
+	}
+	mux := http.NewServeMux()
+	mux.Handle("/ws/", newWSBridge(cc, wsOrigins))
+	mux.Handle("/v1/", newGateway(cc, pb.File_welcome_proto))
+	mux.Handle("/openapi.json", doc)
+	mux.HandleFunc("/explorer", serveExplorer)
+	return &http.Server{Handler: mux}, nil
+}
+
+// run serves s on lis until SIGTERM or SIGINT, keeping the service manager
+// informed: READY=1 once serving, WATCHDOG=1 for as long as probe keeps
+// returning, and STOPPING=1 when draining starts. probe may be nil. On
+// SIGUSR2 the binary is upgraded in place, handing over data; see upgrade.
+// web, if not nil, is the HTTP server, which is drained along with s.
+func run(s *grpc.Server, lis net.Listener, web *http.Server, data *dataDirLock, probe func()) {
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, syscall.SIGTERM, syscall.SIGINT, syscall.SIGUSR2)
+	errc := make(chan error, 1)
+	go func() { errc <- s.Serve(lis) }()
+	if err := notifyReady(); err != nil {
+		log.Printf("failed to notify service manager: %v", err)
+	}
+	if d := watchdogInterval(); d > 0 {
+		go func() {
+			for range time.Tick(d) {
+				if probe != nil {
+					probe()
+				}
+				sdNotify("WATCHDOG=1")
+			}
+		}()
+	}
+wait:
+	for {
+		select {
+		case err := <-errc:
+			log.Fatalf("failed to serve: %v", err)
+		case sig := <-sigs:
+			if sig != syscall.SIGUSR2 {
+				log.Printf("received %v, shutting down", sig)
+				sdNotify("STOPPING=1")
+				break wait
+			}
+			if err := upgrade(data); err != nil {
+				log.Printf("upgrade failed, still serving: %v", err)
+				continue
+			}
+			log.Printf("upgrade complete, draining")
+			break wait
+		}
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	webStopped := make(chan struct{})
+	go func() {
+		defer close(webStopped)
+		if web != nil && web.Shutdown(ctx) != nil {
+			web.Close()
+		}
+	}()
+	stopped := make(chan struct{})
+	go func() {
+		s.GracefulStop()
+		close(stopped)
+	}()
+	select {
+	case <-stopped:
+	case <-ctx.Done():
+		// Subscriptions never end on their own.
+		log.Printf("calls still running after %v, closing them", shutdownTimeout)
+		s.Stop()
+	}
+	<-webStopped
 }
 
 func main() {
 	flag.Parse()
-	lis, err := net.Listen("tcp", fmt.Sprintf(":%d", *port))
+	if err := inheritListeners(); err != nil {
+		log.Fatalf("failed to inherit listeners: %v", err)
+	}
+	switch flag.Arg(0) {
+	case "proxy":
+		runProxy()
+		return
+	case "certs":
+		runCerts()
+		return
+	case "rotate-key":
+		runRotateKey()
+		return
+	case "reencrypt":
+		runReencrypt()
+		return
+	case "restore":
+		runRestore()
+		return
+	case "usage":
+		if err := writeUsageCSV(os.Stdout, filepath.Join(*dataDir, "usage.json"), *usagePeriod); err != nil {
+			log.Fatalf("failed to export usage: %v", err)
+		}
+		return
+	}
+	if *presenceTTL < minPresenceTTL {
+		log.Fatalf("-presence_ttl must be at least %v", minPresenceTTL)
+	}
+	lis, err := listen("grpc", *port)
 	if err != nil {
 		log.Fatalf("failed to listen: %v", err)
 	}
-	s := grpc.NewServer()
-	pb.RegisterWelcomeServiceServer(s, &server{})
-	log.Printf("server listening at %v", lis.Addr())
-	if err := s.Serve(lis); err != nil {
-		log.Fatalf("failed to serve: %v", err)
+	var (
+		opts   []grpc.ServerOption
+		unary  []grpc.UnaryServerInterceptor
+		stream []grpc.StreamServerInterceptor
+		faults *faultInjector
+		policy *rbacPolicy
+	)
+	if *accessLog {
+		unary = append(unary, accessLogUnaryInterceptor)
+		stream = append(stream, accessLogStreamInterceptor)
+	}
+	loopback := insecure.NewCredentials()
+	if *useTLS {
+		cfg, err := serverTLSConfig(*certFile, *keyFile, *clientCAFile)
+		if err != nil {
+			log.Fatalf("failed to load TLS configuration: %v", err)
+		}
+		opts = append(opts, grpc.Creds(credentials.NewTLS(cfg)))
+		loopback = loopbackCredentials(cfg)
+		if *rbacPolicyFile != "" {
+			if *clientCAFile == "" {
+				log.Fatalf("-rbac_policy requires -client_ca_file")
+			}
+			if policy, err = loadRBACPolicy(*rbacPolicyFile); err != nil {
+				log.Fatalf("failed to load RBAC policy: %v", err)
+			}
+		}
+		ids := &identityConfig{
+			policy:            policy,
+			loopbackCert:      cfg.Certificates[0].Certificate[0],
+			requireClientCert: *clientCAFile != "",
+			trustLoopback:     *requireAPIKey,
+		}
+		unary = append(unary, identityUnaryInterceptor(ids))
+		stream = append(stream, identityStreamInterceptor(ids))
+	}
+	data, err := lockDataDir(*dataDir)
+	if err != nil {
+		log.Fatalf("failed to lock data directory: %v", err)
+	}
+	defer data.Close()
+	keys, err := openAPIKeyStore(filepath.Join(*dataDir, "api_keys.json"), data)
+	if err != nil {
+		log.Fatalf("failed to load API keys: %v", err)
+	}
+	// Without an RBAC policy to say who may administer the server, the
+	// administrative services take API keys even when other calls do not.
+	if (*requireAPIKey || policy == nil) && keys.empty() {
+		key, _, err := keys.create("admin", []string{"*"}, "", 0)
+		if err != nil {
+			log.Fatalf("failed to create admin API key: %v", err)
+		}
+		// Kept out of the log, which may be collected and read more widely
+		// than the data directory.
+		path := filepath.Join(*dataDir, "admin.key")
+		if err := data.write(func() error { return writeFileAtomic(path, []byte(key+"\n")) }); err != nil {
+			log.Fatalf("failed to save admin API key: %v", err)
+		}
+		log.Printf("created admin API key; it is in %s, readable only by this user", path)
+	}
+	if *requireAPIKey {
+		unary = append(unary, keys.unaryInterceptor)
+		stream = append(stream, keys.streamInterceptor)
+	} else if policy == nil {
+		unary = append(unary, keys.adminUnaryInterceptor)
+		stream = append(stream, keys.adminStreamInterceptor)
+	}
+	members, err := openMemberStore(*dataDir, flagKeyring(), data)
+	if err != nil {
+		log.Fatalf("failed to load members: %v", err)
+	}
+	cohorts, err := openCohortStore(filepath.Join(*dataDir, "cohorts.json"), data)
+	if err != nil {
+		log.Fatalf("failed to load cohorts: %v", err)
+	}
+	var quotas *quotaConfig
+	if *quotaFile != "" {
+		if quotas, err = loadQuotaConfig(*quotaFile); err != nil {
+			log.Fatalf("failed to load quotas: %v", err)
+		}
 	}
+	usage, err := newMeter(filepath.Join(*dataDir, "usage.json"), quotas, data)
+	if err != nil {
+		log.Fatalf("failed to load usage: %v", err)
+	}
+	// After an upgrade the usage of the calls drained meanwhile is not
+	// saved, as the data directory belongs to the new process.
+	defer usage.Close()
+	stats, err := openWelcomeStats(filepath.Join(*dataDir, "welcome_stats.json"), data)
+	if err != nil {
+		log.Fatalf("failed to load welcome statistics: %v", err)
+	}
+	defer stats.Close()
+	data.flush = []func() error{usage.flush, stats.flush}
+	data.reload = []func() error{keys.reload, members.reload, cohorts.reload, usage.reload, stats.reload}
+	unary = append(unary, usage.unaryInterceptor)
+	stream = append(stream, usage.streamInterceptor)
+	if *enableFaults {
+		// Before the recorder, so that recordings and shadow comparisons see the
+		// responses as the service produced them.
+		faults = &faultInjector{}
+		unary = append(unary, faults.unaryInterceptor)
+		stream = append(stream, faults.streamInterceptor)
+		opts = append(opts, grpc.ForceServerCodec(newFaultCodec()))
+		log.Printf("fault injection enabled")
+	}
+	if *recordFile != "" {
+		rec, err := newRecorder(*recordFile, *recordSample, *recordMethods)
+		if err != nil {
+			log.Fatalf("failed to open record file: %v", err)
+		}
+		defer rec.Close()
+		unary = append(unary, rec.unaryInterceptor)
+		stream = append(stream, rec.streamInterceptor)
+	}
+	if *shadowAddr != "" {
+		sh, err := newShadower(*shadowAddr, *shadowPercent)
+		if err != nil {
+			log.Fatalf("failed to dial shadow server: %v", err)
+		}
+		defer sh.Close()
+		unary = append(unary, sh.unaryInterceptor)
+	}
+	opts = append(opts,
+		grpc.ChainUnaryInterceptor(unary...),
+		grpc.ChainStreamInterceptor(stream...),
+	)
+	s := grpc.NewServer(opts...)
+	templates, err := loadCardTemplates(*cardTemplates)
+	if err != nil {
+		log.Fatalf("failed to load card templates: %v", err)
+	}
+	hub, err := newEventHub(*replayBuffer)
+	if err != nil {
+		log.Fatalf("failed to create event hub: %v", err)
+	}
+	pb.RegisterWelcomeServiceServer(s, &server{events: hub, stats: stats, cards: newCardRenderer(templates), kitDir: *kitDir})
+	avatars := &avatarStore{dir: filepath.Join(*dataDir, "avatars"), lock: data}
+	pb.RegisterAdminServiceServer(s, &adminServer{
+		faults:  faults,
+		meter:   usage,
+		members: members,
+		backup: &backupSources{
+			keys:    keys,
+			members: members,
+			cohorts: cohorts,
+			usage:   usage,
+			stats:   stats,
+			avatars: avatars,
+			dataDir: *dataDir,
+		},
+	})
+	pb.RegisterApiKeyServiceServer(s, &apiKeyServer{store: keys})
+	lobbies := newLobbyHub()
+	pb.RegisterMemberServiceServer(s, &memberServer{
+		store:          members,
+		avatars:        avatars,
+		cohorts:        cohorts,
+		lobbies:        lobbies,
+		maxAvatarBytes: *avatarLimit,
+	})
+	pb.RegisterPresenceServiceServer(s, &presenceServer{hub: newPresenceHub(*presenceTTL), members: members})
+	pb.RegisterCohortServiceServer(s, &cohortServer{store: cohorts, members: members, lobbies: lobbies})
+	var web *http.Server
+	if *httpPort != 0 {
+		hlis, err := listen("http", *httpPort)
+		if err != nil {
+			log.Fatalf("failed to listen for http: %v", err)
+		}
+		cc, err := grpc.Dial(fmt.Sprintf("localhost:%d", lis.Addr().(*net.TCPAddr).Port), grpc.WithTransportCredentials(loopback))
+		if err != nil {
+			log.Fatalf("failed to dial loopback: %v", err)
+		}
+		if web, err = newHTTPServer(cc, *wsOrigins); err != nil {
+			log.Fatalf("failed to set up http: %v", err)
+		}
+		go func() {
+			log.Printf("http listening at %v", hlis.Addr())
+			if err := web.Serve(hlis); !errors.Is(err, http.ErrServerClosed) {
+				log.Fatalf("failed to serve http: %v", err)
+			}
+		}()
+	}
+	log.Printf("server listening at %v", lis.Addr())
+	// A deadlocked event hub stops the watchdog pings.
+	run(s, lis, web, data, func() { hub.latest() })
 }
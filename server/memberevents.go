@@ -0,0 +1,541 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	pb "example.com/grpc-go"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// memberSnapshotEvery is how many events are appended to the member log
+// between snapshots.
+const memberSnapshotEvery = 1000
+
+// The types of member events.
+const (
+	memberCreated = "created"
+	memberUpdated = "updated"
+	memberDeleted = "deleted"
+)
+
+var memberEventTypes = map[string]pb.MemberEvent_Type{
+	memberCreated: pb.MemberEvent_CREATED,
+	memberUpdated: pb.MemberEvent_UPDATED,
+	memberDeleted: pb.MemberEvent_DELETED,
+}
+
+// memberEvent is a change to a member, stored as one line of JSON in the
+// append-only member log.
+type memberEvent struct {
+	Seq      uint64    `json:"seq"`
+	Type     string    `json:"type"`
+	MemberID string    `json:"member_id"`
+	At       time.Time `json:"at"`
+	// Set by created events, and by updated events when they change.
+	Name  string `json:"name,omitempty"`
+	Email string `json:"email,omitempty"`
+
+	// stale is set on events read from the log whose name or email is
+	// encrypted with an older key, or not encrypted.
+	stale bool
+}
+
+// sealMemberFields encrypts a name and email of a member for storage.
+func sealMemberFields(keys *keyring, memberID, name, email string) (string, string, error) {
+	name, err := keys.seal(name, memberID+"/name")
+	if err != nil {
+		return "", "", err
+	}
+	email, err = keys.seal(email, memberID+"/email")
+	return name, email, err
+}
+
+// openMemberFields decrypts the fields sealed by sealMemberFields, and
+// reports whether either is stale.
+func openMemberFields(keys *keyring, memberID, name, email string) (string, string, bool, error) {
+	name, staleName, err := keys.open(name, memberID+"/name")
+	if err != nil {
+		return "", "", false, fmt.Errorf("name of member %s: %v", memberID, err)
+	}
+	email, staleEmail, err := keys.open(email, memberID+"/email")
+	if err != nil {
+		return "", "", false, fmt.Errorf("email of member %s: %v", memberID, err)
+	}
+	return name, email, staleName || staleEmail, nil
+}
+
+func (e *memberEvent) proto() *pb.MemberEvent {
+	return &pb.MemberEvent{
+		Seq:      e.Seq,
+		Type:     memberEventTypes[e.Type],
+		MemberId: e.MemberID,
+		Time:     timestamppb.New(e.At),
+		Name:     e.Name,
+		Email:    e.Email,
+	}
+}
+
+// memberSnapshot is the projection of the member log up to an event, from
+// which it can be rebuilt without replaying the events before. It leaves out
+// where the events are, which would grow with the whole log.
+type memberSnapshot struct {
+	Seq     uint64          `json:"seq"`
+	Offset  int64           `json:"offset"` // of the next event in the log
+	Members []*memberRecord `json:"members"`
+}
+
+// memberEventRef is the offset and length of an event in the member log.
+type memberEventRef [2]int64
+
+// memberProjection is the current state of the members, built by applying
+// the events of the log in order, with where in the log the events of each
+// member are.
+type memberProjection struct {
+	members map[string]*memberRecord
+	index   *trigramIndex
+	events  map[string][]memberEventRef
+	// eventsFrom is the offset of the first event in events. Those before,
+	// up to the snapshot the members were loaded from, are only located
+	// when first asked for.
+	eventsFrom int64
+}
+
+func newMemberProjection() *memberProjection {
+	return &memberProjection{
+		members: make(map[string]*memberRecord),
+		index:   newTrigramIndex(),
+		events:  make(map[string][]memberEventRef),
+	}
+}
+
+func (p *memberProjection) load(snap *memberSnapshot) {
+	for _, r := range snap.Members {
+		p.members[r.ID] = r
+		p.index.set(r.ID, r.Name)
+	}
+	p.eventsFrom = snap.Offset
+}
+
+// check fails if an event does not fit the current state.
+func (p *memberProjection) check(e *memberEvent) error {
+	_, exists := p.members[e.MemberID]
+	switch e.Type {
+	case memberCreated:
+		if exists {
+			return fmt.Errorf("event %d creates member %s, which exists", e.Seq, e.MemberID)
+		}
+	case memberUpdated:
+		if !exists {
+			return fmt.Errorf("event %d updates member %s, which does not exist", e.Seq, e.MemberID)
+		}
+	case memberDeleted:
+		if !exists {
+			return fmt.Errorf("event %d deletes member %s, which does not exist", e.Seq, e.MemberID)
+		}
+	default:
+		return fmt.Errorf("event %d has unknown type %q", e.Seq, e.Type)
+	}
+	return nil
+}
+
+// apply applies an event found at ref in the log, failing if it does not
+// fit the current state.
+func (p *memberProjection) apply(e *memberEvent, ref memberEventRef) error {
+	if err := p.check(e); err != nil {
+		return err
+	}
+	p.events[e.MemberID] = append(p.events[e.MemberID], ref)
+	r := p.members[e.MemberID]
+	switch e.Type {
+	case memberCreated:
+		p.members[e.MemberID] = &memberRecord{ID: e.MemberID, Name: e.Name, Email: e.Email, CreatedAt: e.At, UpdatedAt: e.At}
+		p.index.set(e.MemberID, e.Name)
+	case memberUpdated:
+		if e.Name != "" && e.Name != r.Name {
+			r.Name = e.Name
+			p.index.set(e.MemberID, e.Name)
+		}
+		if e.Email != "" {
+			r.Email = e.Email
+		}
+		r.UpdatedAt = e.At
+	case memberDeleted:
+		delete(p.members, e.MemberID)
+		p.index.remove(e.MemberID)
+	}
+	return nil
+}
+
+// sorted returns the members in the order they joined.
+func (p *memberProjection) sorted() []*memberRecord {
+	recs := make([]*memberRecord, 0, len(p.members))
+	for _, r := range p.members {
+		recs = append(recs, r)
+	}
+	sort.Slice(recs, func(i, j int) bool {
+		if !recs[i].CreatedAt.Equal(recs[j].CreatedAt) {
+			return recs[i].CreatedAt.Before(recs[j].CreatedAt)
+		}
+		return recs[i].ID < recs[j].ID
+	})
+	return recs
+}
+
+// diff counts the members that differ between p and q.
+func (p *memberProjection) diff(q *memberProjection) int {
+	n := 0
+	for id, r := range p.members {
+		o, ok := q.members[id]
+		if !ok || o.Name != r.Name || o.Email != r.Email || !o.CreatedAt.Equal(r.CreatedAt) || !o.UpdatedAt.Equal(r.UpdatedAt) {
+			n++
+		}
+	}
+	for id := range q.members {
+		if _, ok := p.members[id]; !ok {
+			n++
+		}
+	}
+	return n
+}
+
+// scanMemberEvents calls fn with each event read from r, decrypted with
+// keys, and where in r it was read, and returns the length of the complete
+// lines read. A last line without a newline is left out: it is what remains
+// of an append cut short by a crash.
+func scanMemberEvents(r io.Reader, keys *keyring, fn func(*memberEvent, memberEventRef) error) (int64, error) {
+	br := bufio.NewReader(r)
+	var n int64
+	for {
+		line, err := br.ReadBytes('\n')
+		if err == io.EOF {
+			return n, nil
+		}
+		if err != nil {
+			return n, err
+		}
+		if len(bytes.TrimSpace(line)) > 0 {
+			var e memberEvent
+			if err := json.Unmarshal(line, &e); err != nil {
+				return n, fmt.Errorf("bad event at offset %d: %v", n, err)
+			}
+			if e.Name, e.Email, e.stale, err = openMemberFields(keys, e.MemberID, e.Name, e.Email); err != nil {
+				return n, fmt.Errorf("event %d: %v", e.Seq, err)
+			}
+			if err := fn(&e, memberEventRef{n, int64(len(line))}); err != nil {
+				return n, err
+			}
+		}
+		n += int64(len(line))
+	}
+}
+
+// replayMemberEvents applies the events read from r, which starts at
+// offset base in the log, to p, checking that they follow seq without gaps,
+// and returns the last sequence number, the length read like
+// scanMemberEvents and the number of stale events.
+func replayMemberEvents(r io.Reader, base int64, keys *keyring, p *memberProjection, seq uint64) (uint64, int64, int, error) {
+	stale := 0
+	n, err := scanMemberEvents(r, keys, func(e *memberEvent, ref memberEventRef) error {
+		if e.Seq != seq+1 {
+			return fmt.Errorf("event %d follows event %d", e.Seq, seq)
+		}
+		seq = e.Seq
+		if e.stale {
+			stale++
+		}
+		ref[0] += base
+		return p.apply(e, ref)
+	})
+	return seq, n, stale, err
+}
+
+// openLog opens the member log in dir and rebuilds the members from
+// the latest snapshot and the events after it. A log cut short by a crash is
+// truncated to its last complete event. Members kept in members.json by
+// earlier versions are imported into a new log as created and updated
+// events. If any values read are encrypted under an older key, or not
+// encrypted, a snapshot is written at once, so that the current members are
+// under the primary key; the events are only rewritten by the reencrypt
+// command.
+func (s *memberStore) openLog(dir string) error {
+	s.logPath = filepath.Join(dir, "member_events.jsonl")
+	s.snapshotPath = filepath.Join(dir, "member_snapshot.json")
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(s.logPath, os.O_CREATE|os.O_RDWR|os.O_APPEND, 0600)
+	if err != nil {
+		return err
+	}
+	s.log = f
+	size, err := f.Seek(0, io.SeekEnd)
+	if err != nil {
+		return err
+	}
+
+	var snap memberSnapshot
+	if err := readJSONFile(s.snapshotPath, &snap); err != nil {
+		return fmt.Errorf("failed to read snapshot: %v", err)
+	}
+	if snap.Offset > size {
+		log.Printf("member snapshot at event %d is ahead of the log; replaying the whole log", snap.Seq)
+		snap = memberSnapshot{}
+	}
+	staleSnapshot := false
+	for _, r := range snap.Members {
+		var stale bool
+		if r.Name, r.Email, stale, err = openMemberFields(s.keys, r.ID, r.Name, r.Email); err != nil {
+			return fmt.Errorf("failed to read snapshot: %v", err)
+		}
+		staleSnapshot = staleSnapshot || stale
+	}
+	s.memberProjection = newMemberProjection()
+	s.memberProjection.load(&snap)
+	seq, n, stale, err := replayMemberEvents(io.NewSectionReader(f, snap.Offset, size-snap.Offset), snap.Offset, s.keys, s.memberProjection, snap.Seq)
+	if err != nil {
+		return fmt.Errorf("failed to replay %s: %v", s.logPath, err)
+	}
+	if stale > 0 {
+		log.Printf("%d member events are not encrypted with the primary key; the reencrypt command rewrites them", stale)
+	}
+	s.seq, s.offset = seq, snap.Offset+n
+	s.sinceSnapshot = int(seq - snap.Seq)
+	if s.offset < size {
+		log.Printf("truncating %d bytes of an incomplete event at the end of %s", size-s.offset, s.logPath)
+		if err := f.Truncate(s.offset); err != nil {
+			return err
+		}
+	}
+
+	if s.seq == 0 {
+		if err := s.importLegacy(filepath.Join(dir, "members.json")); err != nil {
+			return fmt.Errorf("failed to import members.json: %v", err)
+		}
+	}
+	if s.sinceSnapshot >= memberSnapshotEvery || staleSnapshot || stale > 0 {
+		s.snapshot()
+	}
+	return nil
+}
+
+// importLegacy appends events recreating the members of a members.json
+// file, which is then renamed so that it is not imported again, or removed
+// if the members are encrypted.
+func (s *memberStore) importLegacy(path string) error {
+	var recs []*memberRecord
+	if err := readJSONFile(path, &recs); err != nil || len(recs) == 0 {
+		return err
+	}
+	for _, r := range recs {
+		if err := s.commit(&memberEvent{Type: memberCreated, MemberID: r.ID, At: r.CreatedAt, Name: r.Name, Email: r.Email}); err != nil {
+			return err
+		}
+		if !r.UpdatedAt.Equal(r.CreatedAt) {
+			if err := s.commit(&memberEvent{Type: memberUpdated, MemberID: r.ID, At: r.UpdatedAt}); err != nil {
+				return err
+			}
+		}
+	}
+	log.Printf("imported %d members from %s", len(recs), path)
+	s.snapshot()
+	if s.keys != nil {
+		// Keeping it would leave the names and emails on disk unencrypted.
+		return os.Remove(path)
+	}
+	return os.Rename(path, path+".imported")
+}
+
+// commit appends an event to the log and applies it. An event that does not
+// fit the current state is refused before it is appended. s.mu must be held.
+func (s *memberStore) commit(e *memberEvent) error {
+	e.Seq = s.seq + 1
+	if err := s.check(e); err != nil {
+		return err
+	}
+	sealed := *e
+	var err error
+	if sealed.Name, sealed.Email, err = sealMemberFields(s.keys, e.MemberID, e.Name, e.Email); err != nil {
+		return err
+	}
+	line, err := json.Marshal(&sealed)
+	if err != nil {
+		return err
+	}
+	line = append(line, '\n')
+	err = s.lock.write(func() error {
+		if _, err := s.log.Write(line); err != nil {
+			return err
+		}
+		return s.log.Sync()
+	})
+	if err == errHandedOff {
+		return err
+	}
+	if err != nil {
+		// Leave no partial event behind for the next append to follow.
+		s.log.Truncate(s.offset)
+		return fmt.Errorf("failed to append member event: %v", err)
+	}
+	ref := memberEventRef{s.offset, int64(len(line))}
+	s.seq, s.offset = e.Seq, s.offset+int64(len(line))
+	s.apply(e, ref) // cannot fail, as it was checked
+	if s.sinceSnapshot++; s.sinceSnapshot >= memberSnapshotEvery {
+		s.snapshot()
+	}
+	return nil
+}
+
+// snapshot writes the members as of the last event. A failure is only
+// logged, as the log still holds every event. s.mu must be held.
+func (s *memberStore) snapshot() {
+	snap := memberSnapshot{Seq: s.seq, Offset: s.offset}
+	for _, r := range s.sorted() {
+		sealed := *r
+		var err error
+		if sealed.Name, sealed.Email, err = sealMemberFields(s.keys, r.ID, r.Name, r.Email); err != nil {
+			log.Printf("failed to write member snapshot: %v", err)
+			return
+		}
+		snap.Members = append(snap.Members, &sealed)
+	}
+	if err := s.lock.write(func() error { return writeJSONFile(s.snapshotPath, snap) }); err != nil {
+		log.Printf("failed to write member snapshot: %v", err)
+		return
+	}
+	s.sinceSnapshot = 0
+}
+
+// events returns the events of a member, oldest first, reading only those
+// from the log. s.mu is held throughout, as reload and reencrypt replace the
+// log and a failed commit truncates it.
+func (s *memberStore) events(memberID string) ([]*memberEvent, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.locateEarlyEvents(); err != nil {
+		return nil, fmt.Errorf("failed to locate member events: %v", err)
+	}
+	refs := s.memberProjection.events[memberID]
+	out := make([]*memberEvent, 0, len(refs))
+	for _, ref := range refs {
+		_, err := scanMemberEvents(io.NewSectionReader(s.log, ref[0], ref[1]), s.keys, func(e *memberEvent, _ memberEventRef) error {
+			if e.MemberID != memberID {
+				return fmt.Errorf("event at offset %d is for member %s, not %s", ref[0], e.MemberID, memberID)
+			}
+			out = append(out, e)
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	return out, nil
+}
+
+// locateEarlyEvents scans the log up to the snapshot the members were
+// loaded from for where the events of each member are. s.mu must be held.
+func (s *memberStore) locateEarlyEvents() error {
+	if s.eventsFrom == 0 {
+		return nil
+	}
+	events := make(map[string][]memberEventRef)
+	_, err := scanMemberEvents(io.NewSectionReader(s.log, 0, s.eventsFrom), s.keys, func(e *memberEvent, ref memberEventRef) error {
+		events[e.MemberID] = append(events[e.MemberID], ref)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	for id, refs := range s.memberProjection.events {
+		events[id] = append(events[id], refs...)
+	}
+	s.memberProjection.events, s.eventsFrom = events, 0
+	return nil
+}
+
+// rebuild replays the whole log into a new projection, which replaces the
+// current one, and writes a snapshot of it. It returns the number of events
+// replayed, of members, and of members that differed.
+func (s *memberStore) rebuild() (events uint64, members, changed int, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	p := newMemberProjection()
+	seq, n, _, err := replayMemberEvents(io.NewSectionReader(s.log, 0, s.offset), 0, s.keys, p, 0)
+	if err != nil {
+		return 0, 0, 0, err
+	}
+	if seq != s.seq || n != s.offset {
+		return 0, 0, 0, fmt.Errorf("replayed %d events of %d bytes, the log has %d of %d bytes", seq, n, s.seq, s.offset)
+	}
+	changed = p.diff(s.memberProjection)
+	s.memberProjection = p
+	s.snapshot()
+	return seq, len(p.members), changed, nil
+}
+
+// reencryptMemberLog rewrites the member log in dir with every name and
+// email encrypted under the primary key of keys, and writes a new snapshot.
+// The server must not be running, which the caller makes sure of by locking
+// dir. It returns the number of events rewritten.
+func reencryptMemberLog(dir string, keys *keyring) (int, error) {
+	logPath := filepath.Join(dir, "member_events.jsonl")
+	in, err := os.Open(logPath)
+	if err != nil {
+		return 0, err
+	}
+	defer in.Close()
+	out, err := os.CreateTemp(dir, ".member_events.jsonl.*")
+	if err != nil {
+		return 0, err
+	}
+	defer os.Remove(out.Name()) // fails harmlessly once renamed
+	defer out.Close()
+	w := bufio.NewWriter(out)
+	events := 0
+	_, err = scanMemberEvents(in, keys, func(e *memberEvent, _ memberEventRef) error {
+		var err error
+		if e.Name, e.Email, err = sealMemberFields(keys, e.MemberID, e.Name, e.Email); err != nil {
+			return err
+		}
+		line, err := json.Marshal(e)
+		if err != nil {
+			return err
+		}
+		events++
+		_, err = w.Write(append(line, '\n'))
+		return err
+	})
+	if err == nil {
+		err = w.Flush()
+	}
+	if err == nil {
+		err = out.Sync()
+	}
+	if err != nil {
+		return 0, err
+	}
+	if err := os.Rename(out.Name(), logPath); err != nil {
+		return 0, err
+	}
+	// The offsets in the old snapshot no longer match the log.
+	if err := os.Remove(filepath.Join(dir, "member_snapshot.json")); err != nil && !os.IsNotExist(err) {
+		return 0, err
+	}
+	s, err := openMemberStore(dir, keys, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer s.log.Close()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.snapshot()
+	return events, nil
+}
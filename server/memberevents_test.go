@@ -0,0 +1,72 @@
+package main
+
+import (
+	"sync"
+	"testing"
+)
+
+func TestMemberEventsBeforeSnapshot(t *testing.T) {
+	dir := t.TempDir()
+	s, err := openMemberStore(dir, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m, err := s.create("Ann", "ann@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.update(m.ID, "Anne", ""); err != nil {
+		t.Fatal(err)
+	}
+	s.mu.Lock()
+	s.snapshot()
+	s.mu.Unlock()
+	if _, err := s.update(m.ID, "", "anne@example.com"); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.reload(); err != nil {
+		t.Fatal(err)
+	}
+	defer s.log.Close()
+
+	events, err := s.events(m.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var types []string
+	for _, e := range events {
+		types = append(types, e.Type)
+	}
+	if len(events) != 3 || types[0] != memberCreated || events[1].Name != "Anne" || events[2].Email != "anne@example.com" {
+		t.Errorf("got events %v, want created, renamed to Anne and email changed", types)
+	}
+}
+
+func TestMemberEventsDuringReload(t *testing.T) {
+	s, err := openMemberStore(t.TempDir(), nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m, err := s.create("Ann", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 50; i++ {
+			if err := s.reload(); err != nil {
+				t.Error(err)
+				return
+			}
+		}
+	}()
+	for i := 0; i < 50; i++ {
+		if events, err := s.events(m.ID); err != nil || len(events) != 1 {
+			t.Fatalf("got %d events, %v; want 1", len(events), err)
+		}
+	}
+	wg.Wait()
+	s.log.Close()
+}
@@ -0,0 +1,345 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"log"
+	"net/mail"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	pb "example.com/grpc-go"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+const (
+	defaultPageSize = 20
+	maxPageSize     = 100
+)
+
+// memberRecord is a stored member.
+type memberRecord struct {
+	ID        string    `json:"id"`
+	Name      string    `json:"name"`
+	Email     string    `json:"email,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+func (r *memberRecord) proto() *pb.Member {
+	return &pb.Member{
+		Id:        r.ID,
+		Name:      r.Name,
+		Email:     r.Email,
+		CreatedAt: timestamppb.New(r.CreatedAt),
+		UpdatedAt: timestamppb.New(r.UpdatedAt),
+	}
+}
+
+// memberStore keeps the members as an append-only log of events in a
+// directory, with the current members projected from it in memory along
+// with a search index over their names. Every change is appended to the log
+// before it is applied; snapshots of the projection spare replaying the
+// whole log on startup. With a keyring, names and emails are encrypted in
+// both.
+type memberStore struct {
+	mu           sync.Mutex
+	logPath      string
+	snapshotPath string
+	log          *os.File
+	lock         *dataDirLock
+	seq          uint64 // of the last event
+	offset       int64  // the length of the log
+	// sinceSnapshot counts the events after the last snapshot.
+	sinceSnapshot int
+	// keys encrypts names and emails on disk; nil stores them in plaintext.
+	keys *keyring
+	*memberProjection
+}
+
+// openMemberStore opens the members in dir. Writes go through lock, which
+// may be nil.
+func openMemberStore(dir string, keys *keyring, lock *dataDirLock) (*memberStore, error) {
+	s := &memberStore{keys: keys, lock: lock}
+	if err := s.openLog(dir); err != nil {
+		if s.log != nil {
+			s.log.Close()
+		}
+		return nil, err
+	}
+	return s, nil
+}
+
+// reload opens the log again and rebuilds the members from it.
+func (s *memberStore) reload() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.log.Close()
+	return s.openLog(filepath.Dir(s.logPath))
+}
+
+// append validates and commits an event. s.mu must be held.
+func (s *memberStore) append(e *memberEvent) error {
+	if err := s.commit(e); err == errHandedOff {
+		return err
+	} else if err != nil {
+		return status.Errorf(codes.Internal, "failed to save member: %v", err)
+	}
+	return nil
+}
+
+func (s *memberStore) create(name, email string) (memberRecord, error) {
+	id := make([]byte, 8)
+	if _, err := rand.Read(id); err != nil {
+		return memberRecord{}, status.Errorf(codes.Internal, "failed to create member ID: %v", err)
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	e := &memberEvent{Type: memberCreated, MemberID: hex.EncodeToString(id), At: time.Now().UTC(), Name: name, Email: email}
+	if err := s.append(e); err != nil {
+		return memberRecord{}, err
+	}
+	return *s.members[e.MemberID], nil
+}
+
+func (s *memberStore) get(id string) (memberRecord, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	r, ok := s.members[id]
+	if !ok {
+		return memberRecord{}, status.Errorf(codes.NotFound, "no member %q", id)
+	}
+	return *r, nil
+}
+
+// update sets the name and email of member id, leaving empty ones unchanged.
+func (s *memberStore) update(id, name, email string) (memberRecord, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	r, ok := s.members[id]
+	if !ok {
+		return memberRecord{}, status.Errorf(codes.NotFound, "no member %q", id)
+	}
+	e := &memberEvent{Type: memberUpdated, MemberID: id, At: time.Now().UTC()}
+	if name != r.Name {
+		e.Name = name
+	}
+	if email != r.Email {
+		e.Email = email
+	}
+	if err := s.append(e); err != nil {
+		return memberRecord{}, err
+	}
+	return *s.members[id], nil
+}
+
+func (s *memberStore) delete(id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.members[id]; !ok {
+		return status.Errorf(codes.NotFound, "no member %q", id)
+	}
+	return s.append(&memberEvent{Type: memberDeleted, MemberID: id, At: time.Now().UTC()})
+}
+
+// list returns the members in the order they joined.
+func (s *memberStore) list() []memberRecord {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	recs := s.sorted()
+	out := make([]memberRecord, len(recs))
+	for i, r := range recs {
+		out[i] = *r
+	}
+	return out
+}
+
+type memberHit struct {
+	member memberRecord
+	score  float64
+}
+
+// search returns the members whose name resembles query, best first.
+func (s *memberStore) search(query string) []memberHit {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	ids := s.index.search(query)
+	out := make([]memberHit, len(ids))
+	for i, h := range ids {
+		out[i] = memberHit{member: *s.members[h.id], score: h.score}
+	}
+	return out
+}
+
+// page resolves a page size and token into the offset and length of the
+// page. Tokens are opaque to clients but simply encode the offset, so pages
+// shift if members are added or removed between calls.
+func page(size int32, token string) (offset, n int, err error) {
+	switch {
+	case size < 0:
+		return 0, 0, status.Error(codes.InvalidArgument, "page_size must not be negative")
+	case size == 0:
+		n = defaultPageSize
+	case size > maxPageSize:
+		n = maxPageSize
+	default:
+		n = int(size)
+	}
+	if token == "" {
+		return 0, n, nil
+	}
+	b, err := base64.RawURLEncoding.DecodeString(token)
+	if err == nil {
+		offset, err = strconv.Atoi(string(b))
+	}
+	if err != nil || offset < 0 {
+		return 0, 0, status.Error(codes.InvalidArgument, "invalid page_token")
+	}
+	return offset, n, nil
+}
+
+// pageBounds clamps a page to total results and returns the token of the
+// next page, empty if it is the last.
+func pageBounds(offset, n, total int) (start, end int, next string) {
+	start, end = offset, offset+n
+	if start > total {
+		start = total
+	}
+	if end >= total {
+		return start, total, ""
+	}
+	return start, end, base64.RawURLEncoding.EncodeToString([]byte(strconv.Itoa(end)))
+}
+
+func validateMember(name, email string) error {
+	if !utf8.ValidString(name) || len(name) > 200 {
+		return status.Error(codes.InvalidArgument, "name must be valid UTF-8 of at most 200 bytes")
+	}
+	if email != "" {
+		if a, err := mail.ParseAddress(email); err != nil || a.Address != email {
+			return status.Errorf(codes.InvalidArgument, "invalid email %q", email)
+		}
+	}
+	return nil
+}
+
+// memberServer implements welcome.MemberService.
+type memberServer struct {
+	pb.UnimplementedMemberServiceServer
+	store          *memberStore
+	avatars        *avatarStore
+	cohorts        *cohortStore
+	lobbies        *lobbyHub
+	maxAvatarBytes int64
+}
+
+func (s *memberServer) CreateMember(ctx context.Context, in *pb.CreateMemberRequest) (*pb.Member, error) {
+	if foldName(in.GetName()) == "" {
+		return nil, status.Error(codes.InvalidArgument, "name is required")
+	}
+	if err := validateMember(in.GetName(), in.GetEmail()); err != nil {
+		return nil, err
+	}
+	r, err := s.store.create(in.GetName(), in.GetEmail())
+	if err != nil {
+		return nil, err
+	}
+	return r.proto(), nil
+}
+
+func (s *memberServer) GetMember(ctx context.Context, in *pb.GetMemberRequest) (*pb.Member, error) {
+	r, err := s.store.get(in.GetId())
+	if err != nil {
+		return nil, err
+	}
+	return r.proto(), nil
+}
+
+func (s *memberServer) UpdateMember(ctx context.Context, in *pb.UpdateMemberRequest) (*pb.Member, error) {
+	if in.GetName() != "" && foldName(in.GetName()) == "" {
+		return nil, status.Error(codes.InvalidArgument, "name must contain a letter or digit")
+	}
+	if err := validateMember(in.GetName(), in.GetEmail()); err != nil {
+		return nil, err
+	}
+	r, err := s.store.update(in.GetId(), in.GetName(), in.GetEmail())
+	if err != nil {
+		return nil, err
+	}
+	return r.proto(), nil
+}
+
+func (s *memberServer) DeleteMember(ctx context.Context, in *pb.DeleteMemberRequest) (*pb.DeleteMemberResponse, error) {
+	if err := s.store.delete(in.GetId()); err != nil {
+		return nil, err
+	}
+	if err := s.avatars.remove(in.GetId()); err != nil {
+		log.Printf("failed to remove avatar of member %s: %v", in.GetId(), err)
+	}
+	for _, id := range s.cohorts.memberOf(in.GetId()) {
+		if _, err := s.cohorts.removeMember(id, in.GetId()); err != nil {
+			log.Printf("failed to remove member %s from cohort %s: %v", in.GetId(), id, err)
+		}
+		s.lobbies.kick(id, in.GetId())
+	}
+	return &pb.DeleteMemberResponse{}, nil
+}
+
+func (s *memberServer) ListMembers(ctx context.Context, in *pb.ListMembersRequest) (*pb.ListMembersResponse, error) {
+	offset, n, err := page(in.GetPageSize(), in.GetPageToken())
+	if err != nil {
+		return nil, err
+	}
+	recs := s.store.list()
+	start, end, next := pageBounds(offset, n, len(recs))
+	out := &pb.ListMembersResponse{NextPageToken: next}
+	for _, r := range recs[start:end] {
+		out.Members = append(out.Members, r.proto())
+	}
+	return out, nil
+}
+
+func (s *memberServer) SearchMembers(ctx context.Context, in *pb.SearchMembersRequest) (*pb.SearchMembersResponse, error) {
+	if foldName(in.GetQuery()) == "" {
+		return nil, status.Error(codes.InvalidArgument, "query must contain a letter or digit")
+	}
+	offset, n, err := page(in.GetPageSize(), in.GetPageToken())
+	if err != nil {
+		return nil, err
+	}
+	hits := s.store.search(in.GetQuery())
+	start, end, next := pageBounds(offset, n, len(hits))
+	out := &pb.SearchMembersResponse{NextPageToken: next}
+	for _, h := range hits[start:end] {
+		out.Matches = append(out.Matches, &pb.MemberMatch{Member: h.member.proto(), Score: h.score})
+	}
+	return out, nil
+}
+
+func (s *memberServer) ListMemberEvents(ctx context.Context, in *pb.ListMemberEventsRequest) (*pb.ListMemberEventsResponse, error) {
+	offset, n, err := page(in.GetPageSize(), in.GetPageToken())
+	if err != nil {
+		return nil, err
+	}
+	events, err := s.store.events(in.GetMemberId())
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "failed to read member events: %v", err)
+	}
+	if len(events) == 0 {
+		return nil, status.Errorf(codes.NotFound, "no member %q", in.GetMemberId())
+	}
+	start, end, next := pageBounds(offset, n, len(events))
+	out := &pb.ListMemberEventsResponse{NextPageToken: next}
+	for _, e := range events[start:end] {
+		out.Events = append(out.Events, e.proto())
+	}
+	return out, nil
+}
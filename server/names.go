@@ -0,0 +1,193 @@
+package main
+
+import (
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"golang.org/x/text/cases"
+	"golang.org/x/text/language"
+	"golang.org/x/text/runes"
+	"golang.org/x/text/transform"
+	"golang.org/x/text/unicode/norm"
+	"golang.org/x/text/unicode/runenames"
+)
+
+// nameParticles are the words that stay in lower case when a name is
+// title-cased, unless they start it.
+var nameParticles = map[string]bool{
+	"al": true, "bin": true, "bint": true, "da": true, "das": true, "de": true,
+	"del": true, "della": true, "der": true, "di": true, "do": true, "dos": true,
+	"du": true, "el": true, "la": true, "le": true, "ten": true, "ter": true,
+	"van": true, "von": true, "y": true, "zu": true,
+}
+
+// normalizeName trims a name, collapses runs of whitespace and control
+// characters to one space and puts it in Unicode NFC.
+func normalizeName(s string) string {
+	s = strings.ToValidUTF8(s, "")
+	words := strings.FieldsFunc(s, func(r rune) bool {
+		return unicode.IsSpace(r) || unicode.IsControl(r)
+	})
+	return norm.NFC.String(strings.Join(words, " "))
+}
+
+// titleCase capitalizes the words of a normalized name. Particles are put in
+// lower case unless they start the name, and words in mixed case, such as
+// "McDonald", are kept. Parts of a word after a hyphen or apostrophe are
+// capitalized too, as in "Jean-Luc" and "O'Brien".
+func titleCase(name string) string {
+	// Unlike strings.ToLower, cases knows the final form of sigma.
+	lc := cases.Lower(language.Und)
+	words := strings.Split(name, " ")
+	for i, w := range words {
+		lower := lc.String(w)
+		switch {
+		case i > 0 && nameParticles[lower]:
+			words[i] = lower
+		case w == lower || w == strings.ToUpper(w):
+			words[i] = capitalizeParts(lower)
+		}
+	}
+	return strings.Join(words, " ")
+}
+
+// capitalizeParts puts the first letter of w, and every letter following a
+// hyphen or apostrophe, in title case.
+func capitalizeParts(w string) string {
+	var b strings.Builder
+	start := true
+	for _, r := range w {
+		if start && unicode.IsLetter(r) {
+			r = unicode.ToTitle(r)
+			start = false
+		}
+		switch r {
+		case '-', '\'', '’':
+			start = true
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+// asciiFolds transliterates the lower case letters that do not decompose
+// into an ASCII letter and combining marks, and punctuation common in names.
+var asciiFolds = map[rune]string{
+	// Latin
+	'ß': "ss", 'æ': "ae", 'œ': "oe", 'ø': "o", 'ł': "l", 'đ': "d", 'ð': "d",
+	'þ': "th", 'ı': "i", 'ŋ': "ng", 'ħ': "h",
+	// Greek
+	'α': "a", 'β': "v", 'γ': "g", 'δ': "d", 'ε': "e", 'ζ': "z", 'η': "i",
+	'θ': "th", 'ι': "i", 'κ': "k", 'λ': "l", 'μ': "m", 'ν': "n", 'ξ': "x",
+	'ο': "o", 'π': "p", 'ρ': "r", 'σ': "s", 'ς': "s", 'τ': "t", 'υ': "y",
+	'φ': "f", 'χ': "ch", 'ψ': "ps", 'ω': "o",
+	// Cyrillic
+	'а': "a", 'б': "b", 'в': "v", 'г': "g", 'д': "d", 'е': "e", 'ж': "zh",
+	'з': "z", 'и': "i", 'к': "k", 'л': "l", 'м': "m", 'н': "n", 'о': "o",
+	'п': "p", 'р': "r", 'с': "s", 'т': "t", 'у': "u", 'ф': "f", 'х': "kh",
+	'ц': "ts", 'ч': "ch", 'ш': "sh", 'щ': "shch", 'ъ': "", 'ы': "y", 'ь': "",
+	'э': "e", 'ю': "yu", 'я': "ya", 'і': "i", 'є': "ye", 'ґ': "g", 'ђ': "dj",
+	'ј': "j", 'љ': "lj", 'њ': "nj", 'ћ': "c", 'џ': "dz", 'ѕ': "dz",
+	// Punctuation
+	'‘': "'", '’': "'", 'ʼ': "'", '‐': "-", '‑': "-",
+	'–': "-", '—': "-",
+}
+
+// Some letters are transliterated before they lose their diacritics, and
+// some digraphs as a whole.
+var (
+	precomposedFolds = strings.NewReplacer("й", "y", "Й", "Y", "ё", "yo", "Ё", "Yo", "ї", "yi", "Ї", "Yi")
+	digraphFolds     = strings.NewReplacer("ου", "ou", "Ου", "Ou", "ΟΥ", "OU")
+)
+
+// asciiName transliterates a normalized name to ASCII. Diacritics are
+// dropped, Latin, Greek and Cyrillic letters are spelled out, keeping
+// upper case on the first letter, and other characters are left out.
+func asciiName(name string) string {
+	t := transform.Chain(norm.NFD, runes.Remove(runes.In(unicode.Mn)))
+	s, _, err := transform.String(t, precomposedFolds.Replace(name))
+	if err != nil {
+		return ""
+	}
+	s = digraphFolds.Replace(s)
+	var b strings.Builder
+	for _, r := range s {
+		if r < utf8.RuneSelf {
+			b.WriteRune(r)
+			continue
+		}
+		f, ok := asciiFolds[unicode.ToLower(r)]
+		if !ok {
+			if unicode.IsSpace(r) {
+				b.WriteByte(' ')
+			}
+			continue
+		}
+		if unicode.IsUpper(r) && f != "" {
+			f = strings.ToUpper(f[:1]) + f[1:]
+		}
+		b.WriteString(f)
+	}
+	return strings.Join(strings.Fields(b.String()), " ")
+}
+
+// Hangul syllables are composed of a leading consonant, a vowel and an
+// optional final consonant; these are the first letters of their Revised
+// Romanization. The silent leading consonant ieung is '-', leaving the
+// initial to the vowel.
+const (
+	hangulLeadInitials  = "GKNDTRMBPSS-JJCKTPH"
+	hangulVowelInitials = "AAYYEEYYOWWOYUWWWYEUI"
+)
+
+// nameInitials returns the initials of a normalized name, for names that
+// asciiName cannot spell out: the first letter of each word as a Latin
+// capital followed by a period. Letters outside Latin, Greek and Cyrillic
+// are spelled by their Unicode name, M for ARABIC LETTER MEEM; words that
+// start with a character without a letter name, such as a Chinese one, are
+// left out.
+func nameInitials(name string) string {
+	var out []string
+	for _, w := range strings.Fields(name) {
+		r, _ := utf8.DecodeRuneInString(w)
+		if c := letterInitial(r); c != 0 {
+			out = append(out, string(c)+".")
+		}
+	}
+	return strings.Join(out, " ")
+}
+
+// letterInitial returns the Latin capital letter r starts with, or 0.
+func letterInitial(r rune) byte {
+	if r >= 0xac00 && r <= 0xd7a3 {
+		s := int(r - 0xac00)
+		if c := hangulLeadInitials[s/588]; c != '-' {
+			return c
+		}
+		return hangulVowelInitials[s%588/28]
+	}
+	if a := asciiName(string(r)); a != "" {
+		if c := a[0] &^ 0x20; c >= 'A' && c <= 'Z' {
+			return c
+		}
+		return 0
+	}
+	words := strings.Fields(runenames.Name(r))
+	for i, w := range words {
+		if w != "LETTER" && w != "SYLLABLE" && w != "CHARACTER" {
+			continue
+		}
+		for _, next := range words[i+1:] {
+			if next == "CAPITAL" || next == "SMALL" {
+				continue
+			}
+			if c := next[0]; c >= 'A' && c <= 'Z' {
+				return c
+			}
+			break
+		}
+		break
+	}
+	return 0
+}
@@ -0,0 +1,213 @@
+package main
+
+import (
+	_ "embed"
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	spb "google.golang.org/genproto/googleapis/rpc/status"
+	"google.golang.org/protobuf/reflect/protoreflect"
+)
+
+// explorerPage is a static page that loads /openapi.json and lets users try
+// out the JSON endpoints from a browser.
+//
+//go:embed explorer.html
+var explorerPage []byte
+
+// openAPIDocument describes the HTTP bindings of fd as an OpenAPI 3 document.
+// It is built from the compiled-in descriptors, so it always matches the
+// generated code, which go generate keeps in step with welcome.proto.
+func openAPIDocument(fd protoreflect.FileDescriptor) map[string]interface{} {
+	sb := &schemaBuilder{schemas: make(map[string]interface{})}
+	errorResponse := map[string]interface{}{
+		"description": "An error, as a google.rpc.Status.",
+		"content":     jsonContent(sb.ref((&spb.Status{}).ProtoReflect().Descriptor())),
+	}
+	paths := make(map[string]interface{})
+	for _, b := range httpBindings(fd) {
+		op := map[string]interface{}{
+			"operationId": string(b.method.Parent().Name()) + "_" + string(b.method.Name()),
+			"tags":        []string{string(b.method.Parent().Name())},
+			"responses": map[string]interface{}{
+				"200": map[string]interface{}{
+					"description": "A successful response.",
+					"content":     jsonContent(sb.ref(b.method.Output())),
+				},
+				"default": errorResponse,
+			},
+		}
+		in := b.method.Input()
+		var params []interface{}
+		inPath := make(map[string]bool)
+		for _, name := range b.pathParams() {
+			inPath[name] = true
+			params = append(params, map[string]interface{}{
+				"name":     name,
+				"in":       "path",
+				"required": true,
+				"schema":   sb.field(in.Fields().ByName(protoreflect.Name(name))),
+			})
+		}
+		switch b.body {
+		case "":
+			for i := 0; i < in.Fields().Len(); i++ {
+				f := in.Fields().Get(i)
+				if inPath[string(f.Name())] || f.Message() != nil {
+					continue
+				}
+				params = append(params, map[string]interface{}{
+					"name":   f.JSONName(),
+					"in":     "query",
+					"schema": sb.field(f),
+				})
+			}
+		case "*":
+			op["requestBody"] = map[string]interface{}{
+				"required": true,
+				"content":  jsonContent(sb.ref(in)),
+			}
+		default:
+			op["requestBody"] = map[string]interface{}{
+				"required": true,
+				"content":  jsonContent(sb.field(in.Fields().ByName(protoreflect.Name(b.body)))),
+			}
+		}
+		if params != nil {
+			op["parameters"] = params
+		}
+		item, _ := paths[b.path].(map[string]interface{})
+		if item == nil {
+			item = make(map[string]interface{})
+			paths[b.path] = item
+		}
+		item[strings.ToLower(b.verb)] = op
+	}
+	return map[string]interface{}{
+		"openapi": "3.0.3",
+		"info": map[string]interface{}{
+			"title":   string(fd.Package()) + " API",
+			"version": "v1",
+		},
+		"paths":      paths,
+		"components": map[string]interface{}{"schemas": sb.schemas},
+	}
+}
+
+func jsonContent(schema interface{}) map[string]interface{} {
+	return map[string]interface{}{
+		"application/json": map[string]interface{}{"schema": schema},
+	}
+}
+
+// schemaBuilder converts message and enum descriptors to JSON schemas that
+// follow the protobuf JSON mapping, collecting named schemas as it goes.
+type schemaBuilder struct {
+	schemas map[string]interface{}
+}
+
+// ref returns a reference to the schema for md, adding it on first use.
+// Well-known types with a special JSON form are inlined.
+func (sb *schemaBuilder) ref(md protoreflect.MessageDescriptor) interface{} {
+	switch md.FullName() {
+	case "google.protobuf.Timestamp":
+		return map[string]interface{}{"type": "string", "format": "date-time"}
+	case "google.protobuf.Duration":
+		return map[string]interface{}{"type": "string", "pattern": `^-?[0-9]+(\.[0-9]+)?s$`}
+	case "google.protobuf.Empty":
+		return map[string]interface{}{"type": "object"}
+	case "google.protobuf.Any":
+		return map[string]interface{}{
+			"type":                 "object",
+			"properties":           map[string]interface{}{"@type": map[string]interface{}{"type": "string"}},
+			"additionalProperties": true,
+		}
+	}
+	name := string(md.FullName())
+	if _, ok := sb.schemas[name]; !ok {
+		sb.schemas[name] = nil // guards against recursive messages
+		props := make(map[string]interface{})
+		for i := 0; i < md.Fields().Len(); i++ {
+			f := md.Fields().Get(i)
+			props[f.JSONName()] = sb.field(f)
+		}
+		sb.schemas[name] = map[string]interface{}{"type": "object", "properties": props}
+	}
+	return map[string]interface{}{"$ref": "#/components/schemas/" + name}
+}
+
+func (sb *schemaBuilder) enumRef(ed protoreflect.EnumDescriptor) interface{} {
+	name := string(ed.FullName())
+	if _, ok := sb.schemas[name]; !ok {
+		var values []string
+		for i := 0; i < ed.Values().Len(); i++ {
+			values = append(values, string(ed.Values().Get(i).Name()))
+		}
+		sb.schemas[name] = map[string]interface{}{"type": "string", "enum": values}
+	}
+	return map[string]interface{}{"$ref": "#/components/schemas/" + name}
+}
+
+func (sb *schemaBuilder) field(f protoreflect.FieldDescriptor) interface{} {
+	if f.IsMap() {
+		return map[string]interface{}{"type": "object", "additionalProperties": sb.singular(f.MapValue())}
+	}
+	if f.IsList() {
+		return map[string]interface{}{"type": "array", "items": sb.singular(f)}
+	}
+	return sb.singular(f)
+}
+
+func (sb *schemaBuilder) singular(f protoreflect.FieldDescriptor) interface{} {
+	typed := func(typ, format string) interface{} {
+		s := map[string]interface{}{"type": typ}
+		if format != "" {
+			s["format"] = format
+		}
+		return s
+	}
+	switch f.Kind() {
+	case protoreflect.MessageKind, protoreflect.GroupKind:
+		return sb.ref(f.Message())
+	case protoreflect.EnumKind:
+		return sb.enumRef(f.Enum())
+	case protoreflect.BoolKind:
+		return typed("boolean", "")
+	case protoreflect.StringKind:
+		return typed("string", "")
+	case protoreflect.BytesKind:
+		return typed("string", "byte")
+	case protoreflect.Int32Kind, protoreflect.Sint32Kind, protoreflect.Sfixed32Kind:
+		return typed("integer", "int32")
+	case protoreflect.Uint32Kind, protoreflect.Fixed32Kind:
+		return typed("integer", "int64")
+	case protoreflect.Int64Kind, protoreflect.Sint64Kind, protoreflect.Sfixed64Kind:
+		// 64-bit integers are strings in the protobuf JSON mapping.
+		return typed("string", "int64")
+	case protoreflect.Uint64Kind, protoreflect.Fixed64Kind:
+		return typed("string", "uint64")
+	case protoreflect.FloatKind:
+		return typed("number", "float")
+	case protoreflect.DoubleKind:
+		return typed("number", "double")
+	}
+	return map[string]interface{}{}
+}
+
+// openAPIHandler serves doc as JSON. The document is encoded once.
+func openAPIHandler(doc map[string]interface{}) (http.Handler, error) {
+	data, err := json.MarshalIndent(doc, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(data)
+	}), nil
+}
+
+func serveExplorer(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Write(explorerPage)
+}
@@ -0,0 +1,348 @@
+package main
+
+import (
+	"context"
+	"io"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	pb "example.com/grpc-go"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+const (
+	// maxPresenceFollows bounds how many members one presence stream
+	// follows.
+	maxPresenceFollows = 1000
+	// minPresenceTTL is the shortest presence TTL, so that the sweeper,
+	// which runs four times per TTL, does not spin.
+	minPresenceTTL = time.Second
+)
+
+// presenceSession is one Presence stream.
+type presenceSession struct {
+	memberID string
+	lastBeat int64 // Unix nanoseconds, accessed atomically
+	expired  int32 // 1 once the heartbeats stopped, accessed atomically
+
+	// Guarded by the hub's mu.
+	follows map[string]bool
+	// pending holds the changes not yet sent, only the latest per member, so
+	// a slow stream never blocks the hub and never falls further behind
+	// than one change per member it follows.
+	pending map[string]*pb.PresenceChange
+	wake    chan struct{} // signaled when pending becomes non-empty
+}
+
+type memberPresence struct {
+	sessions map[*presenceSession]bool
+	online   bool
+	since    time.Time // came online, or was last seen
+}
+
+// presenceHub tracks the presence of members from their heartbeats and
+// passes changes to the streams following them. Heartbeats only touch the
+// session; a single sweeper expires the sessions whose heartbeats stopped.
+type presenceHub struct {
+	ttl time.Duration
+
+	mu        sync.Mutex
+	members   map[string]*memberPresence
+	followers map[string]map[*presenceSession]bool // by followed member
+}
+
+func newPresenceHub(ttl time.Duration) *presenceHub {
+	h := &presenceHub{
+		ttl:       ttl,
+		members:   make(map[string]*memberPresence),
+		followers: make(map[string]map[*presenceSession]bool),
+	}
+	go h.sweep()
+	return h
+}
+
+// sweep expires sessions that have not sent a heartbeat within the TTL.
+func (h *presenceHub) sweep() {
+	t := time.NewTicker(h.ttl / 4)
+	defer t.Stop()
+	for now := range t.C {
+		deadline := now.Add(-h.ttl).UnixNano()
+		h.mu.Lock()
+		for id, m := range h.members {
+			changed := false
+			for s := range m.sessions {
+				if atomic.LoadInt64(&s.lastBeat) >= deadline || !atomic.CompareAndSwapInt32(&s.expired, 0, 1) {
+					continue
+				}
+				// A heartbeat between the two checks saw the session live
+				// and left it alone, so undo the expiry.
+				if atomic.LoadInt64(&s.lastBeat) >= deadline {
+					atomic.StoreInt32(&s.expired, 0)
+					continue
+				}
+				changed = true
+			}
+			if changed {
+				h.update(id, now)
+			}
+		}
+		h.mu.Unlock()
+	}
+}
+
+// update recomputes whether member id is online and tells its followers if
+// that changed. h.mu must be held.
+func (h *presenceHub) update(id string, now time.Time) {
+	m := h.members[id]
+	online := false
+	for s := range m.sessions {
+		if atomic.LoadInt32(&s.expired) == 0 {
+			online = true
+			break
+		}
+	}
+	if online == m.online {
+		return
+	}
+	m.online, m.since = online, now
+	change := m.change(id)
+	for s := range h.followers[id] {
+		s.queue(change)
+	}
+}
+
+func (m *memberPresence) change(id string) *pb.PresenceChange {
+	c := &pb.PresenceChange{MemberId: id}
+	if m != nil {
+		c.Online = m.online
+		c.Since = timestamppb.New(m.since)
+	}
+	return c
+}
+
+// queue adds a change to the pending ones of s. The hub's mu must be held.
+func (s *presenceSession) queue(c *pb.PresenceChange) {
+	s.pending[c.GetMemberId()] = c
+	select {
+	case s.wake <- struct{}{}:
+	default:
+	}
+}
+
+// join starts a session for memberID, which is online from now.
+func (h *presenceHub) join(memberID string) *presenceSession {
+	now := time.Now()
+	s := &presenceSession{
+		memberID: memberID,
+		lastBeat: now.UnixNano(),
+		follows:  make(map[string]bool),
+		pending:  make(map[string]*pb.PresenceChange),
+		wake:     make(chan struct{}, 1),
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	m := h.members[memberID]
+	if m == nil {
+		m = &memberPresence{sessions: make(map[*presenceSession]bool)}
+		h.members[memberID] = m
+	}
+	m.sessions[s] = true
+	h.update(memberID, now)
+	return s
+}
+
+// leave ends a session. Its member goes offline unless it has other live
+// sessions.
+func (h *presenceHub) leave(s *presenceSession) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for id := range s.follows {
+		h.unfollow(s, id)
+	}
+	m := h.members[s.memberID]
+	delete(m.sessions, s)
+	h.update(s.memberID, time.Now())
+}
+
+// beat records a heartbeat of s, reviving it if it had expired.
+func (h *presenceHub) beat(s *presenceSession) {
+	now := time.Now()
+	atomic.StoreInt64(&s.lastBeat, now.UnixNano())
+	if atomic.LoadInt32(&s.expired) == 0 {
+		return
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if atomic.CompareAndSwapInt32(&s.expired, 1, 0) {
+		h.update(s.memberID, now)
+	}
+}
+
+// follow changes what s follows, queuing the state of newly followed
+// members.
+func (h *presenceHub) follow(s *presenceSession, follow, unfollow []string) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for _, id := range unfollow {
+		h.unfollow(s, id)
+	}
+	for _, id := range follow {
+		if s.follows[id] {
+			continue
+		}
+		if len(s.follows) == maxPresenceFollows {
+			return status.Errorf(codes.InvalidArgument, "a stream may follow at most %d members", maxPresenceFollows)
+		}
+		s.follows[id] = true
+		fs := h.followers[id]
+		if fs == nil {
+			fs = make(map[*presenceSession]bool)
+			h.followers[id] = fs
+		}
+		fs[s] = true
+		s.queue(h.members[id].change(id))
+	}
+	return nil
+}
+
+// unfollow stops s from following id. h.mu must be held.
+func (h *presenceHub) unfollow(s *presenceSession, id string) {
+	delete(s.follows, id)
+	delete(s.pending, id)
+	if fs := h.followers[id]; fs != nil {
+		delete(fs, s)
+		if len(fs) == 0 {
+			delete(h.followers, id)
+		}
+	}
+}
+
+// drain takes the pending changes of s, ordered by member.
+func (h *presenceHub) drain(s *presenceSession) []*pb.PresenceChange {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	out := make([]*pb.PresenceChange, 0, len(s.pending))
+	for id, c := range s.pending {
+		out = append(out, c)
+		delete(s.pending, id)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].GetMemberId() < out[j].GetMemberId() })
+	return out
+}
+
+// online returns the members that are online, ordered by ID.
+func (h *presenceHub) online() []*pb.PresenceChange {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	var out []*pb.PresenceChange
+	for id, m := range h.members {
+		if m.online {
+			out = append(out, m.change(id))
+		}
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].GetMemberId() < out[j].GetMemberId() })
+	return out
+}
+
+// checkActsAs returns an error unless the caller may act as memberID. An
+// API key bound to a member acts only as it. Other identified callers, by
+// API key or client certificate, may act as any member only if they are
+// granted the AdminService; anonymous callers, when neither API keys nor
+// client certificates are required, may act as anyone.
+func checkActsAs(ctx context.Context, memberID string) error {
+	k, hasKey := apiKeyFromContext(ctx)
+	if hasKey && k.MemberID != "" {
+		if k.MemberID != memberID {
+			return status.Errorf(codes.PermissionDenied, "API key %s acts as member %q, not %q", k.ID, k.MemberID, memberID)
+		}
+		return nil
+	}
+	if _, hasPrincipal := principalFromContext(ctx); (hasKey || hasPrincipal) && !isAdmin(ctx) {
+		return status.Errorf(codes.PermissionDenied, "caller may not act as member %q; use an API key created with its member_id", memberID)
+	}
+	return nil
+}
+
+// presenceServer implements welcome.PresenceService.
+type presenceServer struct {
+	pb.UnimplementedPresenceServiceServer
+	hub     *presenceHub
+	members *memberStore
+}
+
+func (s *presenceServer) Presence(stream pb.PresenceService_PresenceServer) error {
+	first, err := stream.Recv()
+	if err == io.EOF {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	memberID := first.GetMemberId()
+	if err := checkActsAs(stream.Context(), memberID); err != nil {
+		return err
+	}
+	if _, err := s.members.get(memberID); err != nil {
+		return err
+	}
+	if err := stream.SendHeader(metadata.Pairs("x-presence-ttl", s.hub.ttl.String())); err != nil {
+		return err
+	}
+	sess := s.hub.join(memberID)
+	defer s.hub.leave(sess)
+	if err := s.hub.follow(sess, first.GetFollow(), first.GetUnfollow()); err != nil {
+		return err
+	}
+
+	errc := make(chan error, 1)
+	go func() {
+		for {
+			in, err := stream.Recv()
+			if err != nil {
+				errc <- err
+				return
+			}
+			if id := in.GetMemberId(); id != "" && id != memberID {
+				errc <- status.Errorf(codes.InvalidArgument, "stream is for member %q, not %q", memberID, id)
+				return
+			}
+			s.hub.beat(sess)
+			if err := s.hub.follow(sess, in.GetFollow(), in.GetUnfollow()); err != nil {
+				errc <- err
+				return
+			}
+		}
+	}()
+	for {
+		select {
+		case <-sess.wake:
+			for _, c := range s.hub.drain(sess) {
+				if err := stream.Send(c); err != nil {
+					return err
+				}
+			}
+		case err := <-errc:
+			if err == io.EOF {
+				return nil
+			}
+			return err
+		case <-stream.Context().Done():
+			return status.FromContextError(stream.Context().Err()).Err()
+		}
+	}
+}
+
+func (s *presenceServer) ListOnline(ctx context.Context, in *pb.ListOnlineRequest) (*pb.ListOnlineResponse, error) {
+	offset, n, err := page(in.GetPageSize(), in.GetPageToken())
+	if err != nil {
+		return nil, err
+	}
+	online := s.hub.online()
+	start, end, next := pageBounds(offset, n, len(online))
+	return &pb.ListOnlineResponse{Members: online[start:end], NextPageToken: next}, nil
+}
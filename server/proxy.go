@@ -0,0 +1,271 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"math/rand"
+	"net"
+	"os"
+	"strings"
+
+	pb "example.com/grpc-go"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/resolver"
+	"google.golang.org/grpc/resolver/manual"
+	"google.golang.org/grpc/status"
+)
+
+// proxyConfig is the file read by the proxy command, for example:
+//
+//	{
+//	  "pools": {
+//	    "stable": ["10.0.0.1:50051", "10.0.0.2:50051"],
+//	    "canary": ["10.0.0.3:50051"]
+//	  },
+//	  "routes": [
+//	    {"header": "x-canary", "value": "true", "split": [{"pool": "canary", "weight": 1}]},
+//	    {"method_prefix": "/welcome.WelcomeService/", "split": [
+//	      {"pool": "stable", "weight": 90}, {"pool": "canary", "weight": 10}]},
+//	    {"split": [{"pool": "stable", "weight": 1}]}
+//	  ]
+//	}
+type proxyConfig struct {
+	// Pools maps a pool name to its backend addresses. Calls are balanced
+	// round robin across the backends of a pool.
+	Pools map[string][]string `json:"pools"`
+	// Routes are tried in order and the first match wins.
+	Routes []proxyRoute `json:"routes"`
+}
+
+// proxyRoute matches calls carrying a metadata header, calls to methods
+// under a prefix, or both. A route with neither matches every call.
+type proxyRoute struct {
+	Header string `json:"header"`
+	// Value is the header value to match; empty matches any value.
+	Value        string       `json:"value"`
+	MethodPrefix string       `json:"method_prefix"`
+	Split        []proxySplit `json:"split"`
+	total        int
+}
+
+// proxySplit sends a share of a route's calls, proportional to Weight, to
+// Pool.
+type proxySplit struct {
+	Pool   string `json:"pool"`
+	Weight int    `json:"weight"`
+}
+
+func (r *proxyRoute) match(method string, md metadata.MD) bool {
+	if r.MethodPrefix != "" && !strings.HasPrefix(method, r.MethodPrefix) {
+		return false
+	}
+	if r.Header == "" {
+		return true
+	}
+	for _, v := range md.Get(r.Header) {
+		if r.Value == "" || v == r.Value {
+			return true
+		}
+	}
+	return false
+}
+
+// pick chooses a pool at random according to the split weights.
+func (r *proxyRoute) pick() string {
+	n := rand.Intn(r.total)
+	for _, s := range r.Split {
+		if n < s.Weight {
+			return s.Pool
+		}
+		n -= s.Weight
+	}
+	return r.Split[len(r.Split)-1].Pool
+}
+
+func loadProxyConfig(path string) (*proxyConfig, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	cfg := &proxyConfig{}
+	if err := json.Unmarshal(data, cfg); err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	for name, addrs := range cfg.Pools {
+		if len(addrs) == 0 {
+			return nil, fmt.Errorf("%s: pool %q has no backends", path, name)
+		}
+	}
+	for i := range cfg.Routes {
+		r := &cfg.Routes[i]
+		r.Header = strings.ToLower(r.Header)
+		for _, s := range r.Split {
+			if _, ok := cfg.Pools[s.Pool]; !ok {
+				return nil, fmt.Errorf("%s: route %d: unknown pool %q", path, i, s.Pool)
+			}
+			if s.Weight < 0 {
+				return nil, fmt.Errorf("%s: route %d: negative weight for pool %q", path, i, s.Pool)
+			}
+			r.total += s.Weight
+		}
+		if r.total == 0 {
+			return nil, fmt.Errorf("%s: route %d: no pool with a positive weight", path, i)
+		}
+	}
+	return cfg, nil
+}
+
+// proxyStreamDesc lets the proxy forward calls of any kind: a unary call is
+// just a stream with one message each way.
+var proxyStreamDesc = &grpc.StreamDesc{ServerStreams: true, ClientStreams: true}
+
+// proxy forwards every call it receives to a backend pool chosen by its
+// routes. Messages are passed through as raw frames, so the proxy needs no
+// knowledge of the services behind it.
+type proxy struct {
+	routes []proxyRoute
+	pools  map[string]*grpc.ClientConn
+}
+
+func newProxy(cfg *proxyConfig) (*proxy, error) {
+	p := &proxy{routes: cfg.Routes, pools: make(map[string]*grpc.ClientConn)}
+	for name, addrs := range cfg.Pools {
+		r := manual.NewBuilderWithScheme("pool")
+		var state resolver.State
+		for _, a := range addrs {
+			state.Addresses = append(state.Addresses, resolver.Address{Addr: a})
+		}
+		r.InitialState(state)
+		conn, err := grpc.Dial(r.Scheme()+":///"+name,
+			grpc.WithResolvers(r),
+			grpc.WithTransportCredentials(insecure.NewCredentials()),
+			grpc.WithDefaultServiceConfig(`{"loadBalancingConfig": [{"round_robin": {}}]}`),
+		)
+		if err != nil {
+			p.Close()
+			return nil, fmt.Errorf("pool %q: %v", name, err)
+		}
+		p.pools[name] = conn
+	}
+	return p, nil
+}
+
+func (p *proxy) Close() error {
+	for _, conn := range p.pools {
+		conn.Close()
+	}
+	return nil
+}
+
+func (p *proxy) route(method string, md metadata.MD) *grpc.ClientConn {
+	for i := range p.routes {
+		if r := &p.routes[i]; r.match(method, md) {
+			return p.pools[r.pick()]
+		}
+	}
+	return nil
+}
+
+// handler is installed as the server's unknown service handler, so it sees
+// every call.
+func (p *proxy) handler(srv interface{}, ss grpc.ServerStream) error {
+	method, ok := grpc.MethodFromServerStream(ss)
+	if !ok {
+		return status.Error(codes.Internal, "proxy: no method in stream")
+	}
+	md, _ := metadata.FromIncomingContext(ss.Context())
+	conn := p.route(method, md)
+	if conn == nil {
+		return status.Errorf(codes.Unimplemented, "proxy: no route for %s", method)
+	}
+	ctx, cancel := context.WithCancel(ss.Context())
+	defer cancel()
+	ctx = metadata.NewOutgoingContext(ctx, forwardedMetadata(ss.Context(), md))
+	cs, err := conn.NewStream(ctx, proxyStreamDesc, method, grpc.ForceCodec(pb.RawCodec{}))
+	if err != nil {
+		return err
+	}
+	go func() {
+		for {
+			var frame []byte
+			if err := ss.RecvMsg(&frame); err == io.EOF {
+				cs.CloseSend()
+				return
+			} else if err != nil {
+				cancel()
+				return
+			}
+			if err := cs.SendMsg(&frame); err != nil {
+				return // the backend's status is reported by RecvMsg
+			}
+		}
+	}()
+	for first := true; ; first = false {
+		var frame []byte
+		err := cs.RecvMsg(&frame)
+		if first {
+			if h, herr := cs.Header(); herr == nil && len(h) > 0 {
+				ss.SendHeader(h)
+			}
+		}
+		if err != nil {
+			ss.SetTrailer(cs.Trailer())
+			if err == io.EOF {
+				return nil
+			}
+			return err
+		}
+		if err := ss.SendMsg(&frame); err != nil {
+			return err
+		}
+	}
+}
+
+// forwardedMetadata is the metadata sent to the backend: the caller's, with
+// the caller's address appended to x-forwarded-for. Reserved headers are
+// dropped by the transport.
+func forwardedMetadata(ctx context.Context, md metadata.MD) metadata.MD {
+	out := md.Copy()
+	for k := range out {
+		if strings.HasPrefix(k, ":") {
+			delete(out, k)
+		}
+	}
+	if p, ok := peer.FromContext(ctx); ok {
+		if host, _, err := net.SplitHostPort(p.Addr.String()); err == nil {
+			out.Append("x-forwarded-for", host)
+		}
+	}
+	return out
+}
+
+// runProxy serves the proxy command: no services of its own, every call is
+// forwarded according to *proxyConfigFile.
+func runProxy() {
+	cfg, err := loadProxyConfig(*proxyConfigFile)
+	if err != nil {
+		log.Fatalf("failed to load proxy config: %v", err)
+	}
+	p, err := newProxy(cfg)
+	if err != nil {
+		log.Fatalf("failed to set up proxy: %v", err)
+	}
+	defer p.Close()
+	lis, err := listen("grpc", *port)
+	if err != nil {
+		log.Fatalf("failed to listen: %v", err)
+	}
+	s := grpc.NewServer(
+		grpc.ForceServerCodec(pb.RawCodec{}),
+		grpc.UnknownServiceHandler(p.handler),
+	)
+	log.Printf("proxy listening at %v with %d pools and %d routes", lis.Addr(), len(cfg.Pools), len(cfg.Routes))
+	run(s, lis, nil, nil, nil)
+}
@@ -0,0 +1,344 @@
+package main
+
+import (
+	"context"
+	crand "crypto/rand"
+	"encoding/binary"
+	"io"
+	"math/rand"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"google.golang.org/grpc"
+	binlogpb "google.golang.org/grpc/binarylog/grpc_binarylog_v1"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/durationpb"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// recorder captures RPCs to a file in the gRPC binary log format: each
+// grpc.binarylog.v1.GrpcLogEntry is preceded by its length as a big-endian
+// uint32, the framing used by grpc-go's own binary log sinks. Authorization
+// metadata is never written.
+type recorder struct {
+	mu     sync.Mutex
+	f      *os.File
+	sample float64
+	// methods restricts recording to these full method names. An entry
+	// ending in "/*" matches every method of a service; nil matches all.
+	methods []string
+	// lastID is the ID of the last call. It starts at a random value, so
+	// that the calls of successive processes appending to the same file do
+	// not share IDs.
+	lastID uint64
+}
+
+func newRecorder(path string, sample float64, methods string) (*recorder, error) {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		return nil, err
+	}
+	r := &recorder{f: f, sample: sample}
+	var id [8]byte
+	if _, err := crand.Read(id[:]); err != nil {
+		f.Close()
+		return nil, err
+	}
+	// The top bit is left clear so that the IDs cannot wrap around.
+	r.lastID = binary.BigEndian.Uint64(id[:]) >> 1
+	for _, m := range strings.Split(methods, ",") {
+		if m = strings.TrimSpace(m); m != "" {
+			r.methods = append(r.methods, m)
+		}
+	}
+	return r, nil
+}
+
+func (r *recorder) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.f.Close()
+}
+
+func (r *recorder) shouldRecord(method string) bool {
+	if len(r.methods) > 0 && !matchMethod(r.methods, method) {
+		return false
+	}
+	return r.sample >= 1 || rand.Float64() < r.sample
+}
+
+// matchMethod reports whether method is listed in patterns, either exactly or
+// through a "/package.Service/*" wildcard.
+func matchMethod(patterns []string, method string) bool {
+	for _, p := range patterns {
+		if p == "*" || p == method || strings.HasSuffix(p, "/*") && strings.HasPrefix(method, strings.TrimSuffix(p, "*")) {
+			return true
+		}
+	}
+	return false
+}
+
+func (r *recorder) write(e *binlogpb.GrpcLogEntry) {
+	data, err := proto.Marshal(e)
+	if err != nil {
+		return
+	}
+	buf := make([]byte, 4, 4+len(data))
+	binary.BigEndian.PutUint32(buf, uint32(len(data)))
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.f.Write(append(buf, data...))
+}
+
+// recordedCall numbers the entries of one RPC, and collects the metadata
+// the handler sets for the server header and trailer.
+type recordedCall struct {
+	r   *recorder
+	id  uint64
+	seq uint64
+
+	mu         sync.Mutex
+	header     metadata.MD
+	headerSent bool
+	trailerMD  metadata.MD
+}
+
+func (r *recorder) startCall(ctx context.Context, method string) *recordedCall {
+	c := &recordedCall{r: r, id: atomic.AddUint64(&r.lastID, 1)}
+	md, _ := metadata.FromIncomingContext(ctx)
+	hdr := &binlogpb.ClientHeader{
+		Metadata:   binlogMetadata(md),
+		MethodName: method,
+	}
+	if a := md.Get(":authority"); len(a) > 0 {
+		hdr.Authority = a[0]
+	}
+	if d, ok := ctx.Deadline(); ok {
+		hdr.Timeout = durationpb.New(time.Until(d))
+	}
+	e := &binlogpb.GrpcLogEntry{
+		Type:    binlogpb.GrpcLogEntry_EVENT_TYPE_CLIENT_HEADER,
+		Payload: &binlogpb.GrpcLogEntry_ClientHeader{ClientHeader: hdr},
+	}
+	if p, ok := peer.FromContext(ctx); ok {
+		e.Peer = binlogAddress(p.Addr)
+	}
+	c.log(e)
+	return c
+}
+
+func (c *recordedCall) log(e *binlogpb.GrpcLogEntry) {
+	e.Timestamp = timestamppb.Now()
+	e.CallId = c.id
+	e.SequenceIdWithinCall = atomic.AddUint64(&c.seq, 1)
+	e.Logger = binlogpb.GrpcLogEntry_LOGGER_SERVER
+	c.r.write(e)
+}
+
+func (c *recordedCall) message(typ binlogpb.GrpcLogEntry_EventType, m interface{}) {
+	msg, ok := m.(proto.Message)
+	if !ok {
+		return
+	}
+	data, err := proto.Marshal(msg)
+	if err != nil {
+		return
+	}
+	c.log(&binlogpb.GrpcLogEntry{
+		Type:    typ,
+		Payload: &binlogpb.GrpcLogEntry_Message{Message: &binlogpb.Message{Length: uint32(len(data)), Data: data}},
+	})
+}
+
+// setHeader adds md to the server header, as the handler set it.
+func (c *recordedCall) setHeader(md metadata.MD) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if !c.headerSent {
+		c.header = metadata.Join(c.header, md)
+	}
+}
+
+// sendHeader records the server header with md added, unless it was
+// recorded already. gRPC sends it at the latest with the first response.
+func (c *recordedCall) sendHeader(md metadata.MD) {
+	c.mu.Lock()
+	if c.headerSent {
+		c.mu.Unlock()
+		return
+	}
+	c.headerSent = true
+	hdr := metadata.Join(c.header, md)
+	c.mu.Unlock()
+	c.log(&binlogpb.GrpcLogEntry{
+		Type:    binlogpb.GrpcLogEntry_EVENT_TYPE_SERVER_HEADER,
+		Payload: &binlogpb.GrpcLogEntry_ServerHeader{ServerHeader: &binlogpb.ServerHeader{Metadata: binlogMetadata(hdr)}},
+	})
+}
+
+// setTrailer adds md to the trailer, as the handler set it.
+func (c *recordedCall) setTrailer(md metadata.MD) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.trailerMD = metadata.Join(c.trailerMD, md)
+}
+
+func (c *recordedCall) trailer(err error) {
+	st := status.Convert(err)
+	c.mu.Lock()
+	md := c.trailerMD
+	c.mu.Unlock()
+	t := &binlogpb.Trailer{
+		Metadata:      binlogMetadata(md),
+		StatusCode:    uint32(st.Code()),
+		StatusMessage: st.Message(),
+	}
+	if len(st.Details()) > 0 {
+		t.StatusDetails, _ = proto.Marshal(st.Proto())
+	}
+	c.log(&binlogpb.GrpcLogEntry{
+		Type:    binlogpb.GrpcLogEntry_EVENT_TYPE_SERVER_TRAILER,
+		Payload: &binlogpb.GrpcLogEntry_Trailer{Trailer: t},
+	})
+}
+
+func (r *recorder) unaryInterceptor(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	if !r.shouldRecord(info.FullMethod) {
+		return handler(ctx, req)
+	}
+	c := r.startCall(ctx, info.FullMethod)
+	c.message(binlogpb.GrpcLogEntry_EVENT_TYPE_CLIENT_MESSAGE, req)
+	if ts := grpc.ServerTransportStreamFromContext(ctx); ts != nil {
+		// grpc.SetHeader and the like reach the call through its
+		// transport stream.
+		ctx = grpc.NewContextWithServerTransportStream(ctx, &recordingTransportStream{ServerTransportStream: ts, call: c})
+	}
+	resp, err := handler(ctx, req)
+	if err == nil {
+		c.sendHeader(nil)
+		c.message(binlogpb.GrpcLogEntry_EVENT_TYPE_SERVER_MESSAGE, resp)
+	}
+	c.trailer(err)
+	return resp, err
+}
+
+// recordingTransportStream records the metadata a unary handler sets.
+type recordingTransportStream struct {
+	grpc.ServerTransportStream
+	call *recordedCall
+}
+
+func (s *recordingTransportStream) SetHeader(md metadata.MD) error {
+	err := s.ServerTransportStream.SetHeader(md)
+	if err == nil {
+		s.call.setHeader(md)
+	}
+	return err
+}
+
+func (s *recordingTransportStream) SendHeader(md metadata.MD) error {
+	err := s.ServerTransportStream.SendHeader(md)
+	if err == nil {
+		s.call.sendHeader(md)
+	}
+	return err
+}
+
+func (s *recordingTransportStream) SetTrailer(md metadata.MD) error {
+	err := s.ServerTransportStream.SetTrailer(md)
+	if err == nil {
+		s.call.setTrailer(md)
+	}
+	return err
+}
+
+func (r *recorder) streamInterceptor(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	if !r.shouldRecord(info.FullMethod) {
+		return handler(srv, ss)
+	}
+	c := r.startCall(ss.Context(), info.FullMethod)
+	err := handler(srv, &recordingStream{ServerStream: ss, call: c})
+	c.trailer(err)
+	return err
+}
+
+type recordingStream struct {
+	grpc.ServerStream
+	call *recordedCall
+}
+
+func (s *recordingStream) SetHeader(md metadata.MD) error {
+	err := s.ServerStream.SetHeader(md)
+	if err == nil {
+		s.call.setHeader(md)
+	}
+	return err
+}
+
+func (s *recordingStream) SendHeader(md metadata.MD) error {
+	err := s.ServerStream.SendHeader(md)
+	if err == nil {
+		s.call.sendHeader(md)
+	}
+	return err
+}
+
+func (s *recordingStream) SetTrailer(md metadata.MD) {
+	s.ServerStream.SetTrailer(md)
+	s.call.setTrailer(md)
+}
+
+func (s *recordingStream) SendMsg(m interface{}) error {
+	s.call.sendHeader(nil)
+	err := s.ServerStream.SendMsg(m)
+	if err == nil {
+		s.call.message(binlogpb.GrpcLogEntry_EVENT_TYPE_SERVER_MESSAGE, m)
+	}
+	return err
+}
+
+func (s *recordingStream) RecvMsg(m interface{}) error {
+	err := s.ServerStream.RecvMsg(m)
+	switch {
+	case err == nil:
+		s.call.message(binlogpb.GrpcLogEntry_EVENT_TYPE_CLIENT_MESSAGE, m)
+	case err == io.EOF:
+		s.call.log(&binlogpb.GrpcLogEntry{Type: binlogpb.GrpcLogEntry_EVENT_TYPE_CLIENT_HALF_CLOSE})
+	}
+	return err
+}
+
+// binlogMetadata converts request metadata, leaving out pseudo-headers,
+// reserved grpc- headers and credentials.
+func binlogMetadata(md metadata.MD) *binlogpb.Metadata {
+	out := &binlogpb.Metadata{}
+	for k, vs := range md {
+		if strings.HasPrefix(k, ":") || strings.HasPrefix(k, "grpc-") && k != "grpc-trace-bin" || k == "authorization" {
+			continue
+		}
+		for _, v := range vs {
+			out.Entry = append(out.Entry, &binlogpb.MetadataEntry{Key: k, Value: []byte(v)})
+		}
+	}
+	return out
+}
+
+func binlogAddress(addr net.Addr) *binlogpb.Address {
+	switch a := addr.(type) {
+	case *net.TCPAddr:
+		if a.IP.To4() != nil {
+			return &binlogpb.Address{Type: binlogpb.Address_TYPE_IPV4, Address: a.IP.String(), IpPort: uint32(a.Port)}
+		}
+		return &binlogpb.Address{Type: binlogpb.Address_TYPE_IPV6, Address: a.IP.String(), IpPort: uint32(a.Port)}
+	case *net.UnixAddr:
+		return &binlogpb.Address{Type: binlogpb.Address_TYPE_UNIX, Address: a.String()}
+	}
+	return &binlogpb.Address{Type: binlogpb.Address_TYPE_UNKNOWN, Address: addr.String()}
+}
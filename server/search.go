@@ -0,0 +1,228 @@
+package main
+
+import (
+	"sort"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"golang.org/x/text/runes"
+	"golang.org/x/text/transform"
+	"golang.org/x/text/unicode/norm"
+)
+
+const (
+	// minMatchScore is the score below which search results are dropped.
+	minMatchScore = 0.4
+	// wordMatchWeight discounts a query matching one word of a name, so that
+	// matching the whole name ranks higher.
+	wordMatchWeight = 0.9
+)
+
+// letterFolds spells out letters that do not decompose into a base letter and
+// combining marks.
+var letterFolds = strings.NewReplacer(
+	"ß", "ss", "æ", "ae", "œ", "oe", "ø", "o", "ł", "l",
+	"đ", "d", "ð", "d", "þ", "th", "ı", "i",
+)
+
+// foldName reduces a name to the form it is matched in: lower case, without
+// diacritics, with words separated by single spaces.
+func foldName(s string) string {
+	// A transformer chain keeps state, so it is built for every call.
+	t := transform.Chain(norm.NFD, runes.Remove(runes.In(unicode.Mn)), norm.NFC)
+	s, _, err := transform.String(t, s)
+	if err != nil {
+		return ""
+	}
+	s = letterFolds.Replace(strings.ToLower(s))
+	return strings.Join(strings.FieldsFunc(s, func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	}), " ")
+}
+
+// trigrams returns the distinct trigrams of a folded name. Every word is
+// padded with two spaces in front and one behind, as PostgreSQL's pg_trgm
+// does, so that short words and word starts weigh in.
+func trigrams(folded string) []string {
+	seen := make(map[string]bool)
+	var out []string
+	for _, w := range strings.Fields(folded) {
+		rs := []rune("  " + w + " ")
+		for i := 0; i+3 <= len(rs); i++ {
+			if t := string(rs[i : i+3]); !seen[t] {
+				seen[t] = true
+				out = append(out, t)
+			}
+		}
+	}
+	return out
+}
+
+// editDistance returns the number of single-rune insertions, deletions,
+// substitutions and transpositions of adjacent runes that turn a into b
+// (the optimal string alignment distance).
+func editDistance(a, b []rune) int {
+	prev2 := make([]int, len(b)+1)
+	prev := make([]int, len(b)+1)
+	cur := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		cur[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			cur[j] = min3(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+			if i > 1 && j > 1 && a[i-1] == b[j-2] && a[i-2] == b[j-1] && prev2[j-2]+1 < cur[j] {
+				cur[j] = prev2[j-2] + 1
+			}
+		}
+		prev2, prev, cur = prev, cur, prev2
+	}
+	return prev[len(b)]
+}
+
+func min3(a, b, c int) int {
+	if b < a {
+		a = b
+	}
+	if c < a {
+		a = c
+	}
+	return a
+}
+
+// editSimilarity is 1 for equal strings, falling to 0 as their edit distance
+// approaches the length of the longer one.
+func editSimilarity(a, b string) float64 {
+	ra, rb := []rune(a), []rune(b)
+	n := len(ra)
+	if len(rb) > n {
+		n = len(rb)
+	}
+	if n == 0 {
+		return 0
+	}
+	return 1 - float64(editDistance(ra, rb))/float64(n)
+}
+
+// trigramIndex finds documents whose text resembles a query. Documents are
+// added, replaced and removed one at a time, so the index never has to be
+// rebuilt. It is not safe for concurrent use.
+type trigramIndex struct {
+	postings map[string]map[string]bool // trigram to the IDs of documents with it
+	docs     map[string]indexedDoc
+}
+
+type indexedDoc struct {
+	folded   string
+	trigrams []string
+}
+
+type scoredID struct {
+	id     string
+	folded string
+	score  float64
+}
+
+func newTrigramIndex() *trigramIndex {
+	return &trigramIndex{
+		postings: make(map[string]map[string]bool),
+		docs:     make(map[string]indexedDoc),
+	}
+}
+
+// set indexes text as document id, replacing what id had before.
+func (x *trigramIndex) set(id, text string) {
+	x.remove(id)
+	folded := foldName(text)
+	d := indexedDoc{folded: folded, trigrams: trigrams(folded)}
+	for _, t := range d.trigrams {
+		ids := x.postings[t]
+		if ids == nil {
+			ids = make(map[string]bool)
+			x.postings[t] = ids
+		}
+		ids[id] = true
+	}
+	x.docs[id] = d
+}
+
+func (x *trigramIndex) remove(id string) {
+	d, ok := x.docs[id]
+	if !ok {
+		return
+	}
+	for _, t := range d.trigrams {
+		delete(x.postings[t], id)
+		if len(x.postings[t]) == 0 {
+			delete(x.postings, t)
+		}
+	}
+	delete(x.docs, id)
+}
+
+// similarity scores how closely a folded text resembles a folded query: by
+// their edit similarity or, so that partly typed names find something, by the
+// share of the text, in characters, the query is a prefix of, whichever is
+// higher.
+func similarity(q, text string) float64 {
+	score := editSimilarity(q, text)
+	if strings.HasPrefix(text, q) {
+		if s := 0.5 + 0.5*float64(utf8.RuneCountInString(q))/float64(utf8.RuneCountInString(text)); s > score {
+			score = s
+		}
+	}
+	return score
+}
+
+// search returns the documents resembling query, best first. Candidates are
+// the documents sharing a trigram with the query. Each is scored by the
+// higher of its trigram similarity (shared over distinct trigrams) and its
+// similarity to the query, taken over the whole text or, slightly
+// discounted, its closest word.
+func (x *trigramIndex) search(query string) []scoredID {
+	q := foldName(query)
+	if q == "" {
+		return nil
+	}
+	qt := trigrams(q)
+	shared := make(map[string]int)
+	for _, t := range qt {
+		for id := range x.postings[t] {
+			shared[id]++
+		}
+	}
+	var out []scoredID
+	for id, n := range shared {
+		d := x.docs[id]
+		score := float64(n) / float64(len(qt)+len(d.trigrams)-n)
+		if s := similarity(q, d.folded); s > score {
+			score = s
+		}
+		if strings.Contains(d.folded, " ") {
+			for _, w := range strings.Fields(d.folded) {
+				if s := wordMatchWeight * similarity(q, w); s > score {
+					score = s
+				}
+			}
+		}
+		if score >= minMatchScore {
+			out = append(out, scoredID{id: id, folded: d.folded, score: score})
+		}
+	}
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].score != out[j].score {
+			return out[i].score > out[j].score
+		}
+		if out[i].folded != out[j].folded {
+			return out[i].folded < out[j].folded
+		}
+		return out[i].id < out[j].id
+	})
+	return out
+}
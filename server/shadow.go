@@ -0,0 +1,151 @@
+package main
+
+import (
+	"context"
+	"log"
+	"math/rand"
+	"sync"
+	"time"
+
+	pb "example.com/grpc-go"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+)
+
+const (
+	sendWelcomeMethod = "/welcome.WelcomeService/SendWelcome"
+	shadowTimeout     = 5 * time.Second
+	maxShadowInFlight = 64
+	// shadowReportInterval is how often the latencies of the mirrored calls
+	// are logged, summed up.
+	shadowReportInterval = time.Minute
+)
+
+// shadower mirrors a sample of SendWelcome calls to a shadow backend. Shadow
+// calls run in the background, concurrently with the primary call, and their
+// responses are only compared with the primary ones. Differences are logged
+// as they happen, latencies once every shadowReportInterval. Nothing about
+// the shadow call, including its failure, reaches the caller.
+type shadower struct {
+	conn    *grpc.ClientConn
+	client  pb.WelcomeServiceClient
+	percent float64
+	slots   chan struct{} // bounds the shadow calls in flight
+
+	mu         sync.Mutex
+	reportedAt time.Time
+	calls      int
+	diffs      int
+	primaryLat time.Duration // summed over calls
+	shadowLat  time.Duration
+}
+
+// primaryResult is the outcome of the primary call handed to the shadow
+// goroutine for comparison.
+type primaryResult struct {
+	resp    interface{}
+	err     error
+	latency time.Duration
+}
+
+func newShadower(addr string, percent float64) (*shadower, error) {
+	conn, err := grpc.Dial(addr, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		return nil, err
+	}
+	return &shadower{
+		conn:       conn,
+		client:     pb.NewWelcomeServiceClient(conn),
+		percent:    percent,
+		slots:      make(chan struct{}, maxShadowInFlight),
+		reportedAt: time.Now(),
+	}, nil
+}
+
+func (s *shadower) Close() error {
+	return s.conn.Close()
+}
+
+func (s *shadower) unaryInterceptor(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	in, ok := req.(*pb.WelcomeRequest)
+	if info.FullMethod != sendWelcomeMethod || !ok || rand.Float64()*100 >= s.percent {
+		return handler(ctx, req)
+	}
+	select {
+	case s.slots <- struct{}{}:
+	default:
+		// The shadow backend is falling behind; skip rather than queue.
+		return handler(ctx, req)
+	}
+	primary := make(chan primaryResult, 1)
+	go s.mirror(proto.Clone(in).(*pb.WelcomeRequest), primary)
+	start := time.Now()
+	resp, err := handler(ctx, req)
+	primary <- primaryResult{resp: resp, err: err, latency: time.Since(start)}
+	return resp, err
+}
+
+// mirror sends in to the shadow backend and logs how its answer compares
+// with the primary one.
+func (s *shadower) mirror(in *pb.WelcomeRequest, primary <-chan primaryResult) {
+	defer func() { <-s.slots }()
+	defer func() {
+		if r := recover(); r != nil {
+			log.Printf("shadow: panic: %v", r)
+		}
+	}()
+	ctx, cancel := context.WithTimeout(context.Background(), shadowTimeout)
+	defer cancel()
+	ctx = metadata.AppendToOutgoingContext(ctx, "x-shadow", "true")
+	start := time.Now()
+	resp, err := s.client.SendWelcome(ctx, in)
+	latency := time.Since(start)
+	// The primary call is given as long as the shadow one, so that a
+	// handler that never returns cannot hold on to the slot.
+	var p primaryResult
+	select {
+	case p = <-primary:
+	case <-ctx.Done():
+		select {
+		case p = <-primary:
+		default:
+			log.Printf("shadow: the primary SendWelcome did not finish within %v", shadowTimeout)
+			return
+		}
+	}
+
+	pst, sst := status.Convert(p.err), status.Convert(err)
+	differs := true
+	switch {
+	case pst.Code() != sst.Code():
+		log.Printf("shadow: SendWelcome status differs: primary %v, shadow %v (%s)", pst.Code(), sst.Code(), sst.Message())
+	case err == nil && !proto.Equal(p.resp.(proto.Message), resp):
+		log.Printf("shadow: SendWelcome response differs: primary %v, shadow %v", p.resp, resp)
+	default:
+		differs = false
+	}
+	s.report(p.latency, latency, differs)
+}
+
+// report adds up a mirrored call and logs the sums once every
+// shadowReportInterval.
+func (s *shadower) report(primaryLat, shadowLat time.Duration, differs bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.calls++
+	s.primaryLat += primaryLat
+	s.shadowLat += shadowLat
+	if differs {
+		s.diffs++
+	}
+	if since := time.Since(s.reportedAt); since >= shadowReportInterval {
+		n := time.Duration(s.calls)
+		log.Printf("shadow: %d SendWelcome calls mirrored in %v, %d differed; mean latency primary %v, shadow %v",
+			s.calls, since.Round(time.Second), s.diffs, s.primaryLat/n, s.shadowLat/n)
+		s.reportedAt = time.Now()
+		s.calls, s.diffs, s.primaryLat, s.shadowLat = 0, 0, 0, 0
+	}
+}
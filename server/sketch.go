@@ -0,0 +1,132 @@
+package main
+
+import (
+	"hash/fnv"
+	"math"
+	"math/bits"
+	"sort"
+)
+
+// hllPrecision is the number of hash bits that pick a HyperLogLog register.
+// 2^12 registers of one byte give a standard error of 1.04/sqrt(4096), about
+// 1.6%.
+const hllPrecision = 12
+
+// hyperLogLog estimates the number of distinct strings added to it
+// (Flajolet et al., 2007), in constant space. Sketches merge by taking the
+// maximum of each register.
+type hyperLogLog struct {
+	registers [1 << hllPrecision]uint8
+}
+
+func hash64(s string) uint64 {
+	h := fnv.New64a()
+	h.Write([]byte(s))
+	// FNV mixes the last bytes poorly into the high bits, which pick the
+	// register, so finish with the MurmurHash3 finalizer.
+	x := h.Sum64()
+	x ^= x >> 33
+	x *= 0xff51afd7ed558ccd
+	x ^= x >> 33
+	x *= 0xc4ceb9fe1a85ec53
+	x ^= x >> 33
+	return x
+}
+
+func (h *hyperLogLog) add(s string) {
+	x := hash64(s)
+	i := x >> (64 - hllPrecision)
+	// The rank is the position of the first one bit in the remaining bits;
+	// the sentinel bit caps it when they are all zero.
+	rank := uint8(bits.LeadingZeros64(x<<hllPrecision|1<<(hllPrecision-1)) + 1)
+	if rank > h.registers[i] {
+		h.registers[i] = rank
+	}
+}
+
+func (h *hyperLogLog) merge(o *hyperLogLog) {
+	for i, r := range o.registers {
+		if r > h.registers[i] {
+			h.registers[i] = r
+		}
+	}
+}
+
+func (h *hyperLogLog) estimate() uint64 {
+	const m = float64(len(h.registers))
+	sum, zeros := 0.0, 0
+	for _, r := range h.registers {
+		sum += math.Ldexp(1, -int(r))
+		if r == 0 {
+			zeros++
+		}
+	}
+	e := 0.7213 / (1 + 1.079/m) * m * m / sum
+	// Small cardinalities leave registers empty; linear counting is more
+	// accurate for them.
+	if e <= 2.5*m && zeros > 0 {
+		e = m * math.Log(m/float64(zeros))
+	}
+	return uint64(e + 0.5)
+}
+
+// topKCapacity is the number of names a spaceSaving summary tracks.
+const topKCapacity = 100
+
+// spaceSaving tracks the most frequent strings in a stream with a fixed
+// number of counters (Metwally et al., 2005). A string that is not tracked
+// replaces the one with the lowest count and inherits that count, so counts
+// can be overestimated by at most the count they inherited, but every string
+// seen more often than total/topKCapacity times is tracked.
+type spaceSaving struct {
+	counts map[string]uint64
+}
+
+func newSpaceSaving() *spaceSaving {
+	return &spaceSaving{counts: make(map[string]uint64)}
+}
+
+func (s *spaceSaving) add(key string, n uint64) {
+	if _, ok := s.counts[key]; ok || len(s.counts) < topKCapacity {
+		s.counts[key] += n
+		return
+	}
+	minKey, minCount := "", uint64(math.MaxUint64)
+	for k, c := range s.counts {
+		if c < minCount || c == minCount && k < minKey {
+			minKey, minCount = k, c
+		}
+	}
+	delete(s.counts, minKey)
+	s.counts[key] = minCount + n
+}
+
+// merge adds the counts of o to s.
+func (s *spaceSaving) merge(o *spaceSaving) {
+	for k, c := range o.counts {
+		s.add(k, c)
+	}
+}
+
+type keyCount struct {
+	key   string
+	count uint64
+}
+
+// top returns the n strings with the highest counts, highest first.
+func (s *spaceSaving) top(n int) []keyCount {
+	out := make([]keyCount, 0, len(s.counts))
+	for k, c := range s.counts {
+		out = append(out, keyCount{k, c})
+	}
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].count != out[j].count {
+			return out[i].count > out[j].count
+		}
+		return out[i].key < out[j].key
+	})
+	if len(out) > n {
+		out = out[:n]
+	}
+	return out
+}
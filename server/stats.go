@@ -0,0 +1,271 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	pb "example.com/grpc-go"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+const (
+	// statsRetention is how long hourly welcome statistics are kept.
+	statsRetention = 92 * 24 * time.Hour
+	// defaultStatsRange is the range GetWelcomeStats covers without a start.
+	defaultStatsRange = 7 * 24 * time.Hour
+	defaultTopNames   = 10
+	// statsFlushInterval is how often changed statistics are written to
+	// disk. It is longer than that of usage, as every hour kept takes a few
+	// kilobytes to write.
+	statsFlushInterval = time.Minute
+)
+
+// hourStats summarizes the welcomes of one hour.
+type hourStats struct {
+	welcomes uint64
+	names    hyperLogLog
+	top      *spaceSaving
+}
+
+// savedHourStats is hourStats as stored on disk.
+type savedHourStats struct {
+	Welcomes uint64            `json:"welcomes"`
+	Names    []byte            `json:"names"` // the HyperLogLog registers
+	Top      map[string]uint64 `json:"top"`
+}
+
+// welcomeStats summarizes welcome events by hour as they are published, so
+// that a range is answered by merging its hours instead of scanning every
+// welcome in it. The hours are kept in memory and flushed to a JSON file in
+// the background, like usage.
+type welcomeStats struct {
+	path string
+	lock *dataDirLock
+
+	mu      sync.Mutex
+	hours   map[int64]*hourStats // by the Unix time the hour starts
+	dirty   bool
+	done    chan struct{}
+	stopped chan struct{}
+}
+
+// openWelcomeStats reads the statistics at path and starts flushing them.
+// Writes go through lock, which may be nil.
+func openWelcomeStats(path string, lock *dataDirLock) (*welcomeStats, error) {
+	w := &welcomeStats{
+		path:    path,
+		lock:    lock,
+		done:    make(chan struct{}),
+		stopped: make(chan struct{}),
+	}
+	if err := w.reload(); err != nil {
+		return nil, err
+	}
+	go w.flushLoop()
+	return w, nil
+}
+
+// readWelcomeStats reads the hours saved at path.
+func readWelcomeStats(path string) (map[int64]*hourStats, error) {
+	saved := make(map[string]*savedHourStats)
+	if err := readJSONFile(path, &saved); err != nil {
+		return nil, err
+	}
+	hours := make(map[int64]*hourStats, len(saved))
+	for k, sh := range saved {
+		t, err := time.Parse(hourLayout, k)
+		if err != nil {
+			return nil, fmt.Errorf("bad hour %q", k)
+		}
+		if len(sh.Names) != 1<<hllPrecision {
+			return nil, fmt.Errorf("hour %s: %d HyperLogLog registers, expected %d", k, len(sh.Names), 1<<hllPrecision)
+		}
+		h := &hourStats{welcomes: sh.Welcomes, top: newSpaceSaving()}
+		copy(h.names.registers[:], sh.Names)
+		for name, c := range sh.Top {
+			h.top.counts[name] = c
+		}
+		hours[t.Unix()] = h
+	}
+	return hours, nil
+}
+
+// reload reads the statistics from disk again, dropping any not yet
+// flushed.
+func (w *welcomeStats) reload() error {
+	hours, err := readWelcomeStats(w.path)
+	if err != nil {
+		return err
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.hours = hours
+	w.dirty = false
+	return nil
+}
+
+// saved returns the hours as stored on disk. w.mu must be held.
+func (w *welcomeStats) saved() map[string]*savedHourStats {
+	saved := make(map[string]*savedHourStats, len(w.hours))
+	for hour, h := range w.hours {
+		sh := &savedHourStats{Welcomes: h.welcomes, Names: append([]byte(nil), h.names.registers[:]...), Top: make(map[string]uint64, len(h.top.counts))}
+		for name, c := range h.top.counts {
+			sh.Top[name] = c
+		}
+		saved[time.Unix(hour, 0).UTC().Format(hourLayout)] = sh
+	}
+	return saved
+}
+
+func (w *welcomeStats) flushLoop() {
+	defer close(w.stopped)
+	t := time.NewTicker(statsFlushInterval)
+	defer t.Stop()
+	for {
+		select {
+		case <-t.C:
+			if err := w.flush(); err != nil && err != errHandedOff {
+				log.Printf("failed to save welcome statistics: %v", err)
+			}
+		case <-w.done:
+			return
+		}
+	}
+}
+
+// flush writes the statistics to disk if they changed, dropping expired
+// hours.
+func (w *welcomeStats) flush() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if !w.dirty {
+		return nil
+	}
+	w.expire(time.Now())
+	if err := w.lock.write(func() error { return writeJSONFile(w.path, w.saved()) }); err != nil {
+		return err
+	}
+	w.dirty = false
+	return nil
+}
+
+// Close stops the background flushing and saves the statistics one last
+// time.
+func (w *welcomeStats) Close() error {
+	close(w.done)
+	<-w.stopped
+	return w.flush()
+}
+
+// record adds a welcome event to the statistics of its hour.
+func (w *welcomeStats) record(ev *pb.WelcomeEvent) {
+	hour := ev.GetSentAt().AsTime().Truncate(time.Hour).Unix()
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	h := w.hours[hour]
+	if h == nil {
+		h = &hourStats{top: newSpaceSaving()}
+		w.hours[hour] = h
+		w.expire(time.Now())
+	}
+	h.welcomes++
+	h.names.add(ev.GetName())
+	h.top.add(ev.GetName(), 1)
+	w.dirty = true
+}
+
+// expire drops the hours that ended more than statsRetention before now.
+// w.mu must be held.
+func (w *welcomeStats) expire(now time.Time) {
+	oldest := now.Add(-statsRetention).Truncate(time.Hour).Unix()
+	for hour := range w.hours {
+		if hour < oldest {
+			delete(w.hours, hour)
+		}
+	}
+}
+
+// query summarizes the hours from start up to end. The range is widened to
+// whole hours and narrowed to the hours that are kept.
+func (w *welcomeStats) query(start, end time.Time, topN int) *pb.WelcomeStats {
+	now := time.Now()
+	if oldest := now.Add(-statsRetention); start.Before(oldest) {
+		start = oldest
+	}
+	if end.After(now) {
+		end = now
+	}
+	first := start.Truncate(time.Hour)
+	last := end.Truncate(time.Hour)
+	if last.Before(end) {
+		last = last.Add(time.Hour)
+	}
+
+	// Copy the hours in range so that they are merged without holding up
+	// record.
+	var hours []*hourStats
+	w.mu.Lock()
+	for t := first; t.Before(last); t = t.Add(time.Hour) {
+		var c *hourStats
+		if h := w.hours[t.Unix()]; h != nil {
+			c = &hourStats{welcomes: h.welcomes, names: h.names, top: newSpaceSaving()}
+			c.top.merge(h.top)
+		}
+		hours = append(hours, c)
+	}
+	w.mu.Unlock()
+
+	out := &pb.WelcomeStats{}
+	var names hyperLogLog
+	top := newSpaceSaving()
+	for i, h := range hours {
+		hw := &pb.HourlyWelcomes{Hour: timestamppb.New(first.Add(time.Duration(i) * time.Hour))}
+		if h != nil {
+			hw.Welcomes = h.welcomes
+			hw.UniqueNames = h.names.estimate()
+			out.Welcomes += h.welcomes
+			names.merge(&h.names)
+			top.merge(h.top)
+		}
+		out.Hourly = append(out.Hourly, hw)
+	}
+	out.UniqueNames = names.estimate()
+	for _, kc := range top.top(topN) {
+		out.TopNames = append(out.TopNames, &pb.NameCount{Name: kc.key, Count: kc.count})
+	}
+	return out
+}
+
+// GetWelcomeStats summarizes the welcomes sent in the requested range.
+func (s *server) GetWelcomeStats(ctx context.Context, in *pb.GetWelcomeStatsRequest) (*pb.WelcomeStats, error) {
+	end := time.Now()
+	if in.GetEndTime() != nil {
+		if err := in.GetEndTime().CheckValid(); err != nil {
+			return nil, status.Errorf(codes.InvalidArgument, "end_time: %v", err)
+		}
+		end = in.GetEndTime().AsTime()
+	}
+	start := end.Add(-defaultStatsRange)
+	if in.GetStartTime() != nil {
+		if err := in.GetStartTime().CheckValid(); err != nil {
+			return nil, status.Errorf(codes.InvalidArgument, "start_time: %v", err)
+		}
+		start = in.GetStartTime().AsTime()
+	}
+	if !start.Before(end) {
+		return nil, status.Error(codes.InvalidArgument, "start_time must be before end_time")
+	}
+	topN := int(in.GetTopN())
+	switch {
+	case topN < 0 || topN > topKCapacity:
+		return nil, status.Errorf(codes.InvalidArgument, "top_n must be between 0 and %d", topKCapacity)
+	case topN == 0:
+		topN = defaultTopNames
+	}
+	return s.stats.query(start, end, topN), nil
+}
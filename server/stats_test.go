@@ -0,0 +1,48 @@
+package main
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	pb "example.com/grpc-go"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+func TestWelcomeStatsSurviveRestart(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "welcome_stats.json")
+	w, err := openWelcomeStats(path, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+	for _, name := range []string{"Ann", "Bo", "Ann", "Cy", "Ann"} {
+		w.record(&pb.WelcomeEvent{Name: name, SentAt: timestamppb.New(now)})
+	}
+	w.record(&pb.WelcomeEvent{Name: "Bo", SentAt: timestamppb.New(now.Add(-2 * time.Hour))})
+	want := w.query(now.Add(-3*time.Hour), now, 2)
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	w, err = openWelcomeStats(path, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+	got := w.query(now.Add(-3*time.Hour), now, 2)
+	if got.Welcomes != 6 || got.UniqueNames != 3 || got.Welcomes != want.Welcomes || got.UniqueNames != want.UniqueNames {
+		t.Errorf("got %d welcomes of %d names, want 6 of 3", got.Welcomes, got.UniqueNames)
+	}
+	if len(got.TopNames) != 2 || got.TopNames[0].Name != "Ann" || got.TopNames[0].Count != 3 || got.TopNames[1].Name != "Bo" || got.TopNames[1].Count != 2 {
+		t.Errorf("got top names %v, want Ann 3 and Bo 2", got.TopNames)
+	}
+	if len(got.Hourly) != len(want.Hourly) {
+		t.Fatalf("got %d hours, want %d", len(got.Hourly), len(want.Hourly))
+	}
+	for i := range got.Hourly {
+		if got.Hourly[i].Welcomes != want.Hourly[i].Welcomes || !got.Hourly[i].Hour.AsTime().Equal(want.Hourly[i].Hour.AsTime()) {
+			t.Errorf("hour %d: got %v, want %v", i, got.Hourly[i], want.Hourly[i])
+		}
+	}
+}
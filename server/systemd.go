@@ -0,0 +1,130 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// listenFDsStart is the first file descriptor passed by systemd socket
+// activation; see sd_listen_fds(3).
+const listenFDsStart = 3
+
+// inherited holds the listeners passed by systemd, keyed by the names given
+// with FileDescriptorName= in the socket unit.
+var inherited = make(map[string]net.Listener)
+
+// unnamed holds inherited listeners without a name, in the order passed.
+var unnamed []net.Listener
+
+// namedListener is a listener in use, as handed over on upgrade.
+type namedListener struct {
+	name string
+	lis  net.Listener
+}
+
+// listening holds the listeners returned by listen.
+var listening []namedListener
+
+// inheritListeners takes over the sockets passed by systemd socket
+// activation or by the process that started this one for an upgrade, if
+// any. The environment variables are cleared so they do not leak into child
+// processes.
+func inheritListeners() error {
+	defer os.Unsetenv("LISTEN_PID")
+	defer os.Unsetenv("LISTEN_FDS")
+	defer os.Unsetenv("LISTEN_FDNAMES")
+	defer os.Unsetenv(upgradeFDNamesEnv)
+	var names []string
+	n := 0
+	if v := os.Getenv(upgradeFDNamesEnv); v != "" {
+		names = strings.Split(v, ":")
+		n = len(names)
+	} else if pid, err := strconv.Atoi(os.Getenv("LISTEN_PID")); err == nil && pid == os.Getpid() {
+		names = strings.Split(os.Getenv("LISTEN_FDNAMES"), ":")
+		n, _ = strconv.Atoi(os.Getenv("LISTEN_FDS"))
+	}
+	for i := 0; i < n; i++ {
+		fd := listenFDsStart + i
+		syscall.CloseOnExec(fd)
+		name := "unknown" // systemd's name for sockets without one
+		if i < len(names) && names[i] != "" {
+			name = names[i]
+		}
+		f := os.NewFile(uintptr(fd), name)
+		lis, err := net.FileListener(f)
+		f.Close()
+		if err != nil {
+			return fmt.Errorf("inherited fd %d: %v", fd, err)
+		}
+		if name == "unknown" {
+			unnamed = append(unnamed, lis)
+		} else {
+			inherited[name] = lis
+		}
+	}
+	return nil
+}
+
+// listen returns the inherited listener called name, or else the next
+// unnamed one, and only listens on port itself when systemd passed neither.
+// Unnamed sockets are used for gRPC first and HTTP second.
+func listen(name string, port int) (net.Listener, error) {
+	lis, ok := inherited[name]
+	switch {
+	case ok:
+		delete(inherited, name)
+	case len(unnamed) > 0:
+		lis = unnamed[0]
+		unnamed = unnamed[1:]
+	default:
+		var err error
+		if lis, err = net.Listen("tcp", fmt.Sprintf(":%d", port)); err != nil {
+			return nil, err
+		}
+	}
+	listening = append(listening, namedListener{name: name, lis: lis})
+	return lis, nil
+}
+
+// sdNotify sends state to the service manager as described in sd_notify(3).
+// It does nothing when NOTIFY_SOCKET is unset.
+func sdNotify(state string) error {
+	if path := os.Getenv("NOTIFY_SOCKET"); path != "" {
+		return notify(path, state)
+	}
+	return nil
+}
+
+// notify sends state to the notification socket at path. A leading @ stands
+// for the abstract namespace.
+func notify(path, state string) error {
+	if path[0] == '@' {
+		path = "\x00" + path[1:] // abstract socket
+	}
+	conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: path, Net: "unixgram"})
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	_, err = conn.Write([]byte(state))
+	return err
+}
+
+// watchdogInterval returns how often to send WATCHDOG=1: half the timeout
+// configured with WatchdogSec=, or zero if the watchdog is not enabled for
+// this process.
+func watchdogInterval() time.Duration {
+	if pid, err := strconv.Atoi(os.Getenv("WATCHDOG_PID")); err == nil && pid != os.Getpid() {
+		return 0
+	}
+	usec, err := strconv.ParseInt(os.Getenv("WATCHDOG_USEC"), 10, 64)
+	if err != nil || usec <= 0 {
+		return 0
+	}
+	return time.Duration(usec) * time.Microsecond / 2
+}
@@ -0,0 +1,281 @@
+package main
+
+import (
+	"bufio"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc"
+)
+
+// inheritChildEnv makes the test binary act as a server that inherits its
+// listener, for TestInheritListeners.
+const inheritChildEnv = "SYSTEMD_TEST_INHERIT_CHILD"
+
+func TestMain(m *testing.M) {
+	if os.Getenv(inheritChildEnv) != "" {
+		inheritChild()
+		return
+	}
+	os.Exit(m.Run())
+}
+
+// inheritChild takes over the listeners passed to it, accepts one
+// connection on the gRPC one and writes the name it was found under.
+func inheritChild() {
+	if err := inheritListeners(); err != nil {
+		os.Stderr.WriteString(err.Error() + "\n")
+		os.Exit(1)
+	}
+	lis, err := listen("grpc", 0)
+	if err != nil {
+		os.Stderr.WriteString(err.Error() + "\n")
+		os.Exit(1)
+	}
+	conn, err := lis.Accept()
+	if err != nil {
+		os.Stderr.WriteString(err.Error() + "\n")
+		os.Exit(1)
+	}
+	conn.Write([]byte(listening[0].name + " " + os.Getenv("LISTEN_FDS") + os.Getenv(upgradeFDNamesEnv) + "\n"))
+	conn.Close()
+	os.Exit(0)
+}
+
+// setenv sets an environment variable for the rest of the test.
+func setenv(t *testing.T, key, value string) {
+	old, ok := os.LookupEnv(key)
+	os.Setenv(key, value)
+	t.Cleanup(func() {
+		if ok {
+			os.Setenv(key, old)
+		} else {
+			os.Unsetenv(key)
+		}
+	})
+}
+
+// unsetenv clears an environment variable for the rest of the test.
+func unsetenv(t *testing.T, key string) {
+	setenv(t, key, "")
+	os.Unsetenv(key)
+}
+
+// listenNotify binds a notification socket and points NOTIFY_SOCKET at it.
+func listenNotify(t *testing.T) *net.UnixConn {
+	path := filepath.Join(t.TempDir(), "notify")
+	conn, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: path, Net: "unixgram"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	setenv(t, "NOTIFY_SOCKET", path)
+	return conn
+}
+
+// expectNotify reads notifications until one is want, skipping those in
+// skip.
+func expectNotify(t *testing.T, conn *net.UnixConn, want string, skip ...string) {
+	t.Helper()
+	buf := make([]byte, 4096)
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	for {
+		n, err := conn.Read(buf)
+		if err != nil {
+			t.Fatalf("waiting for %s: %v", want, err)
+		}
+		got := string(buf[:n])
+		if got == want {
+			return
+		}
+		skipped := false
+		for _, s := range skip {
+			skipped = skipped || got == s
+		}
+		if !skipped {
+			t.Fatalf("got notification %q, want %q", got, want)
+		}
+	}
+}
+
+func TestSdNotify(t *testing.T) {
+	conn := listenNotify(t)
+	if err := sdNotify("READY=1"); err != nil {
+		t.Fatal(err)
+	}
+	expectNotify(t, conn, "READY=1")
+
+	unsetenv(t, "NOTIFY_SOCKET")
+	if err := sdNotify("READY=1"); err != nil {
+		t.Errorf("sdNotify without NOTIFY_SOCKET: %v", err)
+	}
+}
+
+func TestNotifyAbstractSocket(t *testing.T) {
+	name := "welcome-systemd-test-" + filepath.Base(t.TempDir())
+	conn, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: "\x00" + name, Net: "unixgram"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	if err := notify("@"+name, "STOPPING=1"); err != nil {
+		t.Fatal(err)
+	}
+	expectNotify(t, conn, "STOPPING=1")
+}
+
+func TestWatchdogInterval(t *testing.T) {
+	pid := strconv.Itoa(os.Getpid())
+	for _, tc := range []struct {
+		usec, pid string
+		want      time.Duration
+	}{
+		{"", "", 0},
+		{"0", "", 0},
+		{"bad", "", 0},
+		{"2000000", "", time.Second},
+		{"2000000", pid, time.Second},
+		{"2000000", "1", 0},
+	} {
+		setenv(t, "WATCHDOG_USEC", tc.usec)
+		setenv(t, "WATCHDOG_PID", tc.pid)
+		if got := watchdogInterval(); got != tc.want {
+			t.Errorf("WATCHDOG_USEC=%q WATCHDOG_PID=%q: got %v, want %v", tc.usec, tc.pid, got, tc.want)
+		}
+	}
+}
+
+func TestRunNotifiesServiceManager(t *testing.T) {
+	conn := listenNotify(t)
+	setenv(t, "WATCHDOG_USEC", "20000")
+	unsetenv(t, "WATCHDOG_PID")
+	unsetenv(t, upgradeNotifyEnv)
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	probed := make(chan struct{}, 1)
+	probe := func() {
+		select {
+		case probed <- struct{}{}:
+		default:
+		}
+	}
+	done := make(chan struct{})
+	go func() {
+		run(grpc.NewServer(), lis, nil, nil, probe)
+		close(done)
+	}()
+
+	expectNotify(t, conn, "READY=1")
+	expectNotify(t, conn, "WATCHDOG=1")
+	select {
+	case <-probed:
+	default:
+		t.Error("WATCHDOG=1 sent without probing")
+	}
+	// run has been notified of signals since before READY=1, so this does
+	// not end the test.
+	if err := syscall.Kill(os.Getpid(), syscall.SIGTERM); err != nil {
+		t.Fatal(err)
+	}
+	expectNotify(t, conn, "STOPPING=1", "WATCHDOG=1")
+	select {
+	case <-done:
+	case <-time.After(shutdownTimeout):
+		t.Fatal("run did not return after SIGTERM")
+	}
+}
+
+func TestInheritListeners(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		// script starts the test binary as $0.
+		script string
+		env    []string
+		want   string
+	}{
+		{
+			name:   "socket activation",
+			script: `LISTEN_PID=$$; export LISTEN_PID; exec "$0"`,
+			env:    []string{"LISTEN_FDS=1", "LISTEN_FDNAMES=grpc"},
+			want:   "grpc ",
+		},
+		{
+			name:   "unnamed socket",
+			script: `LISTEN_PID=$$; export LISTEN_PID; exec "$0"`,
+			env:    []string{"LISTEN_FDS=1"},
+			want:   "grpc ",
+		},
+		{
+			name:   "upgrade",
+			script: `exec "$0"`,
+			env:    []string{upgradeFDNamesEnv + "=grpc"},
+			want:   "grpc ",
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			lis, err := net.Listen("tcp", "127.0.0.1:0")
+			if err != nil {
+				t.Fatal(err)
+			}
+			f, err := lis.(*net.TCPListener).File()
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer f.Close()
+			cmd := exec.Command("/bin/sh", "-c", tc.script, os.Args[0])
+			cmd.Env = append(os.Environ(), inheritChildEnv+"=1")
+			cmd.Env = append(cmd.Env, tc.env...)
+			cmd.ExtraFiles = []*os.File{f}
+			cmd.Stderr = os.Stderr
+			if err := cmd.Start(); err != nil {
+				t.Fatal(err)
+			}
+			defer cmd.Wait()
+			// Only the child accepts from now on.
+			addr := lis.Addr().String()
+			lis.Close()
+
+			conn, err := net.DialTimeout("tcp", addr, 5*time.Second)
+			if err != nil {
+				cmd.Process.Kill()
+				t.Fatal(err)
+			}
+			defer conn.Close()
+			conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+			line, err := bufio.NewReader(conn).ReadString('\n')
+			if err != nil {
+				cmd.Process.Kill()
+				t.Fatalf("reading from the child: %v", err)
+			}
+			// The child reports the name it found the listener under and that
+			// the environment was cleared.
+			if got := strings.TrimSuffix(line, "\n"); got != tc.want {
+				t.Errorf("child reported %q, want %q", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestInheritListenersOtherPID(t *testing.T) {
+	setenv(t, "LISTEN_PID", "1")
+	setenv(t, "LISTEN_FDS", "1")
+	unsetenv(t, upgradeFDNamesEnv)
+	if err := inheritListeners(); err != nil {
+		t.Fatal(err)
+	}
+	if len(inherited) != 0 || len(unnamed) != 0 {
+		t.Errorf("inherited %v and %v, meant for another process", inherited, unnamed)
+	}
+	if _, ok := os.LookupEnv("LISTEN_FDS"); ok {
+		t.Error("LISTEN_FDS left in the environment")
+	}
+}
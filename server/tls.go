@@ -0,0 +1,69 @@
+package main
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"os"
+
+	"google.golang.org/grpc/credentials"
+)
+
+// serverTLSConfig loads the server certificate and, if clientCAFile is set,
+// the CAs that client certificates must chain to. Clients without a valid
+// certificate are then refused (mutual TLS).
+func serverTLSConfig(certFile, keyFile, clientCAFile string) (*tls.Config, error) {
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return nil, err
+	}
+	cfg := &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		MinVersion:   tls.VersionTLS12,
+	}
+	if clientCAFile != "" {
+		pool, err := loadCertPool(clientCAFile)
+		if err != nil {
+			return nil, err
+		}
+		cfg.ClientCAs = pool
+		cfg.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+	return cfg, nil
+}
+
+func loadCertPool(file string) (*x509.CertPool, error) {
+	pem, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(pem) {
+		return nil, fmt.Errorf("no certificates in %s", file)
+	}
+	return pool, nil
+}
+
+// loopbackCredentials are used by the HTTP endpoints to call the server
+// itself. The server is trusted by pinning its own certificate, and the same
+// certificate is presented as the client certificate under mutual TLS, so it
+// must also be valid for client authentication. The identity interceptors
+// recognize the certificate and treat such calls as loopbackPrincipal, which
+// has no rights of its own; HTTP callers are told apart by their API keys.
+func loopbackCredentials(cfg *tls.Config) credentials.TransportCredentials {
+	own := cfg.Certificates[0]
+	return credentials.NewTLS(&tls.Config{
+		Certificates: []tls.Certificate{own},
+		// Verification is done by VerifyPeerCertificate below.
+		InsecureSkipVerify: true,
+		VerifyPeerCertificate: func(rawCerts [][]byte, _ [][]*x509.Certificate) error {
+			if len(rawCerts) == 0 || !bytes.Equal(rawCerts[0], own.Certificate[0]) {
+				return errors.New("loopback peer is not this server")
+			}
+			return nil
+		},
+		MinVersion: tls.VersionTLS12,
+	})
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+// upgradeNotifyEnv names the socket on which a process started by upgrade
+// reports READY=1 to its parent instead of to the service manager.
+const upgradeNotifyEnv = "UPGRADE_NOTIFY_SOCKET"
+
+// upgradeFDNamesEnv lists the names of the listeners handed to a process
+// started by upgrade, colon-separated, in file descriptor order from 3.
+const upgradeFDNamesEnv = "UPGRADE_FDNAMES"
+
+// upgrade starts a new copy of the server binary, handing it the listening
+// sockets and the data directory, and waits for it to report ready. On
+// success the new process is announced to the service manager as the main
+// one and the caller should drain and exit, refusing changes to the data
+// directory meanwhile. On failure the new process is killed, the data
+// directory is taken back and the caller keeps serving.
+func upgrade(data *dataDirLock) error {
+	var (
+		names []string
+		files []*os.File
+	)
+	defer func() {
+		for _, f := range files {
+			f.Close()
+		}
+	}()
+	for _, l := range listening {
+		tl, ok := l.lis.(*net.TCPListener)
+		if !ok {
+			return fmt.Errorf("cannot pass %s listener of type %T", l.name, l.lis)
+		}
+		f, err := tl.File()
+		if err != nil {
+			return err
+		}
+		names = append(names, l.name)
+		files = append(files, f)
+	}
+
+	sockName := fmt.Sprintf("@welcome-upgrade-%d", os.Getpid())
+	sock, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: "\x00" + sockName[1:], Net: "unixgram"})
+	if err != nil {
+		return err
+	}
+	defer sock.Close()
+
+	cmd := exec.Command(os.Args[0], os.Args[1:]...)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	cmd.ExtraFiles = files
+	for _, kv := range os.Environ() {
+		// The watchdog belongs to whichever process is the main one.
+		if !strings.HasPrefix(kv, "WATCHDOG_PID=") {
+			cmd.Env = append(cmd.Env, kv)
+		}
+	}
+	cmd.Env = append(cmd.Env,
+		upgradeNotifyEnv+"="+sockName,
+		upgradeFDNamesEnv+"="+strings.Join(names, ":"),
+	)
+	// The new process opens the stores as soon as it starts.
+	if err := data.handOff(); err != nil {
+		return fmt.Errorf("failed to hand off the data directory: %v", err)
+	}
+	if err := cmd.Start(); err != nil {
+		return rollBack(data, err)
+	}
+	log.Printf("upgrade: started pid %d", cmd.Process.Pid)
+
+	exited := make(chan error, 1)
+	go func() { exited <- cmd.Wait() }()
+	ready := make(chan error, 1)
+	go func() { ready <- waitReady(sock) }()
+	select {
+	case err = <-ready:
+	case err = <-exited:
+		if err == nil {
+			err = errors.New("exited")
+		}
+		err = fmt.Errorf("new process %v before becoming ready", err)
+		exited <- nil
+	case <-time.After(*upgradeTimeout):
+		err = fmt.Errorf("new process not ready after %v", *upgradeTimeout)
+	}
+	if err != nil {
+		cmd.Process.Kill()
+		<-exited
+		return rollBack(data, err)
+	}
+	if err := sdNotify(fmt.Sprintf("MAINPID=%d", cmd.Process.Pid)); err != nil {
+		log.Printf("failed to notify service manager: %v", err)
+	}
+	return nil
+}
+
+// rollBack takes the data directory back after a failed upgrade and
+// returns err.
+func rollBack(data *dataDirLock, err error) error {
+	if rerr := data.reclaim(); rerr != nil {
+		log.Printf("upgrade: failed to take back the data directory, refusing changes: %v", rerr)
+	}
+	return err
+}
+
+// waitReady reads notifications from sock until one contains READY=1.
+func waitReady(sock *net.UnixConn) error {
+	buf := make([]byte, 4096)
+	for {
+		n, err := sock.Read(buf)
+		if err != nil {
+			return err
+		}
+		for _, line := range strings.Split(string(buf[:n]), "\n") {
+			if line == "READY=1" {
+				return nil
+			}
+		}
+	}
+}
+
+// notifyReady reports readiness to the process that started this one for an
+// upgrade, or else to the service manager.
+func notifyReady() error {
+	if path := os.Getenv(upgradeNotifyEnv); path != "" {
+		os.Unsetenv(upgradeNotifyEnv)
+		return notify(path, "READY=1")
+	}
+	return sdNotify("READY=1")
+}
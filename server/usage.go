@@ -0,0 +1,433 @@
+package main
+
+import (
+	"context"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"log"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	pb "example.com/grpc-go"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+)
+
+const (
+	// usageFlushInterval is how often changed usage is written to disk.
+	usageFlushInterval = 10 * time.Second
+	// usageHourRetention and usageDayRetention are how long hourly and
+	// daily usage is kept; monthly usage is kept forever.
+	usageHourRetention = 48 * time.Hour
+	usageDayRetention  = 92 * 24 * time.Hour
+
+	// rollingDayHours and rollingMonthDays are the lengths of the windows
+	// that daily and monthly quotas apply to.
+	rollingDayHours  = 24
+	rollingMonthDays = 30
+
+	hourLayout  = "2006-01-02T15"
+	dayLayout   = "2006-01-02"
+	monthLayout = "2006-01"
+)
+
+// usageCounts is the usage of one caller in one period.
+type usageCounts struct {
+	Calls    uint64 `json:"calls"`
+	Messages uint64 `json:"messages"`
+	Bytes    uint64 `json:"bytes"`
+}
+
+func (u *usageCounts) proto() *pb.Usage {
+	return &pb.Usage{Calls: u.Calls, Messages: u.Messages, Bytes: u.Bytes}
+}
+
+// plus adds c, which may be nil, to u.
+func (u *usageCounts) plus(c *usageCounts) {
+	if c != nil {
+		u.Calls += c.Calls
+		u.Messages += c.Messages
+		u.Bytes += c.Bytes
+	}
+}
+
+// quotaLimits caps the usage of a caller in the rolling day and month. Zero
+// fields are unlimited.
+type quotaLimits struct {
+	Daily   usageCounts `json:"daily"`
+	Monthly usageCounts `json:"monthly"`
+}
+
+// quotaConfig is read from the file given by -quota_file, for example:
+//
+//	{
+//	  "default": {"daily": {"calls": 10000}},
+//	  "identities": {
+//	    "spiffe://example.org/ns/prod/sa/frontend": {"monthly": {"bytes": 10000000000}},
+//	    "apikey:c5b46f924cbd1ce2": {"daily": {"calls": 100}}
+//	  }
+//	}
+type quotaConfig struct {
+	Default    quotaLimits            `json:"default"`
+	Identities map[string]quotaLimits `json:"identities"`
+}
+
+func (c *quotaConfig) limits(identity string) quotaLimits {
+	if c == nil {
+		return quotaLimits{}
+	}
+	if l, ok := c.Identities[identity]; ok {
+		return l
+	}
+	return c.Default
+}
+
+// meter counts calls, messages and bytes per caller identity in hourly,
+// daily and monthly periods, in UTC, and enforces quotas on rolling windows
+// of them: the daily quota applies to the last 24 hours, counted by the
+// hour, and the monthly quota to the last 30 days, counted by the day.
+// Usage is kept in memory and flushed to a JSON file in the background.
+type meter struct {
+	path   string
+	lock   *dataDirLock
+	quotas *quotaConfig
+
+	mu sync.Mutex
+	// periods maps an hour (YYYY-MM-DDTHH), day (YYYY-MM-DD) or month
+	// (YYYY-MM) to the usage of each identity in it.
+	periods map[string]map[string]*usageCounts
+	// windows holds the usage of identities in the rolling day and month,
+	// so that checking quotas need not add up the periods on every message.
+	windows map[string]*usageWindows
+	dirty   bool
+	done    chan struct{}
+	stopped chan struct{}
+}
+
+// newMeter reads the usage at path and starts flushing it. Writes go through
+// lock, which may be nil.
+func newMeter(path string, quotas *quotaConfig, lock *dataDirLock) (*meter, error) {
+	m := &meter{
+		path:    path,
+		lock:    lock,
+		quotas:  quotas,
+		done:    make(chan struct{}),
+		stopped: make(chan struct{}),
+	}
+	if err := m.reload(); err != nil {
+		return nil, err
+	}
+	go m.flushLoop()
+	return m, nil
+}
+
+// reload reads the usage from disk again, dropping any not yet flushed.
+func (m *meter) reload() error {
+	periods := make(map[string]map[string]*usageCounts)
+	if err := readJSONFile(m.path, &periods); err != nil {
+		return err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.periods = periods
+	m.windows = make(map[string]*usageWindows)
+	m.dirty = false
+	return nil
+}
+
+func loadQuotaConfig(path string) (*quotaConfig, error) {
+	c := &quotaConfig{}
+	if err := readJSONFile(path, c); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+func (m *meter) flushLoop() {
+	defer close(m.stopped)
+	t := time.NewTicker(usageFlushInterval)
+	defer t.Stop()
+	for {
+		select {
+		case <-t.C:
+			if err := m.flush(); err != nil && err != errHandedOff {
+				log.Printf("failed to save usage: %v", err)
+			}
+		case <-m.done:
+			return
+		}
+	}
+}
+
+// flush writes the usage to disk if it changed, dropping expired hours and
+// days.
+func (m *meter) flush() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if !m.dirty {
+		return nil
+	}
+	now := time.Now().UTC()
+	oldestHour := now.Add(-usageHourRetention).Format(hourLayout)
+	oldestDay := now.Add(-usageDayRetention).Format(dayLayout)
+	for p := range m.periods {
+		if len(p) == len(hourLayout) && p < oldestHour || len(p) == len(dayLayout) && p < oldestDay {
+			delete(m.periods, p)
+		}
+	}
+	if err := m.lock.write(func() error { return writeJSONFile(m.path, m.periods) }); err != nil {
+		return err
+	}
+	m.dirty = false
+	return nil
+}
+
+// Close stops the background flushing and saves the usage one last time.
+func (m *meter) Close() error {
+	close(m.done)
+	<-m.stopped
+	return m.flush()
+}
+
+// counts returns the usage of identity in period, creating it if needed.
+// m.mu must be held.
+func (m *meter) counts(period, identity string) *usageCounts {
+	ids := m.periods[period]
+	if ids == nil {
+		ids = make(map[string]*usageCounts)
+		m.periods[period] = ids
+	}
+	c := ids[identity]
+	if c == nil {
+		c = &usageCounts{}
+		ids[identity] = c
+	}
+	return c
+}
+
+// add records usage by identity, now.
+func (m *meter) add(identity string, calls, messages, bytes uint64) {
+	now := time.Now().UTC()
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, period := range []string{now.Format(hourLayout), now.Format(dayLayout), now.Format(monthLayout)} {
+		c := m.counts(period, identity)
+		c.Calls += calls
+		c.Messages += messages
+		c.Bytes += bytes
+	}
+	if w := m.windows[identity]; w != nil {
+		added := &usageCounts{Calls: calls, Messages: messages, Bytes: bytes}
+		w.daily.plus(added)
+		w.monthly.plus(added)
+	}
+	m.dirty = true
+}
+
+// usageWindows is the usage of an identity in the rolling day and month
+// that started at daySince and monthSince.
+type usageWindows struct {
+	daySince, monthSince time.Time
+	daily, monthly       usageCounts
+}
+
+// windowsOf returns the usage of identity in the rolling day and month as of
+// now, adding up the periods only when the windows moved on since last
+// asked. m.mu must be held.
+func (m *meter) windowsOf(identity string, now time.Time) *usageWindows {
+	daySince := now.Truncate(time.Hour).Add(-(rollingDayHours - 1) * time.Hour)
+	y, mo, d := now.Date()
+	monthSince := time.Date(y, mo, d-(rollingMonthDays-1), 0, 0, 0, 0, time.UTC)
+	if w := m.windows[identity]; w != nil && w.daySince.Equal(daySince) && w.monthSince.Equal(monthSince) {
+		return w
+	}
+	w := &usageWindows{daySince: daySince, monthSince: monthSince}
+	for t := daySince; !t.After(now); t = t.Add(time.Hour) {
+		w.daily.plus(m.periods[t.Format(hourLayout)][identity])
+	}
+	for t := monthSince; !t.After(now); t = t.AddDate(0, 0, 1) {
+		w.monthly.plus(m.periods[t.Format(dayLayout)][identity])
+	}
+	m.windows[identity] = w
+	return w
+}
+
+// usage returns the usage of identity in the rolling day and month, with
+// the times they started.
+func (m *meter) usage(identity string) (daySince time.Time, daily usageCounts, monthSince time.Time, monthly usageCounts) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	w := m.windowsOf(identity, time.Now().UTC())
+	return w.daySince, w.daily, w.monthSince, w.monthly
+}
+
+// check fails with ResourceExhausted, detailing every exceeded limit in a
+// google.rpc.QuotaFailure, if identity has used up one of its quotas.
+func (m *meter) check(identity string) error {
+	limits := m.quotas.limits(identity)
+	if limits == (quotaLimits{}) {
+		return nil
+	}
+	_, daily, _, monthly := m.usage(identity)
+	var violations []*errdetails.QuotaFailure_Violation
+	exceeded := func(period, what string, used, limit uint64) {
+		if limit > 0 && used >= limit {
+			violations = append(violations, &errdetails.QuotaFailure_Violation{
+				Subject:     identity,
+				Description: fmt.Sprintf("%s %s quota of %d exhausted", period, what, limit),
+			})
+		}
+	}
+	exceeded("daily", "calls", daily.Calls, limits.Daily.Calls)
+	exceeded("daily", "messages", daily.Messages, limits.Daily.Messages)
+	exceeded("daily", "bytes", daily.Bytes, limits.Daily.Bytes)
+	exceeded("monthly", "calls", monthly.Calls, limits.Monthly.Calls)
+	exceeded("monthly", "messages", monthly.Messages, limits.Monthly.Messages)
+	exceeded("monthly", "bytes", monthly.Bytes, limits.Monthly.Bytes)
+	if len(violations) == 0 {
+		return nil
+	}
+	st, err := status.New(codes.ResourceExhausted, "quota exhausted for "+identity).
+		WithDetails(&errdetails.QuotaFailure{Violations: violations})
+	if err != nil {
+		return status.Error(codes.ResourceExhausted, "quota exhausted for "+identity)
+	}
+	return st.Err()
+}
+
+// apiKeyIdentityPrefix starts the identities of API keys, followed by the
+// key ID.
+const apiKeyIdentityPrefix = "apikey:"
+
+// callerIdentity names the caller for metering: its API key, else its
+// certificate identity, else "anonymous". The key comes first because it
+// tells apart the callers behind one certificate, such as those of the
+// HTTP endpoints. Keys go by ID, as their names need not be unique.
+func callerIdentity(ctx context.Context) string {
+	if k, ok := apiKeyFromContext(ctx); ok {
+		return apiKeyIdentityPrefix + k.ID
+	}
+	if pr, ok := principalFromContext(ctx); ok {
+		return pr.ID
+	}
+	return "anonymous"
+}
+
+// messageSize returns the encoded size of a message passing through an
+// interceptor.
+func messageSize(m interface{}) uint64 {
+	switch m := m.(type) {
+	case proto.Message:
+		return uint64(proto.Size(m))
+	case *[]byte:
+		return uint64(len(*m))
+	}
+	return 0
+}
+
+func (m *meter) unaryInterceptor(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	identity := callerIdentity(ctx)
+	// The AdminService is metered but exempt from quotas, so that callers
+	// can still look up their usage once they have run out.
+	if !strings.HasPrefix(info.FullMethod, adminServicePrefix) {
+		if err := m.check(identity); err != nil {
+			return nil, err
+		}
+	}
+	resp, err := handler(ctx, req)
+	messages, bytes := uint64(1), messageSize(req)
+	if err == nil {
+		messages++
+		bytes += messageSize(resp)
+	}
+	m.add(identity, 1, messages, bytes)
+	return resp, err
+}
+
+func (m *meter) streamInterceptor(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	identity := callerIdentity(ss.Context())
+	m.add(identity, 1, 0, 0)
+	if strings.HasPrefix(info.FullMethod, adminServicePrefix) {
+		return handler(srv, ss)
+	}
+	if err := m.check(identity); err != nil {
+		return err
+	}
+	return handler(srv, &meteredStream{ServerStream: ss, meter: m, identity: identity})
+}
+
+// meteredStream counts the messages of a stream as they pass, and ends the
+// stream once the caller runs out of quota.
+type meteredStream struct {
+	grpc.ServerStream
+	meter    *meter
+	identity string
+}
+
+func (s *meteredStream) SendMsg(msg interface{}) error {
+	if err := s.meter.check(s.identity); err != nil {
+		return err
+	}
+	err := s.ServerStream.SendMsg(msg)
+	if err == nil {
+		s.meter.add(s.identity, 0, 1, messageSize(msg))
+	}
+	return err
+}
+
+func (s *meteredStream) RecvMsg(msg interface{}) error {
+	err := s.ServerStream.RecvMsg(msg)
+	if err != nil {
+		return err
+	}
+	s.meter.add(s.identity, 0, 1, messageSize(msg))
+	return s.meter.check(s.identity)
+}
+
+// writeUsageCSV writes the usage in the file at path as CSV, one row per
+// period and identity, for the periods starting with prefix.
+func writeUsageCSV(w io.Writer, path, prefix string) error {
+	periods := make(map[string]map[string]*usageCounts)
+	if err := readJSONFile(path, &periods); err != nil {
+		return err
+	}
+	type row struct {
+		period, identity string
+		c                *usageCounts
+	}
+	var rows []row
+	for p, ids := range periods {
+		if !strings.HasPrefix(p, prefix) {
+			continue
+		}
+		for id, c := range ids {
+			rows = append(rows, row{p, id, c})
+		}
+	}
+	sort.Slice(rows, func(i, j int) bool {
+		if rows[i].period != rows[j].period {
+			return rows[i].period < rows[j].period
+		}
+		return rows[i].identity < rows[j].identity
+	})
+	cw := csv.NewWriter(w)
+	cw.Write([]string{"period", "identity", "calls", "messages", "bytes"})
+	for _, r := range rows {
+		cw.Write([]string{
+			r.period,
+			r.identity,
+			strconv.FormatUint(r.c.Calls, 10),
+			strconv.FormatUint(r.c.Messages, 10),
+			strconv.FormatUint(r.c.Bytes, 10),
+		})
+	}
+	cw.Flush()
+	return cw.Error()
+}
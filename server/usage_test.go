@@ -0,0 +1,36 @@
+package main
+
+import (
+	"path/filepath"
+	"testing"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestMeterCheckKeepsUpWithUsage(t *testing.T) {
+	quotas := &quotaConfig{Identities: map[string]quotaLimits{
+		"apikey:k1": {Daily: usageCounts{Calls: 3}},
+	}}
+	m, err := newMeter(filepath.Join(t.TempDir(), "usage.json"), quotas, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer m.Close()
+	for i := 0; i < 3; i++ {
+		if err := m.check("apikey:k1"); err != nil {
+			t.Fatalf("after %d calls: %v", i, err)
+		}
+		m.add("apikey:k1", 1, 2, 10)
+		m.add("apikey:k2", 1, 2, 10)
+	}
+	if err := m.check("apikey:k1"); status.Code(err) != codes.ResourceExhausted {
+		t.Errorf("after 3 calls: got %v, want ResourceExhausted", err)
+	}
+	if err := m.check("apikey:k2"); err != nil {
+		t.Errorf("identity without quotas: %v", err)
+	}
+	if _, daily, _, monthly := m.usage("apikey:k1"); daily.Calls != 3 || monthly.Bytes != 30 {
+		t.Errorf("got daily %+v, monthly %+v; want 3 calls and 30 bytes", daily, monthly)
+	}
+}
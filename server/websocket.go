@@ -0,0 +1,314 @@
+package main
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// This file implements the server side of RFC 6455, just enough to bridge
+// JSON messages to gRPC streams. golang.org/x/net/websocket can neither send
+// custom close codes nor report pongs, both of which the bridge needs.
+
+const (
+	wsOpContinuation = 0x0
+	wsOpText         = 0x1
+	wsOpBinary       = 0x2
+	wsOpClose        = 0x8
+	wsOpPing         = 0x9
+	wsOpPong         = 0xa
+
+	wsCloseNormal    = 1000
+	wsCloseGoingAway = 1001
+	wsCloseProtocol  = 1002
+	wsCloseNoStatus  = 1005
+	wsCloseTooBig    = 1009
+
+	wsMaxMessageSize = 1 << 20
+	wsAcceptGUID     = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+)
+
+// wsCloseError is returned by readMessage once the peer has closed the
+// connection.
+type wsCloseError struct {
+	code   int
+	reason string
+}
+
+func (e *wsCloseError) Error() string {
+	return fmt.Sprintf("websocket closed by peer: %d %s", e.code, e.reason)
+}
+
+type wsConn struct {
+	conn net.Conn
+	br   *bufio.Reader
+
+	wmu    sync.Mutex // serializes frame writes
+	closed bool       // a close frame was sent
+
+	amu      sync.Mutex
+	lastRead time.Time // any frame, including pongs, counts as activity
+}
+
+// upgradeWebSocket performs the opening handshake and takes over the
+// underlying connection. Browsers, which send an Origin header, are only let
+// in from the server's own origin or one in allowedOrigins, so that other
+// sites cannot open sockets with their users' cookies or credentials. The
+// first offered subprotocol that is not skipped is echoed back.
+func upgradeWebSocket(w http.ResponseWriter, r *http.Request, allowedOrigins []string, skipProtocol func(string) bool) (*wsConn, error) {
+	if r.Method != http.MethodGet ||
+		!headerContains(r.Header, "Connection", "upgrade") ||
+		!headerContains(r.Header, "Upgrade", "websocket") {
+		http.Error(w, "websocket upgrade required", http.StatusUpgradeRequired)
+		return nil, errors.New("not a websocket handshake")
+	}
+	if r.Header.Get("Sec-Websocket-Version") != "13" {
+		w.Header().Set("Sec-WebSocket-Version", "13")
+		http.Error(w, "unsupported websocket version", http.StatusBadRequest)
+		return nil, errors.New("unsupported websocket version")
+	}
+	if origin := r.Header.Get("Origin"); origin != "" && !originAllowed(origin, r.Host, allowedOrigins) {
+		http.Error(w, "origin not allowed", http.StatusForbidden)
+		return nil, fmt.Errorf("origin %q not allowed", origin)
+	}
+	key := r.Header.Get("Sec-Websocket-Key")
+	if key == "" {
+		http.Error(w, "missing Sec-WebSocket-Key", http.StatusBadRequest)
+		return nil, errors.New("missing Sec-WebSocket-Key")
+	}
+	hj, ok := w.(http.Hijacker)
+	if !ok {
+		http.Error(w, "websocket not supported", http.StatusInternalServerError)
+		return nil, errors.New("response writer cannot be hijacked")
+	}
+	conn, rw, err := hj.Hijack()
+	if err != nil {
+		return nil, err
+	}
+	sum := sha1.Sum([]byte(key + wsAcceptGUID))
+	resp := "HTTP/1.1 101 Switching Protocols\r\n" +
+		"Upgrade: websocket\r\n" +
+		"Connection: Upgrade\r\n" +
+		"Sec-WebSocket-Accept: " + base64.StdEncoding.EncodeToString(sum[:]) + "\r\n"
+	// Browsers refuse the connection unless one of the offered subprotocols
+	// is echoed. The bridge does not depend on it.
+	for _, proto := range headerTokens(r.Header, "Sec-Websocket-Protocol") {
+		if !skipProtocol(proto) {
+			resp += "Sec-WebSocket-Protocol: " + proto + "\r\n"
+			break
+		}
+	}
+	if _, err := conn.Write([]byte(resp + "\r\n")); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return &wsConn{conn: conn, br: rw.Reader, lastRead: time.Now()}, nil
+}
+
+// originAllowed reports whether a browser at origin may connect to host.
+func originAllowed(origin, host string, allowed []string) bool {
+	if u, err := url.Parse(origin); err == nil && strings.EqualFold(u.Host, host) {
+		return true
+	}
+	for _, a := range allowed {
+		if strings.EqualFold(a, origin) {
+			return true
+		}
+	}
+	return false
+}
+
+func headerContains(h http.Header, name, token string) bool {
+	for _, t := range headerTokens(h, name) {
+		if strings.EqualFold(t, token) {
+			return true
+		}
+	}
+	return false
+}
+
+// headerTokens returns the comma-separated values of a header.
+func headerTokens(h http.Header, name string) []string {
+	var out []string
+	for _, v := range h.Values(name) {
+		for _, t := range strings.Split(v, ",") {
+			if t = strings.TrimSpace(t); t != "" {
+				out = append(out, t)
+			}
+		}
+	}
+	return out
+}
+
+// readMessage returns the next text or binary message, answering pings and
+// reassembling fragments along the way. After the peer sends a close frame it
+// replies with a close frame and returns a *wsCloseError.
+func (c *wsConn) readMessage() (int, []byte, error) {
+	var (
+		op  int
+		msg []byte
+	)
+	for {
+		fin, frameOp, payload, err := c.readFrame()
+		if err != nil {
+			return 0, nil, err
+		}
+		c.amu.Lock()
+		c.lastRead = time.Now()
+		c.amu.Unlock()
+		switch frameOp {
+		case wsOpPing:
+			if err := c.writeFrame(wsOpPong, payload); err != nil {
+				return 0, nil, err
+			}
+			continue
+		case wsOpPong:
+			continue
+		case wsOpClose:
+			ce := &wsCloseError{code: wsCloseNoStatus}
+			if len(payload) >= 2 {
+				ce.code = int(binary.BigEndian.Uint16(payload))
+				ce.reason = string(payload[2:])
+			}
+			c.close(wsCloseNormal, "")
+			return 0, nil, ce
+		case wsOpText, wsOpBinary:
+			if op != 0 {
+				c.close(wsCloseProtocol, "expected continuation frame")
+				return 0, nil, errors.New("websocket: unexpected data frame")
+			}
+			op = frameOp
+		case wsOpContinuation:
+			if op == 0 {
+				c.close(wsCloseProtocol, "unexpected continuation frame")
+				return 0, nil, errors.New("websocket: unexpected continuation frame")
+			}
+		default:
+			c.close(wsCloseProtocol, "unknown opcode")
+			return 0, nil, fmt.Errorf("websocket: unknown opcode %d", frameOp)
+		}
+		if len(msg)+len(payload) > wsMaxMessageSize {
+			c.close(wsCloseTooBig, "message too big")
+			return 0, nil, errors.New("websocket: message too big")
+		}
+		msg = append(msg, payload...)
+		if fin {
+			return op, msg, nil
+		}
+	}
+}
+
+func (c *wsConn) readFrame() (fin bool, op int, payload []byte, err error) {
+	var hdr [2]byte
+	if _, err = io.ReadFull(c.br, hdr[:]); err != nil {
+		return
+	}
+	fin = hdr[0]&0x80 != 0
+	op = int(hdr[0] & 0x0f)
+	if hdr[0]&0x70 != 0 {
+		c.close(wsCloseProtocol, "reserved bits set")
+		return false, 0, nil, errors.New("websocket: reserved bits set")
+	}
+	if hdr[1]&0x80 == 0 {
+		c.close(wsCloseProtocol, "client frames must be masked")
+		return false, 0, nil, errors.New("websocket: unmasked client frame")
+	}
+	n := uint64(hdr[1] & 0x7f)
+	switch n {
+	case 126:
+		var ext [2]byte
+		if _, err = io.ReadFull(c.br, ext[:]); err != nil {
+			return
+		}
+		n = uint64(binary.BigEndian.Uint16(ext[:]))
+	case 127:
+		var ext [8]byte
+		if _, err = io.ReadFull(c.br, ext[:]); err != nil {
+			return
+		}
+		n = binary.BigEndian.Uint64(ext[:])
+	}
+	if op >= wsOpClose && (n > 125 || !fin) {
+		c.close(wsCloseProtocol, "invalid control frame")
+		return false, 0, nil, errors.New("websocket: invalid control frame")
+	}
+	if n > wsMaxMessageSize {
+		c.close(wsCloseTooBig, "message too big")
+		return false, 0, nil, errors.New("websocket: frame too big")
+	}
+	var mask [4]byte
+	if _, err = io.ReadFull(c.br, mask[:]); err != nil {
+		return
+	}
+	payload = make([]byte, n)
+	if _, err = io.ReadFull(c.br, payload); err != nil {
+		return
+	}
+	for i := range payload {
+		payload[i] ^= mask[i%4]
+	}
+	return fin, op, payload, nil
+}
+
+// writeFrame sends a single unfragmented frame. It is safe for concurrent use.
+func (c *wsConn) writeFrame(op int, payload []byte) error {
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+	if c.closed {
+		return errors.New("websocket: connection closed")
+	}
+	return c.writeFrameLocked(op, payload)
+}
+
+func (c *wsConn) writeFrameLocked(op int, payload []byte) error {
+	hdr := []byte{0x80 | byte(op)}
+	switch n := len(payload); {
+	case n <= 125:
+		hdr = append(hdr, byte(n))
+	case n <= 0xffff:
+		hdr = append(hdr, 126, byte(n>>8), byte(n))
+	default:
+		var ext [8]byte
+		binary.BigEndian.PutUint64(ext[:], uint64(n))
+		hdr = append(append(hdr, 127), ext[:]...)
+	}
+	c.conn.SetWriteDeadline(time.Now().Add(10 * time.Second))
+	if _, err := c.conn.Write(append(hdr, payload...)); err != nil {
+		return err
+	}
+	return nil
+}
+
+// close sends a close frame with the given code unless one was already sent.
+// The reason is truncated to fit in a control frame.
+func (c *wsConn) close(code int, reason string) {
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+	if c.closed {
+		return
+	}
+	if len(reason) > 123 {
+		reason = reason[:123]
+	}
+	payload := make([]byte, 2, 2+len(reason))
+	binary.BigEndian.PutUint16(payload, uint16(code))
+	c.writeFrameLocked(wsOpClose, append(payload, reason...))
+	c.closed = true
+}
+
+// idle returns how long ago the last frame was received from the peer.
+func (c *wsConn) idle() time.Duration {
+	c.amu.Lock()
+	defer c.amu.Unlock()
+	return time.Since(c.lastRead)
+}
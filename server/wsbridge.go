@@ -0,0 +1,219 @@
+package main
+
+import (
+	"context"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	pb "example.com/grpc-go"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/reflect/protoregistry"
+)
+
+const (
+	wsPingInterval = 30 * time.Second
+	// wsCloseStatusBase is added to a gRPC status code to form the close
+	// code, e.g. 4016 for Unauthenticated. 4000-4999 is reserved for
+	// applications by RFC 6455.
+	wsCloseStatusBase = 4000
+	// wsBearerProtocol starts a subprotocol that carries a bearer token,
+	// as browsers cannot set headers on WebSockets.
+	wsBearerProtocol = "bearer."
+)
+
+// wsBridge exposes the streaming RPCs of WelcomeService over WebSockets at
+// /ws/welcome.WelcomeService/<Method>. Each text frame from the client is the
+// JSON encoding of one request message and each frame sent back is one
+// response. When the RPC ends the socket is closed with 1000 on success or
+// 4000 plus the gRPC status code on failure, with the status message as the
+// close reason.
+//
+// Calls go through a regular client connection to this server, so every
+// server interceptor applies. A bearer token from the Authorization header,
+// or for browsers from a "bearer.<token>" subprotocol offered along with
+// another one, such as "json", is forwarded as authorization metadata. Tokens
+// are not taken from the URL, which ends up in logs and browser history.
+// Browsers may connect from the server's own origin and allowedOrigins.
+type wsBridge struct {
+	cc             *grpc.ClientConn
+	methods        map[string]protoreflect.MethodDescriptor
+	allowedOrigins []string
+}
+
+// newWSBridge returns a bridge that lets in browsers from the server's own
+// origin and the comma-separated origins.
+func newWSBridge(cc *grpc.ClientConn, origins string) *wsBridge {
+	b := &wsBridge{cc: cc, methods: make(map[string]protoreflect.MethodDescriptor)}
+	for _, o := range strings.Split(origins, ",") {
+		if o = strings.TrimSpace(o); o != "" {
+			b.allowedOrigins = append(b.allowedOrigins, o)
+		}
+	}
+	sd := pb.File_welcome_proto.Services().ByName("WelcomeService")
+	for i := 0; i < sd.Methods().Len(); i++ {
+		md := sd.Methods().Get(i)
+		if md.IsStreamingServer() {
+			b.methods["/"+string(sd.FullName())+"/"+string(md.Name())] = md
+		}
+	}
+	return b
+}
+
+func (b *wsBridge) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	method := strings.TrimPrefix(r.URL.Path, "/ws")
+	md, ok := b.methods[method]
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	ws, err := upgradeWebSocket(w, r, b.allowedOrigins, isBearerProtocol)
+	if err != nil {
+		log.Printf("websocket %s: %v", method, err)
+		return
+	}
+	defer ws.conn.Close()
+
+	ctx, cancel := context.WithCancel(r.Context())
+	defer cancel()
+	if tok := wsBearerToken(r); tok != "" {
+		ctx = metadata.AppendToOutgoingContext(ctx, "authorization", "Bearer "+tok)
+	}
+	desc := &grpc.StreamDesc{ServerStreams: true, ClientStreams: md.IsStreamingClient()}
+	stream, err := b.cc.NewStream(ctx, desc, method)
+	if err != nil {
+		ws.close(closeCodeFor(err))
+		return
+	}
+	go b.forwardRequests(cancel, ws, stream, md)
+	go b.keepAlive(ctx, cancel, ws)
+
+	for {
+		out, err := newMessage(md.Output())
+		if err == nil {
+			err = stream.RecvMsg(out)
+		}
+		if err == io.EOF {
+			ws.close(wsCloseNormal, "")
+			return
+		}
+		if err != nil {
+			ws.close(closeCodeFor(err))
+			return
+		}
+		data, err := protojson.Marshal(out)
+		if err != nil {
+			ws.close(closeCodeFor(status.Errorf(codes.Internal, "encoding response: %v", err)))
+			return
+		}
+		if err := ws.writeFrame(wsOpText, data); err != nil {
+			return
+		}
+	}
+}
+
+// forwardRequests decodes client frames and sends them on the stream. For
+// server-streaming methods only the first frame is used.
+func (b *wsBridge) forwardRequests(cancel context.CancelFunc, ws *wsConn, stream grpc.ClientStream, md protoreflect.MethodDescriptor) {
+	sent := false
+	for {
+		_, data, err := ws.readMessage()
+		if err != nil {
+			// Whether the client closed the socket or it broke, the call
+			// cannot continue.
+			cancel()
+			return
+		}
+		if sent && !md.IsStreamingClient() {
+			continue
+		}
+		in, err := newMessage(md.Input())
+		if err == nil {
+			err = protojson.Unmarshal(data, in)
+		}
+		if err != nil {
+			ws.close(closeCodeFor(status.Errorf(codes.InvalidArgument, "decoding request: %v", err)))
+			cancel()
+			return
+		}
+		if err := stream.SendMsg(in); err != nil {
+			// The RPC failed; the receiving side reports the status.
+			return
+		}
+		sent = true
+		if !md.IsStreamingClient() {
+			stream.CloseSend()
+		}
+	}
+}
+
+// keepAlive pings the client and gives up on it when nothing, not even a
+// pong, was received for two ping intervals.
+func (b *wsBridge) keepAlive(ctx context.Context, cancel context.CancelFunc, ws *wsConn) {
+	t := time.NewTicker(wsPingInterval)
+	defer t.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+		}
+		if ws.idle() > 2*wsPingInterval {
+			ws.close(wsCloseGoingAway, "ping timeout")
+			cancel()
+			return
+		}
+		if err := ws.writeFrame(wsOpPing, nil); err != nil {
+			cancel()
+			return
+		}
+	}
+}
+
+// closeCodeFor maps an RPC error to a WebSocket close code and reason.
+func closeCodeFor(err error) (int, string) {
+	st := status.Convert(err)
+	if st.Code() == codes.OK {
+		return wsCloseNormal, ""
+	}
+	return wsCloseStatusBase + int(st.Code()), st.Message()
+}
+
+func newMessage(md protoreflect.MessageDescriptor) (proto.Message, error) {
+	mt, err := protoregistry.GlobalTypes.FindMessageByName(md.FullName())
+	if err != nil {
+		return nil, err
+	}
+	return mt.New().Interface(), nil
+}
+
+func bearerToken(r *http.Request) string {
+	if h := r.Header.Get("Authorization"); strings.HasPrefix(h, "Bearer ") {
+		return strings.TrimPrefix(h, "Bearer ")
+	}
+	return ""
+}
+
+// wsBearerToken returns the bearer token of a WebSocket handshake, from the
+// Authorization header or a bearer subprotocol.
+func wsBearerToken(r *http.Request) string {
+	if tok := bearerToken(r); tok != "" {
+		return tok
+	}
+	for _, proto := range headerTokens(r.Header, "Sec-Websocket-Protocol") {
+		if isBearerProtocol(proto) {
+			return strings.TrimPrefix(proto, wsBearerProtocol)
+		}
+	}
+	return ""
+}
+
+func isBearerProtocol(proto string) bool { return strings.HasPrefix(proto, wsBearerProtocol) }
@@ -0,0 +1,131 @@
+package welcome
+
+import (
+	"context"
+	"io"
+	"math/rand"
+	"strconv"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// Subscriber follows SubscribeWelcomes and reconnects with exponential
+// backoff when the stream breaks. It remembers the sequence number and epoch
+// of the last event it delivered and resumes after it, so the handler sees
+// every event exactly once.
+type Subscriber struct {
+	Client WelcomeServiceClient
+	// LastSeq and Epoch are those of the last event delivered. Set them to
+	// resume after an event seen earlier; Epoch may only be zero if LastSeq
+	// is.
+	LastSeq uint64
+	Epoch   uint64
+	// MinBackoff and MaxBackoff bound the delay between reconnections,
+	// which doubles while no events come through. They default to half a
+	// second and 30 seconds.
+	MinBackoff time.Duration
+	MaxBackoff time.Duration
+	// OnRetry, if not nil, is called with the error that broke the stream
+	// and the delay before reconnecting.
+	OnRetry func(err error, delay time.Duration)
+}
+
+// Run delivers events to handle until ctx is done or the server reports that
+// the events after LastSeq can no longer be replayed, such as after a
+// restart, with OUT_OF_RANGE.
+func (s *Subscriber) Run(ctx context.Context, handle func(*WelcomeEvent)) error {
+	minBackoff, maxBackoff := s.MinBackoff, s.MaxBackoff
+	if minBackoff <= 0 {
+		minBackoff = 500 * time.Millisecond
+	}
+	if maxBackoff <= 0 {
+		maxBackoff = 30 * time.Second
+	}
+	if maxBackoff < minBackoff {
+		maxBackoff = minBackoff
+	}
+	backoff := minBackoff
+	for {
+		delivered, err := s.follow(ctx, handle)
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if status.Code(err) == codes.OutOfRange {
+			return err
+		}
+		if delivered {
+			backoff = minBackoff
+		}
+		// Jitter the delay by up to ±20% so that clients dropped together do
+		// not reconnect together.
+		delay := backoff + time.Duration((rand.Float64()*0.4-0.2)*float64(backoff))
+		if s.OnRetry != nil {
+			s.OnRetry(err, delay)
+		}
+		select {
+		case <-time.After(delay):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+		if backoff *= 2; backoff > maxBackoff {
+			backoff = maxBackoff
+		}
+	}
+}
+
+// follow opens one stream and reads it until it fails. It reports whether
+// any event was delivered.
+func (s *Subscriber) follow(ctx context.Context, handle func(*WelcomeEvent)) (bool, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	stream, err := s.Client.SubscribeWelcomes(ctx, &SubscribeWelcomesRequest{ResumeAfter: s.LastSeq, Epoch: s.Epoch})
+	if err != nil {
+		return false, err
+	}
+	header, err := stream.Header()
+	if err != nil {
+		return false, err
+	}
+	if e := headerUint(header, "x-welcome-epoch"); s.Epoch == 0 && e != 0 {
+		// Take the position the stream starts after, so that resuming
+		// misses nothing even if it drops before the first event.
+		s.Epoch, s.LastSeq = e, headerUint(header, "x-welcome-resume-after")
+	}
+	delivered := false
+	for {
+		ev, err := stream.Recv()
+		if err == io.EOF {
+			return delivered, status.Error(codes.Unavailable, "stream closed by server")
+		}
+		if err != nil {
+			return delivered, err
+		}
+		if s.Epoch == 0 {
+			// A server that does not send its position.
+			s.Epoch = ev.GetEpoch()
+		}
+		if ev.GetEpoch() != s.Epoch {
+			// Only servers that do not check the epoch get here.
+			return delivered, status.Errorf(codes.OutOfRange, "the server restarted: got epoch %d, want %d", ev.GetEpoch(), s.Epoch)
+		}
+		if ev.GetSeq() <= s.LastSeq {
+			continue
+		}
+		s.LastSeq = ev.GetSeq()
+		delivered = true
+		handle(ev)
+	}
+}
+
+// headerUint returns the number in header key, or zero.
+func headerUint(header metadata.MD, key string) uint64 {
+	v := header.Get(key)
+	if len(v) == 0 {
+		return 0
+	}
+	n, _ := strconv.ParseUint(v[0], 10, 64)
+	return n
+}
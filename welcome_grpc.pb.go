@@ -20,6 +20,23 @@ const _ = grpc.SupportPackageIsVersion7
 type WelcomeServiceClient interface {
 	// Sends a greeting
 	SendWelcome(ctx context.Context, in *WelcomeRequest, opts ...grpc.CallOption) (*WelcomeResponse, error)
+	// Streams welcomes as they are sent. Every event carries a sequence
+	// number so that a client whose stream dropped can resume where it left off.
+	// Before any event, the x-welcome-epoch and x-welcome-resume-after
+	// response headers give the position the stream starts after, which is
+	// where to resume if it drops before the first event.
+	SubscribeWelcomes(ctx context.Context, in *SubscribeWelcomesRequest, opts ...grpc.CallOption) (WelcomeService_SubscribeWelcomesClient, error)
+	// Sends a greeting for every name received on the stream
+	SendWelcomes(ctx context.Context, opts ...grpc.CallOption) (WelcomeService_SendWelcomesClient, error)
+	// Summarizes the welcomes sent in a time range, in whole hours. Unique
+	// and top name counts are approximate.
+	GetWelcomeStats(ctx context.Context, in *GetWelcomeStatsRequest, opts ...grpc.CallOption) (*WelcomeStats, error)
+	// Renders a shareable welcome card as a PNG image, streamed in chunks.
+	RenderWelcomeCard(ctx context.Context, in *RenderWelcomeCardRequest, opts ...grpc.CallOption) (WelcomeService_RenderWelcomeCardClient, error)
+	// Streams a file of the welcome kit in fixed-size chunks, starting at an
+	// offset to resume an interrupted download. The hex SHA-256 digest of the
+	// whole file is sent in the x-content-sha256 trailer.
+	DownloadWelcomeKit(ctx context.Context, in *DownloadWelcomeKitRequest, opts ...grpc.CallOption) (WelcomeService_DownloadWelcomeKitClient, error)
 }
 
 type welcomeServiceClient struct {
@@ -39,12 +56,165 @@ func (c *welcomeServiceClient) SendWelcome(ctx context.Context, in *WelcomeReque
 	return out, nil
 }
 
+func (c *welcomeServiceClient) SubscribeWelcomes(ctx context.Context, in *SubscribeWelcomesRequest, opts ...grpc.CallOption) (WelcomeService_SubscribeWelcomesClient, error) {
+	stream, err := c.cc.NewStream(ctx, &WelcomeService_ServiceDesc.Streams[0], "/welcome.WelcomeService/SubscribeWelcomes", opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &welcomeServiceSubscribeWelcomesClient{stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+type WelcomeService_SubscribeWelcomesClient interface {
+	Recv() (*WelcomeEvent, error)
+	grpc.ClientStream
+}
+
+type welcomeServiceSubscribeWelcomesClient struct {
+	grpc.ClientStream
+}
+
+func (x *welcomeServiceSubscribeWelcomesClient) Recv() (*WelcomeEvent, error) {
+	m := new(WelcomeEvent)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func (c *welcomeServiceClient) SendWelcomes(ctx context.Context, opts ...grpc.CallOption) (WelcomeService_SendWelcomesClient, error) {
+	stream, err := c.cc.NewStream(ctx, &WelcomeService_ServiceDesc.Streams[1], "/welcome.WelcomeService/SendWelcomes", opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &welcomeServiceSendWelcomesClient{stream}
+	return x, nil
+}
+
+type WelcomeService_SendWelcomesClient interface {
+	Send(*WelcomeRequest) error
+	Recv() (*WelcomeResponse, error)
+	grpc.ClientStream
+}
+
+type welcomeServiceSendWelcomesClient struct {
+	grpc.ClientStream
+}
+
+func (x *welcomeServiceSendWelcomesClient) Send(m *WelcomeRequest) error {
+	return x.ClientStream.SendMsg(m)
+}
+
+func (x *welcomeServiceSendWelcomesClient) Recv() (*WelcomeResponse, error) {
+	m := new(WelcomeResponse)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func (c *welcomeServiceClient) GetWelcomeStats(ctx context.Context, in *GetWelcomeStatsRequest, opts ...grpc.CallOption) (*WelcomeStats, error) {
+	out := new(WelcomeStats)
+	err := c.cc.Invoke(ctx, "/welcome.WelcomeService/GetWelcomeStats", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *welcomeServiceClient) RenderWelcomeCard(ctx context.Context, in *RenderWelcomeCardRequest, opts ...grpc.CallOption) (WelcomeService_RenderWelcomeCardClient, error) {
+	stream, err := c.cc.NewStream(ctx, &WelcomeService_ServiceDesc.Streams[2], "/welcome.WelcomeService/RenderWelcomeCard", opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &welcomeServiceRenderWelcomeCardClient{stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+type WelcomeService_RenderWelcomeCardClient interface {
+	Recv() (*WelcomeCardChunk, error)
+	grpc.ClientStream
+}
+
+type welcomeServiceRenderWelcomeCardClient struct {
+	grpc.ClientStream
+}
+
+func (x *welcomeServiceRenderWelcomeCardClient) Recv() (*WelcomeCardChunk, error) {
+	m := new(WelcomeCardChunk)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func (c *welcomeServiceClient) DownloadWelcomeKit(ctx context.Context, in *DownloadWelcomeKitRequest, opts ...grpc.CallOption) (WelcomeService_DownloadWelcomeKitClient, error) {
+	stream, err := c.cc.NewStream(ctx, &WelcomeService_ServiceDesc.Streams[3], "/welcome.WelcomeService/DownloadWelcomeKit", opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &welcomeServiceDownloadWelcomeKitClient{stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+type WelcomeService_DownloadWelcomeKitClient interface {
+	Recv() (*WelcomeKitChunk, error)
+	grpc.ClientStream
+}
+
+type welcomeServiceDownloadWelcomeKitClient struct {
+	grpc.ClientStream
+}
+
+func (x *welcomeServiceDownloadWelcomeKitClient) Recv() (*WelcomeKitChunk, error) {
+	m := new(WelcomeKitChunk)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
 // WelcomeServiceServer is the server API for WelcomeService service.
 // All implementations must embed UnimplementedWelcomeServiceServer
 // for forward compatibility
 type WelcomeServiceServer interface {
 	// Sends a greeting
 	SendWelcome(context.Context, *WelcomeRequest) (*WelcomeResponse, error)
+	// Streams welcomes as they are sent. Every event carries a sequence
+	// number so that a client whose stream dropped can resume where it left off.
+	// Before any event, the x-welcome-epoch and x-welcome-resume-after
+	// response headers give the position the stream starts after, which is
+	// where to resume if it drops before the first event.
+	SubscribeWelcomes(*SubscribeWelcomesRequest, WelcomeService_SubscribeWelcomesServer) error
+	// Sends a greeting for every name received on the stream
+	SendWelcomes(WelcomeService_SendWelcomesServer) error
+	// Summarizes the welcomes sent in a time range, in whole hours. Unique
+	// and top name counts are approximate.
+	GetWelcomeStats(context.Context, *GetWelcomeStatsRequest) (*WelcomeStats, error)
+	// Renders a shareable welcome card as a PNG image, streamed in chunks.
+	RenderWelcomeCard(*RenderWelcomeCardRequest, WelcomeService_RenderWelcomeCardServer) error
+	// Streams a file of the welcome kit in fixed-size chunks, starting at an
+	// offset to resume an interrupted download. The hex SHA-256 digest of the
+	// whole file is sent in the x-content-sha256 trailer.
+	DownloadWelcomeKit(*DownloadWelcomeKitRequest, WelcomeService_DownloadWelcomeKitServer) error
 	mustEmbedUnimplementedWelcomeServiceServer()
 }
 
@@ -55,6 +225,21 @@ type UnimplementedWelcomeServiceServer struct {
 func (UnimplementedWelcomeServiceServer) SendWelcome(context.Context, *WelcomeRequest) (*WelcomeResponse, error) {
 	return nil, status.Errorf(codes.Unimplemented, "method SendWelcome not implemented")
 }
+func (UnimplementedWelcomeServiceServer) SubscribeWelcomes(*SubscribeWelcomesRequest, WelcomeService_SubscribeWelcomesServer) error {
+	return status.Errorf(codes.Unimplemented, "method SubscribeWelcomes not implemented")
+}
+func (UnimplementedWelcomeServiceServer) SendWelcomes(WelcomeService_SendWelcomesServer) error {
+	return status.Errorf(codes.Unimplemented, "method SendWelcomes not implemented")
+}
+func (UnimplementedWelcomeServiceServer) GetWelcomeStats(context.Context, *GetWelcomeStatsRequest) (*WelcomeStats, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetWelcomeStats not implemented")
+}
+func (UnimplementedWelcomeServiceServer) RenderWelcomeCard(*RenderWelcomeCardRequest, WelcomeService_RenderWelcomeCardServer) error {
+	return status.Errorf(codes.Unimplemented, "method RenderWelcomeCard not implemented")
+}
+func (UnimplementedWelcomeServiceServer) DownloadWelcomeKit(*DownloadWelcomeKitRequest, WelcomeService_DownloadWelcomeKitServer) error {
+	return status.Errorf(codes.Unimplemented, "method DownloadWelcomeKit not implemented")
+}
 func (UnimplementedWelcomeServiceServer) mustEmbedUnimplementedWelcomeServiceServer() {}
 
 // UnsafeWelcomeServiceServer may be embedded to opt out of forward compatibility for this service.
@@ -86,6 +271,113 @@ func _WelcomeService_SendWelcome_Handler(srv interface{}, ctx context.Context, d
 	return interceptor(ctx, in, info, handler)
 }
 
+func _WelcomeService_SubscribeWelcomes_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(SubscribeWelcomesRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(WelcomeServiceServer).SubscribeWelcomes(m, &welcomeServiceSubscribeWelcomesServer{stream})
+}
+
+type WelcomeService_SubscribeWelcomesServer interface {
+	Send(*WelcomeEvent) error
+	grpc.ServerStream
+}
+
+type welcomeServiceSubscribeWelcomesServer struct {
+	grpc.ServerStream
+}
+
+func (x *welcomeServiceSubscribeWelcomesServer) Send(m *WelcomeEvent) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+func _WelcomeService_SendWelcomes_Handler(srv interface{}, stream grpc.ServerStream) error {
+	return srv.(WelcomeServiceServer).SendWelcomes(&welcomeServiceSendWelcomesServer{stream})
+}
+
+type WelcomeService_SendWelcomesServer interface {
+	Send(*WelcomeResponse) error
+	Recv() (*WelcomeRequest, error)
+	grpc.ServerStream
+}
+
+type welcomeServiceSendWelcomesServer struct {
+	grpc.ServerStream
+}
+
+func (x *welcomeServiceSendWelcomesServer) Send(m *WelcomeResponse) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+func (x *welcomeServiceSendWelcomesServer) Recv() (*WelcomeRequest, error) {
+	m := new(WelcomeRequest)
+	if err := x.ServerStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func _WelcomeService_GetWelcomeStats_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GetWelcomeStatsRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(WelcomeServiceServer).GetWelcomeStats(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/welcome.WelcomeService/GetWelcomeStats",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(WelcomeServiceServer).GetWelcomeStats(ctx, req.(*GetWelcomeStatsRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _WelcomeService_RenderWelcomeCard_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(RenderWelcomeCardRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(WelcomeServiceServer).RenderWelcomeCard(m, &welcomeServiceRenderWelcomeCardServer{stream})
+}
+
+type WelcomeService_RenderWelcomeCardServer interface {
+	Send(*WelcomeCardChunk) error
+	grpc.ServerStream
+}
+
+type welcomeServiceRenderWelcomeCardServer struct {
+	grpc.ServerStream
+}
+
+func (x *welcomeServiceRenderWelcomeCardServer) Send(m *WelcomeCardChunk) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+func _WelcomeService_DownloadWelcomeKit_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(DownloadWelcomeKitRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(WelcomeServiceServer).DownloadWelcomeKit(m, &welcomeServiceDownloadWelcomeKitServer{stream})
+}
+
+type WelcomeService_DownloadWelcomeKitServer interface {
+	Send(*WelcomeKitChunk) error
+	grpc.ServerStream
+}
+
+type welcomeServiceDownloadWelcomeKitServer struct {
+	grpc.ServerStream
+}
+
+func (x *welcomeServiceDownloadWelcomeKitServer) Send(m *WelcomeKitChunk) error {
+	return x.ServerStream.SendMsg(m)
+}
+
 // WelcomeService_ServiceDesc is the grpc.ServiceDesc for WelcomeService service.
 // It's only intended for direct use with grpc.RegisterService,
 // and not to be introspected or modified (even as a copy)
@@ -97,7 +389,1357 @@ var WelcomeService_ServiceDesc = grpc.ServiceDesc{
 			MethodName: "SendWelcome",
 			Handler:    _WelcomeService_SendWelcome_Handler,
 		},
+		{
+			MethodName: "GetWelcomeStats",
+			Handler:    _WelcomeService_GetWelcomeStats_Handler,
+		},
+	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "SubscribeWelcomes",
+			Handler:       _WelcomeService_SubscribeWelcomes_Handler,
+			ServerStreams: true,
+		},
+		{
+			StreamName:    "SendWelcomes",
+			Handler:       _WelcomeService_SendWelcomes_Handler,
+			ServerStreams: true,
+			ClientStreams: true,
+		},
+		{
+			StreamName:    "RenderWelcomeCard",
+			Handler:       _WelcomeService_RenderWelcomeCard_Handler,
+			ServerStreams: true,
+		},
+		{
+			StreamName:    "DownloadWelcomeKit",
+			Handler:       _WelcomeService_DownloadWelcomeKit_Handler,
+			ServerStreams: true,
+		},
+	},
+	Metadata: "welcome.proto",
+}
+
+// AdminServiceClient is the client API for AdminService service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+type AdminServiceClient interface {
+	// Replaces the fault injection rules. Fails with FAILED_PRECONDITION
+	// unless fault injection is enabled in the server's configuration.
+	SetFaultRules(ctx context.Context, in *SetFaultRulesRequest, opts ...grpc.CallOption) (*FaultRules, error)
+	// Returns the fault injection rules in effect.
+	GetFaultRules(ctx context.Context, in *GetFaultRulesRequest, opts ...grpc.CallOption) (*FaultRules, error)
+	// Returns the usage of a caller in the current day and month, in UTC,
+	// with its quotas.
+	GetUsage(ctx context.Context, in *GetUsageRequest, opts ...grpc.CallOption) (*GetUsageResponse, error)
+	// Rebuilds the members from their whole event log, ignoring snapshots,
+	// and writes a new snapshot. Calls that change members wait meanwhile.
+	RebuildMembers(ctx context.Context, in *RebuildMembersRequest, opts ...grpc.CallOption) (*RebuildMembersResponse, error)
+	// Streams an archive of the server's state as of one point in time, taken
+	// without stopping other calls. The archive is a gzipped tar of the
+	// stores with a manifest of their SHA-256 digests, for the server's
+	// restore command.
+	Backup(ctx context.Context, in *BackupRequest, opts ...grpc.CallOption) (AdminService_BackupClient, error)
+}
+
+type adminServiceClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewAdminServiceClient(cc grpc.ClientConnInterface) AdminServiceClient {
+	return &adminServiceClient{cc}
+}
+
+func (c *adminServiceClient) SetFaultRules(ctx context.Context, in *SetFaultRulesRequest, opts ...grpc.CallOption) (*FaultRules, error) {
+	out := new(FaultRules)
+	err := c.cc.Invoke(ctx, "/welcome.AdminService/SetFaultRules", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *adminServiceClient) GetFaultRules(ctx context.Context, in *GetFaultRulesRequest, opts ...grpc.CallOption) (*FaultRules, error) {
+	out := new(FaultRules)
+	err := c.cc.Invoke(ctx, "/welcome.AdminService/GetFaultRules", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *adminServiceClient) GetUsage(ctx context.Context, in *GetUsageRequest, opts ...grpc.CallOption) (*GetUsageResponse, error) {
+	out := new(GetUsageResponse)
+	err := c.cc.Invoke(ctx, "/welcome.AdminService/GetUsage", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *adminServiceClient) RebuildMembers(ctx context.Context, in *RebuildMembersRequest, opts ...grpc.CallOption) (*RebuildMembersResponse, error) {
+	out := new(RebuildMembersResponse)
+	err := c.cc.Invoke(ctx, "/welcome.AdminService/RebuildMembers", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *adminServiceClient) Backup(ctx context.Context, in *BackupRequest, opts ...grpc.CallOption) (AdminService_BackupClient, error) {
+	stream, err := c.cc.NewStream(ctx, &AdminService_ServiceDesc.Streams[0], "/welcome.AdminService/Backup", opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &adminServiceBackupClient{stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+type AdminService_BackupClient interface {
+	Recv() (*BackupChunk, error)
+	grpc.ClientStream
+}
+
+type adminServiceBackupClient struct {
+	grpc.ClientStream
+}
+
+func (x *adminServiceBackupClient) Recv() (*BackupChunk, error) {
+	m := new(BackupChunk)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// AdminServiceServer is the server API for AdminService service.
+// All implementations must embed UnimplementedAdminServiceServer
+// for forward compatibility
+type AdminServiceServer interface {
+	// Replaces the fault injection rules. Fails with FAILED_PRECONDITION
+	// unless fault injection is enabled in the server's configuration.
+	SetFaultRules(context.Context, *SetFaultRulesRequest) (*FaultRules, error)
+	// Returns the fault injection rules in effect.
+	GetFaultRules(context.Context, *GetFaultRulesRequest) (*FaultRules, error)
+	// Returns the usage of a caller in the current day and month, in UTC,
+	// with its quotas.
+	GetUsage(context.Context, *GetUsageRequest) (*GetUsageResponse, error)
+	// Rebuilds the members from their whole event log, ignoring snapshots,
+	// and writes a new snapshot. Calls that change members wait meanwhile.
+	RebuildMembers(context.Context, *RebuildMembersRequest) (*RebuildMembersResponse, error)
+	// Streams an archive of the server's state as of one point in time, taken
+	// without stopping other calls. The archive is a gzipped tar of the
+	// stores with a manifest of their SHA-256 digests, for the server's
+	// restore command.
+	Backup(*BackupRequest, AdminService_BackupServer) error
+	mustEmbedUnimplementedAdminServiceServer()
+}
+
+// UnimplementedAdminServiceServer must be embedded to have forward compatible implementations.
+type UnimplementedAdminServiceServer struct {
+}
+
+func (UnimplementedAdminServiceServer) SetFaultRules(context.Context, *SetFaultRulesRequest) (*FaultRules, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method SetFaultRules not implemented")
+}
+func (UnimplementedAdminServiceServer) GetFaultRules(context.Context, *GetFaultRulesRequest) (*FaultRules, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetFaultRules not implemented")
+}
+func (UnimplementedAdminServiceServer) GetUsage(context.Context, *GetUsageRequest) (*GetUsageResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetUsage not implemented")
+}
+func (UnimplementedAdminServiceServer) RebuildMembers(context.Context, *RebuildMembersRequest) (*RebuildMembersResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method RebuildMembers not implemented")
+}
+func (UnimplementedAdminServiceServer) Backup(*BackupRequest, AdminService_BackupServer) error {
+	return status.Errorf(codes.Unimplemented, "method Backup not implemented")
+}
+func (UnimplementedAdminServiceServer) mustEmbedUnimplementedAdminServiceServer() {}
+
+// UnsafeAdminServiceServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to AdminServiceServer will
+// result in compilation errors.
+type UnsafeAdminServiceServer interface {
+	mustEmbedUnimplementedAdminServiceServer()
+}
+
+func RegisterAdminServiceServer(s grpc.ServiceRegistrar, srv AdminServiceServer) {
+	s.RegisterService(&AdminService_ServiceDesc, srv)
+}
+
+func _AdminService_SetFaultRules_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(SetFaultRulesRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(AdminServiceServer).SetFaultRules(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/welcome.AdminService/SetFaultRules",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(AdminServiceServer).SetFaultRules(ctx, req.(*SetFaultRulesRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _AdminService_GetFaultRules_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GetFaultRulesRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(AdminServiceServer).GetFaultRules(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/welcome.AdminService/GetFaultRules",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(AdminServiceServer).GetFaultRules(ctx, req.(*GetFaultRulesRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _AdminService_GetUsage_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GetUsageRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(AdminServiceServer).GetUsage(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/welcome.AdminService/GetUsage",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(AdminServiceServer).GetUsage(ctx, req.(*GetUsageRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _AdminService_RebuildMembers_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(RebuildMembersRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(AdminServiceServer).RebuildMembers(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/welcome.AdminService/RebuildMembers",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(AdminServiceServer).RebuildMembers(ctx, req.(*RebuildMembersRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _AdminService_Backup_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(BackupRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(AdminServiceServer).Backup(m, &adminServiceBackupServer{stream})
+}
+
+type AdminService_BackupServer interface {
+	Send(*BackupChunk) error
+	grpc.ServerStream
+}
+
+type adminServiceBackupServer struct {
+	grpc.ServerStream
+}
+
+func (x *adminServiceBackupServer) Send(m *BackupChunk) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+// AdminService_ServiceDesc is the grpc.ServiceDesc for AdminService service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var AdminService_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "welcome.AdminService",
+	HandlerType: (*AdminServiceServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "SetFaultRules",
+			Handler:    _AdminService_SetFaultRules_Handler,
+		},
+		{
+			MethodName: "GetFaultRules",
+			Handler:    _AdminService_GetFaultRules_Handler,
+		},
+		{
+			MethodName: "GetUsage",
+			Handler:    _AdminService_GetUsage_Handler,
+		},
+		{
+			MethodName: "RebuildMembers",
+			Handler:    _AdminService_RebuildMembers_Handler,
+		},
+	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "Backup",
+			Handler:       _AdminService_Backup_Handler,
+			ServerStreams: true,
+		},
+	},
+	Metadata: "welcome.proto",
+}
+
+// ApiKeyServiceClient is the client API for ApiKeyService service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+type ApiKeyServiceClient interface {
+	// Creates a key. The key itself is only ever returned here; the server
+	// keeps just a hash of it.
+	CreateApiKey(ctx context.Context, in *CreateApiKeyRequest, opts ...grpc.CallOption) (*CreateApiKeyResponse, error)
+	// Lists all keys, including revoked and expired ones.
+	ListApiKeys(ctx context.Context, in *ListApiKeysRequest, opts ...grpc.CallOption) (*ListApiKeysResponse, error)
+	// Revokes a key. It stops working within the auth cache lifetime.
+	RevokeApiKey(ctx context.Context, in *RevokeApiKeyRequest, opts ...grpc.CallOption) (*ApiKey, error)
+	// Creates a new key with the same name and scopes as an existing one. The
+	// old key keeps working for the grace period, then expires.
+	RotateApiKey(ctx context.Context, in *RotateApiKeyRequest, opts ...grpc.CallOption) (*CreateApiKeyResponse, error)
+}
+
+type apiKeyServiceClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewApiKeyServiceClient(cc grpc.ClientConnInterface) ApiKeyServiceClient {
+	return &apiKeyServiceClient{cc}
+}
+
+func (c *apiKeyServiceClient) CreateApiKey(ctx context.Context, in *CreateApiKeyRequest, opts ...grpc.CallOption) (*CreateApiKeyResponse, error) {
+	out := new(CreateApiKeyResponse)
+	err := c.cc.Invoke(ctx, "/welcome.ApiKeyService/CreateApiKey", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *apiKeyServiceClient) ListApiKeys(ctx context.Context, in *ListApiKeysRequest, opts ...grpc.CallOption) (*ListApiKeysResponse, error) {
+	out := new(ListApiKeysResponse)
+	err := c.cc.Invoke(ctx, "/welcome.ApiKeyService/ListApiKeys", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *apiKeyServiceClient) RevokeApiKey(ctx context.Context, in *RevokeApiKeyRequest, opts ...grpc.CallOption) (*ApiKey, error) {
+	out := new(ApiKey)
+	err := c.cc.Invoke(ctx, "/welcome.ApiKeyService/RevokeApiKey", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *apiKeyServiceClient) RotateApiKey(ctx context.Context, in *RotateApiKeyRequest, opts ...grpc.CallOption) (*CreateApiKeyResponse, error) {
+	out := new(CreateApiKeyResponse)
+	err := c.cc.Invoke(ctx, "/welcome.ApiKeyService/RotateApiKey", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// ApiKeyServiceServer is the server API for ApiKeyService service.
+// All implementations must embed UnimplementedApiKeyServiceServer
+// for forward compatibility
+type ApiKeyServiceServer interface {
+	// Creates a key. The key itself is only ever returned here; the server
+	// keeps just a hash of it.
+	CreateApiKey(context.Context, *CreateApiKeyRequest) (*CreateApiKeyResponse, error)
+	// Lists all keys, including revoked and expired ones.
+	ListApiKeys(context.Context, *ListApiKeysRequest) (*ListApiKeysResponse, error)
+	// Revokes a key. It stops working within the auth cache lifetime.
+	RevokeApiKey(context.Context, *RevokeApiKeyRequest) (*ApiKey, error)
+	// Creates a new key with the same name and scopes as an existing one. The
+	// old key keeps working for the grace period, then expires.
+	RotateApiKey(context.Context, *RotateApiKeyRequest) (*CreateApiKeyResponse, error)
+	mustEmbedUnimplementedApiKeyServiceServer()
+}
+
+// UnimplementedApiKeyServiceServer must be embedded to have forward compatible implementations.
+type UnimplementedApiKeyServiceServer struct {
+}
+
+func (UnimplementedApiKeyServiceServer) CreateApiKey(context.Context, *CreateApiKeyRequest) (*CreateApiKeyResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method CreateApiKey not implemented")
+}
+func (UnimplementedApiKeyServiceServer) ListApiKeys(context.Context, *ListApiKeysRequest) (*ListApiKeysResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ListApiKeys not implemented")
+}
+func (UnimplementedApiKeyServiceServer) RevokeApiKey(context.Context, *RevokeApiKeyRequest) (*ApiKey, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method RevokeApiKey not implemented")
+}
+func (UnimplementedApiKeyServiceServer) RotateApiKey(context.Context, *RotateApiKeyRequest) (*CreateApiKeyResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method RotateApiKey not implemented")
+}
+func (UnimplementedApiKeyServiceServer) mustEmbedUnimplementedApiKeyServiceServer() {}
+
+// UnsafeApiKeyServiceServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to ApiKeyServiceServer will
+// result in compilation errors.
+type UnsafeApiKeyServiceServer interface {
+	mustEmbedUnimplementedApiKeyServiceServer()
+}
+
+func RegisterApiKeyServiceServer(s grpc.ServiceRegistrar, srv ApiKeyServiceServer) {
+	s.RegisterService(&ApiKeyService_ServiceDesc, srv)
+}
+
+func _ApiKeyService_CreateApiKey_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(CreateApiKeyRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ApiKeyServiceServer).CreateApiKey(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/welcome.ApiKeyService/CreateApiKey",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ApiKeyServiceServer).CreateApiKey(ctx, req.(*CreateApiKeyRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _ApiKeyService_ListApiKeys_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ListApiKeysRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ApiKeyServiceServer).ListApiKeys(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/welcome.ApiKeyService/ListApiKeys",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ApiKeyServiceServer).ListApiKeys(ctx, req.(*ListApiKeysRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _ApiKeyService_RevokeApiKey_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(RevokeApiKeyRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ApiKeyServiceServer).RevokeApiKey(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/welcome.ApiKeyService/RevokeApiKey",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ApiKeyServiceServer).RevokeApiKey(ctx, req.(*RevokeApiKeyRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _ApiKeyService_RotateApiKey_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(RotateApiKeyRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ApiKeyServiceServer).RotateApiKey(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/welcome.ApiKeyService/RotateApiKey",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ApiKeyServiceServer).RotateApiKey(ctx, req.(*RotateApiKeyRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+// ApiKeyService_ServiceDesc is the grpc.ServiceDesc for ApiKeyService service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var ApiKeyService_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "welcome.ApiKeyService",
+	HandlerType: (*ApiKeyServiceServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "CreateApiKey",
+			Handler:    _ApiKeyService_CreateApiKey_Handler,
+		},
+		{
+			MethodName: "ListApiKeys",
+			Handler:    _ApiKeyService_ListApiKeys_Handler,
+		},
+		{
+			MethodName: "RevokeApiKey",
+			Handler:    _ApiKeyService_RevokeApiKey_Handler,
+		},
+		{
+			MethodName: "RotateApiKey",
+			Handler:    _ApiKeyService_RotateApiKey_Handler,
+		},
+	},
+	Streams:  []grpc.StreamDesc{},
+	Metadata: "welcome.proto",
+}
+
+// MemberServiceClient is the client API for MemberService service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+type MemberServiceClient interface {
+	CreateMember(ctx context.Context, in *CreateMemberRequest, opts ...grpc.CallOption) (*Member, error)
+	GetMember(ctx context.Context, in *GetMemberRequest, opts ...grpc.CallOption) (*Member, error)
+	// Changes the fields of a member that are set in the request.
+	UpdateMember(ctx context.Context, in *UpdateMemberRequest, opts ...grpc.CallOption) (*Member, error)
+	DeleteMember(ctx context.Context, in *DeleteMemberRequest, opts ...grpc.CallOption) (*DeleteMemberResponse, error)
+	// Lists members in the order they joined.
+	ListMembers(ctx context.Context, in *ListMembersRequest, opts ...grpc.CallOption) (*ListMembersResponse, error)
+	// Finds members whose name resembles the query, best matches first.
+	// Matching ignores case and diacritics and tolerates misspellings.
+	SearchMembers(ctx context.Context, in *SearchMembersRequest, opts ...grpc.CallOption) (*SearchMembersResponse, error)
+	// Sets the profile picture of a member. The first message carries the
+	// metadata, the following ones the image data. Fails with
+	// RESOURCE_EXHAUSTED once the data exceeds the server's size limit, and
+	// with INVALID_ARGUMENT if it is not a PNG, JPEG or GIF image of the
+	// declared type.
+	UploadAvatar(ctx context.Context, opts ...grpc.CallOption) (MemberService_UploadAvatarClient, error)
+	// Lists the changes made to a member, oldest first, including those of
+	// deleted members.
+	ListMemberEvents(ctx context.Context, in *ListMemberEventsRequest, opts ...grpc.CallOption) (*ListMemberEventsResponse, error)
+}
+
+type memberServiceClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewMemberServiceClient(cc grpc.ClientConnInterface) MemberServiceClient {
+	return &memberServiceClient{cc}
+}
+
+func (c *memberServiceClient) CreateMember(ctx context.Context, in *CreateMemberRequest, opts ...grpc.CallOption) (*Member, error) {
+	out := new(Member)
+	err := c.cc.Invoke(ctx, "/welcome.MemberService/CreateMember", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *memberServiceClient) GetMember(ctx context.Context, in *GetMemberRequest, opts ...grpc.CallOption) (*Member, error) {
+	out := new(Member)
+	err := c.cc.Invoke(ctx, "/welcome.MemberService/GetMember", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *memberServiceClient) UpdateMember(ctx context.Context, in *UpdateMemberRequest, opts ...grpc.CallOption) (*Member, error) {
+	out := new(Member)
+	err := c.cc.Invoke(ctx, "/welcome.MemberService/UpdateMember", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *memberServiceClient) DeleteMember(ctx context.Context, in *DeleteMemberRequest, opts ...grpc.CallOption) (*DeleteMemberResponse, error) {
+	out := new(DeleteMemberResponse)
+	err := c.cc.Invoke(ctx, "/welcome.MemberService/DeleteMember", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *memberServiceClient) ListMembers(ctx context.Context, in *ListMembersRequest, opts ...grpc.CallOption) (*ListMembersResponse, error) {
+	out := new(ListMembersResponse)
+	err := c.cc.Invoke(ctx, "/welcome.MemberService/ListMembers", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *memberServiceClient) SearchMembers(ctx context.Context, in *SearchMembersRequest, opts ...grpc.CallOption) (*SearchMembersResponse, error) {
+	out := new(SearchMembersResponse)
+	err := c.cc.Invoke(ctx, "/welcome.MemberService/SearchMembers", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *memberServiceClient) UploadAvatar(ctx context.Context, opts ...grpc.CallOption) (MemberService_UploadAvatarClient, error) {
+	stream, err := c.cc.NewStream(ctx, &MemberService_ServiceDesc.Streams[0], "/welcome.MemberService/UploadAvatar", opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &memberServiceUploadAvatarClient{stream}
+	return x, nil
+}
+
+type MemberService_UploadAvatarClient interface {
+	Send(*UploadAvatarRequest) error
+	CloseAndRecv() (*Avatar, error)
+	grpc.ClientStream
+}
+
+type memberServiceUploadAvatarClient struct {
+	grpc.ClientStream
+}
+
+func (x *memberServiceUploadAvatarClient) Send(m *UploadAvatarRequest) error {
+	return x.ClientStream.SendMsg(m)
+}
+
+func (x *memberServiceUploadAvatarClient) CloseAndRecv() (*Avatar, error) {
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	m := new(Avatar)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func (c *memberServiceClient) ListMemberEvents(ctx context.Context, in *ListMemberEventsRequest, opts ...grpc.CallOption) (*ListMemberEventsResponse, error) {
+	out := new(ListMemberEventsResponse)
+	err := c.cc.Invoke(ctx, "/welcome.MemberService/ListMemberEvents", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// MemberServiceServer is the server API for MemberService service.
+// All implementations must embed UnimplementedMemberServiceServer
+// for forward compatibility
+type MemberServiceServer interface {
+	CreateMember(context.Context, *CreateMemberRequest) (*Member, error)
+	GetMember(context.Context, *GetMemberRequest) (*Member, error)
+	// Changes the fields of a member that are set in the request.
+	UpdateMember(context.Context, *UpdateMemberRequest) (*Member, error)
+	DeleteMember(context.Context, *DeleteMemberRequest) (*DeleteMemberResponse, error)
+	// Lists members in the order they joined.
+	ListMembers(context.Context, *ListMembersRequest) (*ListMembersResponse, error)
+	// Finds members whose name resembles the query, best matches first.
+	// Matching ignores case and diacritics and tolerates misspellings.
+	SearchMembers(context.Context, *SearchMembersRequest) (*SearchMembersResponse, error)
+	// Sets the profile picture of a member. The first message carries the
+	// metadata, the following ones the image data. Fails with
+	// RESOURCE_EXHAUSTED once the data exceeds the server's size limit, and
+	// with INVALID_ARGUMENT if it is not a PNG, JPEG or GIF image of the
+	// declared type.
+	UploadAvatar(MemberService_UploadAvatarServer) error
+	// Lists the changes made to a member, oldest first, including those of
+	// deleted members.
+	ListMemberEvents(context.Context, *ListMemberEventsRequest) (*ListMemberEventsResponse, error)
+	mustEmbedUnimplementedMemberServiceServer()
+}
+
+// UnimplementedMemberServiceServer must be embedded to have forward compatible implementations.
+type UnimplementedMemberServiceServer struct {
+}
+
+func (UnimplementedMemberServiceServer) CreateMember(context.Context, *CreateMemberRequest) (*Member, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method CreateMember not implemented")
+}
+func (UnimplementedMemberServiceServer) GetMember(context.Context, *GetMemberRequest) (*Member, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetMember not implemented")
+}
+func (UnimplementedMemberServiceServer) UpdateMember(context.Context, *UpdateMemberRequest) (*Member, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method UpdateMember not implemented")
+}
+func (UnimplementedMemberServiceServer) DeleteMember(context.Context, *DeleteMemberRequest) (*DeleteMemberResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method DeleteMember not implemented")
+}
+func (UnimplementedMemberServiceServer) ListMembers(context.Context, *ListMembersRequest) (*ListMembersResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ListMembers not implemented")
+}
+func (UnimplementedMemberServiceServer) SearchMembers(context.Context, *SearchMembersRequest) (*SearchMembersResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method SearchMembers not implemented")
+}
+func (UnimplementedMemberServiceServer) UploadAvatar(MemberService_UploadAvatarServer) error {
+	return status.Errorf(codes.Unimplemented, "method UploadAvatar not implemented")
+}
+func (UnimplementedMemberServiceServer) ListMemberEvents(context.Context, *ListMemberEventsRequest) (*ListMemberEventsResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ListMemberEvents not implemented")
+}
+func (UnimplementedMemberServiceServer) mustEmbedUnimplementedMemberServiceServer() {}
+
+// UnsafeMemberServiceServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to MemberServiceServer will
+// result in compilation errors.
+type UnsafeMemberServiceServer interface {
+	mustEmbedUnimplementedMemberServiceServer()
+}
+
+func RegisterMemberServiceServer(s grpc.ServiceRegistrar, srv MemberServiceServer) {
+	s.RegisterService(&MemberService_ServiceDesc, srv)
+}
+
+func _MemberService_CreateMember_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(CreateMemberRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(MemberServiceServer).CreateMember(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/welcome.MemberService/CreateMember",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(MemberServiceServer).CreateMember(ctx, req.(*CreateMemberRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _MemberService_GetMember_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GetMemberRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(MemberServiceServer).GetMember(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/welcome.MemberService/GetMember",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(MemberServiceServer).GetMember(ctx, req.(*GetMemberRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _MemberService_UpdateMember_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(UpdateMemberRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(MemberServiceServer).UpdateMember(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/welcome.MemberService/UpdateMember",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(MemberServiceServer).UpdateMember(ctx, req.(*UpdateMemberRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _MemberService_DeleteMember_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(DeleteMemberRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(MemberServiceServer).DeleteMember(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/welcome.MemberService/DeleteMember",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(MemberServiceServer).DeleteMember(ctx, req.(*DeleteMemberRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _MemberService_ListMembers_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ListMembersRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(MemberServiceServer).ListMembers(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/welcome.MemberService/ListMembers",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(MemberServiceServer).ListMembers(ctx, req.(*ListMembersRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _MemberService_SearchMembers_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(SearchMembersRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(MemberServiceServer).SearchMembers(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/welcome.MemberService/SearchMembers",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(MemberServiceServer).SearchMembers(ctx, req.(*SearchMembersRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _MemberService_UploadAvatar_Handler(srv interface{}, stream grpc.ServerStream) error {
+	return srv.(MemberServiceServer).UploadAvatar(&memberServiceUploadAvatarServer{stream})
+}
+
+type MemberService_UploadAvatarServer interface {
+	SendAndClose(*Avatar) error
+	Recv() (*UploadAvatarRequest, error)
+	grpc.ServerStream
+}
+
+type memberServiceUploadAvatarServer struct {
+	grpc.ServerStream
+}
+
+func (x *memberServiceUploadAvatarServer) SendAndClose(m *Avatar) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+func (x *memberServiceUploadAvatarServer) Recv() (*UploadAvatarRequest, error) {
+	m := new(UploadAvatarRequest)
+	if err := x.ServerStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func _MemberService_ListMemberEvents_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ListMemberEventsRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(MemberServiceServer).ListMemberEvents(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/welcome.MemberService/ListMemberEvents",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(MemberServiceServer).ListMemberEvents(ctx, req.(*ListMemberEventsRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+// MemberService_ServiceDesc is the grpc.ServiceDesc for MemberService service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var MemberService_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "welcome.MemberService",
+	HandlerType: (*MemberServiceServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "CreateMember",
+			Handler:    _MemberService_CreateMember_Handler,
+		},
+		{
+			MethodName: "GetMember",
+			Handler:    _MemberService_GetMember_Handler,
+		},
+		{
+			MethodName: "UpdateMember",
+			Handler:    _MemberService_UpdateMember_Handler,
+		},
+		{
+			MethodName: "DeleteMember",
+			Handler:    _MemberService_DeleteMember_Handler,
+		},
+		{
+			MethodName: "ListMembers",
+			Handler:    _MemberService_ListMembers_Handler,
+		},
+		{
+			MethodName: "SearchMembers",
+			Handler:    _MemberService_SearchMembers_Handler,
+		},
+		{
+			MethodName: "ListMemberEvents",
+			Handler:    _MemberService_ListMemberEvents_Handler,
+		},
+	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "UploadAvatar",
+			Handler:       _MemberService_UploadAvatar_Handler,
+			ClientStreams: true,
+		},
+	},
+	Metadata: "welcome.proto",
+}
+
+// PresenceServiceClient is the client API for PresenceService service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+type PresenceServiceClient interface {
+	// Keeps the calling member online while it sends messages, and streams
+	// the presence of the members it follows: their state when they are
+	// followed, then every change. A member is online while one of its
+	// streams has sent a message within the presence TTL, which the server
+	// announces in the x-presence-ttl response header. Fails with
+	// PERMISSION_DENIED if the caller may not act as the member, see
+	// CreateApiKeyRequest.member_id.
+	Presence(ctx context.Context, opts ...grpc.CallOption) (PresenceService_PresenceClient, error)
+	// Lists the members that are online, ordered by ID.
+	ListOnline(ctx context.Context, in *ListOnlineRequest, opts ...grpc.CallOption) (*ListOnlineResponse, error)
+}
+
+type presenceServiceClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewPresenceServiceClient(cc grpc.ClientConnInterface) PresenceServiceClient {
+	return &presenceServiceClient{cc}
+}
+
+func (c *presenceServiceClient) Presence(ctx context.Context, opts ...grpc.CallOption) (PresenceService_PresenceClient, error) {
+	stream, err := c.cc.NewStream(ctx, &PresenceService_ServiceDesc.Streams[0], "/welcome.PresenceService/Presence", opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &presenceServicePresenceClient{stream}
+	return x, nil
+}
+
+type PresenceService_PresenceClient interface {
+	Send(*PresenceHeartbeat) error
+	Recv() (*PresenceChange, error)
+	grpc.ClientStream
+}
+
+type presenceServicePresenceClient struct {
+	grpc.ClientStream
+}
+
+func (x *presenceServicePresenceClient) Send(m *PresenceHeartbeat) error {
+	return x.ClientStream.SendMsg(m)
+}
+
+func (x *presenceServicePresenceClient) Recv() (*PresenceChange, error) {
+	m := new(PresenceChange)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func (c *presenceServiceClient) ListOnline(ctx context.Context, in *ListOnlineRequest, opts ...grpc.CallOption) (*ListOnlineResponse, error) {
+	out := new(ListOnlineResponse)
+	err := c.cc.Invoke(ctx, "/welcome.PresenceService/ListOnline", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// PresenceServiceServer is the server API for PresenceService service.
+// All implementations must embed UnimplementedPresenceServiceServer
+// for forward compatibility
+type PresenceServiceServer interface {
+	// Keeps the calling member online while it sends messages, and streams
+	// the presence of the members it follows: their state when they are
+	// followed, then every change. A member is online while one of its
+	// streams has sent a message within the presence TTL, which the server
+	// announces in the x-presence-ttl response header. Fails with
+	// PERMISSION_DENIED if the caller may not act as the member, see
+	// CreateApiKeyRequest.member_id.
+	Presence(PresenceService_PresenceServer) error
+	// Lists the members that are online, ordered by ID.
+	ListOnline(context.Context, *ListOnlineRequest) (*ListOnlineResponse, error)
+	mustEmbedUnimplementedPresenceServiceServer()
+}
+
+// UnimplementedPresenceServiceServer must be embedded to have forward compatible implementations.
+type UnimplementedPresenceServiceServer struct {
+}
+
+func (UnimplementedPresenceServiceServer) Presence(PresenceService_PresenceServer) error {
+	return status.Errorf(codes.Unimplemented, "method Presence not implemented")
+}
+func (UnimplementedPresenceServiceServer) ListOnline(context.Context, *ListOnlineRequest) (*ListOnlineResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ListOnline not implemented")
+}
+func (UnimplementedPresenceServiceServer) mustEmbedUnimplementedPresenceServiceServer() {}
+
+// UnsafePresenceServiceServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to PresenceServiceServer will
+// result in compilation errors.
+type UnsafePresenceServiceServer interface {
+	mustEmbedUnimplementedPresenceServiceServer()
+}
+
+func RegisterPresenceServiceServer(s grpc.ServiceRegistrar, srv PresenceServiceServer) {
+	s.RegisterService(&PresenceService_ServiceDesc, srv)
+}
+
+func _PresenceService_Presence_Handler(srv interface{}, stream grpc.ServerStream) error {
+	return srv.(PresenceServiceServer).Presence(&presenceServicePresenceServer{stream})
+}
+
+type PresenceService_PresenceServer interface {
+	Send(*PresenceChange) error
+	Recv() (*PresenceHeartbeat, error)
+	grpc.ServerStream
+}
+
+type presenceServicePresenceServer struct {
+	grpc.ServerStream
+}
+
+func (x *presenceServicePresenceServer) Send(m *PresenceChange) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+func (x *presenceServicePresenceServer) Recv() (*PresenceHeartbeat, error) {
+	m := new(PresenceHeartbeat)
+	if err := x.ServerStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func _PresenceService_ListOnline_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ListOnlineRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(PresenceServiceServer).ListOnline(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/welcome.PresenceService/ListOnline",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(PresenceServiceServer).ListOnline(ctx, req.(*ListOnlineRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+// PresenceService_ServiceDesc is the grpc.ServiceDesc for PresenceService service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var PresenceService_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "welcome.PresenceService",
+	HandlerType: (*PresenceServiceServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "ListOnline",
+			Handler:    _PresenceService_ListOnline_Handler,
+		},
+	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "Presence",
+			Handler:       _PresenceService_Presence_Handler,
+			ServerStreams: true,
+			ClientStreams: true,
+		},
+	},
+	Metadata: "welcome.proto",
+}
+
+// CohortServiceClient is the client API for CohortService service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+type CohortServiceClient interface {
+	CreateCohort(ctx context.Context, in *CreateCohortRequest, opts ...grpc.CallOption) (*Cohort, error)
+	ListCohorts(ctx context.Context, in *ListCohortsRequest, opts ...grpc.CallOption) (*ListCohortsResponse, error)
+	// Adds a member to a cohort and welcomes them in its lobby.
+	AddCohortMember(ctx context.Context, in *AddCohortMemberRequest, opts ...grpc.CallOption) (*Cohort, error)
+	// Removes a member from a cohort, ending their lobby streams.
+	RemoveCohortMember(ctx context.Context, in *RemoveCohortMemberRequest, opts ...grpc.CallOption) (*Cohort, error)
+	// Enters the lobby of a cohort as one of its members. The recent events
+	// of the lobby are sent first, marked as backlog, followed by new ones as
+	// they happen. A stream that falls too far behind is ended with
+	// RESOURCE_EXHAUSTED and may join again. Fails with PERMISSION_DENIED if
+	// the caller may not act as the member, as for Presence.
+	JoinLobby(ctx context.Context, in *JoinLobbyRequest, opts ...grpc.CallOption) (CohortService_JoinLobbyClient, error)
+}
+
+type cohortServiceClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewCohortServiceClient(cc grpc.ClientConnInterface) CohortServiceClient {
+	return &cohortServiceClient{cc}
+}
+
+func (c *cohortServiceClient) CreateCohort(ctx context.Context, in *CreateCohortRequest, opts ...grpc.CallOption) (*Cohort, error) {
+	out := new(Cohort)
+	err := c.cc.Invoke(ctx, "/welcome.CohortService/CreateCohort", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *cohortServiceClient) ListCohorts(ctx context.Context, in *ListCohortsRequest, opts ...grpc.CallOption) (*ListCohortsResponse, error) {
+	out := new(ListCohortsResponse)
+	err := c.cc.Invoke(ctx, "/welcome.CohortService/ListCohorts", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *cohortServiceClient) AddCohortMember(ctx context.Context, in *AddCohortMemberRequest, opts ...grpc.CallOption) (*Cohort, error) {
+	out := new(Cohort)
+	err := c.cc.Invoke(ctx, "/welcome.CohortService/AddCohortMember", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *cohortServiceClient) RemoveCohortMember(ctx context.Context, in *RemoveCohortMemberRequest, opts ...grpc.CallOption) (*Cohort, error) {
+	out := new(Cohort)
+	err := c.cc.Invoke(ctx, "/welcome.CohortService/RemoveCohortMember", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *cohortServiceClient) JoinLobby(ctx context.Context, in *JoinLobbyRequest, opts ...grpc.CallOption) (CohortService_JoinLobbyClient, error) {
+	stream, err := c.cc.NewStream(ctx, &CohortService_ServiceDesc.Streams[0], "/welcome.CohortService/JoinLobby", opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &cohortServiceJoinLobbyClient{stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+type CohortService_JoinLobbyClient interface {
+	Recv() (*LobbyEvent, error)
+	grpc.ClientStream
+}
+
+type cohortServiceJoinLobbyClient struct {
+	grpc.ClientStream
+}
+
+func (x *cohortServiceJoinLobbyClient) Recv() (*LobbyEvent, error) {
+	m := new(LobbyEvent)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// CohortServiceServer is the server API for CohortService service.
+// All implementations must embed UnimplementedCohortServiceServer
+// for forward compatibility
+type CohortServiceServer interface {
+	CreateCohort(context.Context, *CreateCohortRequest) (*Cohort, error)
+	ListCohorts(context.Context, *ListCohortsRequest) (*ListCohortsResponse, error)
+	// Adds a member to a cohort and welcomes them in its lobby.
+	AddCohortMember(context.Context, *AddCohortMemberRequest) (*Cohort, error)
+	// Removes a member from a cohort, ending their lobby streams.
+	RemoveCohortMember(context.Context, *RemoveCohortMemberRequest) (*Cohort, error)
+	// Enters the lobby of a cohort as one of its members. The recent events
+	// of the lobby are sent first, marked as backlog, followed by new ones as
+	// they happen. A stream that falls too far behind is ended with
+	// RESOURCE_EXHAUSTED and may join again. Fails with PERMISSION_DENIED if
+	// the caller may not act as the member, as for Presence.
+	JoinLobby(*JoinLobbyRequest, CohortService_JoinLobbyServer) error
+	mustEmbedUnimplementedCohortServiceServer()
+}
+
+// UnimplementedCohortServiceServer must be embedded to have forward compatible implementations.
+type UnimplementedCohortServiceServer struct {
+}
+
+func (UnimplementedCohortServiceServer) CreateCohort(context.Context, *CreateCohortRequest) (*Cohort, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method CreateCohort not implemented")
+}
+func (UnimplementedCohortServiceServer) ListCohorts(context.Context, *ListCohortsRequest) (*ListCohortsResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ListCohorts not implemented")
+}
+func (UnimplementedCohortServiceServer) AddCohortMember(context.Context, *AddCohortMemberRequest) (*Cohort, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method AddCohortMember not implemented")
+}
+func (UnimplementedCohortServiceServer) RemoveCohortMember(context.Context, *RemoveCohortMemberRequest) (*Cohort, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method RemoveCohortMember not implemented")
+}
+func (UnimplementedCohortServiceServer) JoinLobby(*JoinLobbyRequest, CohortService_JoinLobbyServer) error {
+	return status.Errorf(codes.Unimplemented, "method JoinLobby not implemented")
+}
+func (UnimplementedCohortServiceServer) mustEmbedUnimplementedCohortServiceServer() {}
+
+// UnsafeCohortServiceServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to CohortServiceServer will
+// result in compilation errors.
+type UnsafeCohortServiceServer interface {
+	mustEmbedUnimplementedCohortServiceServer()
+}
+
+func RegisterCohortServiceServer(s grpc.ServiceRegistrar, srv CohortServiceServer) {
+	s.RegisterService(&CohortService_ServiceDesc, srv)
+}
+
+func _CohortService_CreateCohort_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(CreateCohortRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(CohortServiceServer).CreateCohort(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/welcome.CohortService/CreateCohort",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(CohortServiceServer).CreateCohort(ctx, req.(*CreateCohortRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _CohortService_ListCohorts_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ListCohortsRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(CohortServiceServer).ListCohorts(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/welcome.CohortService/ListCohorts",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(CohortServiceServer).ListCohorts(ctx, req.(*ListCohortsRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _CohortService_AddCohortMember_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(AddCohortMemberRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(CohortServiceServer).AddCohortMember(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/welcome.CohortService/AddCohortMember",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(CohortServiceServer).AddCohortMember(ctx, req.(*AddCohortMemberRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _CohortService_RemoveCohortMember_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(RemoveCohortMemberRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(CohortServiceServer).RemoveCohortMember(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/welcome.CohortService/RemoveCohortMember",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(CohortServiceServer).RemoveCohortMember(ctx, req.(*RemoveCohortMemberRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _CohortService_JoinLobby_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(JoinLobbyRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(CohortServiceServer).JoinLobby(m, &cohortServiceJoinLobbyServer{stream})
+}
+
+type CohortService_JoinLobbyServer interface {
+	Send(*LobbyEvent) error
+	grpc.ServerStream
+}
+
+type cohortServiceJoinLobbyServer struct {
+	grpc.ServerStream
+}
+
+func (x *cohortServiceJoinLobbyServer) Send(m *LobbyEvent) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+// CohortService_ServiceDesc is the grpc.ServiceDesc for CohortService service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var CohortService_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "welcome.CohortService",
+	HandlerType: (*CohortServiceServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "CreateCohort",
+			Handler:    _CohortService_CreateCohort_Handler,
+		},
+		{
+			MethodName: "ListCohorts",
+			Handler:    _CohortService_ListCohorts_Handler,
+		},
+		{
+			MethodName: "AddCohortMember",
+			Handler:    _CohortService_AddCohortMember_Handler,
+		},
+		{
+			MethodName: "RemoveCohortMember",
+			Handler:    _CohortService_RemoveCohortMember_Handler,
+		},
+	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "JoinLobby",
+			Handler:       _CohortService_JoinLobby_Handler,
+			ServerStreams: true,
+		},
 	},
-	Streams:  []grpc.StreamDesc{},
 	Metadata: "welcome.proto",
 }